@@ -0,0 +1,219 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"urbn.com/recengine/copurchase"
+	"urbn.com/recengine/model"
+)
+
+//build the bought together model of the plain order items and write it as the part-00000 file of modelDir,
+//which the engine loads with -dataLocation modelDir. every order of every model weighs as decay tells by its time
+func buildPlainModel(stream orderStream, modelDir string, options copurchase.Options, decay copurchase.Decay) {
+	builder := copurchase.NewBuilder()
+	readPlainOrders(stream, func(order OrderItems) {
+		builder.AddWeightedOrder(nil, orderProductIds(order), decay.Weight(order.Time))
+	})
+	products := buildProducts(builder, options)
+	if err := writeModel(modelDir, copurchase.NewModelInfo(options, decay, builder.Orders, len(products)), products); err != nil {
+		fmt.Fprintln(os.Stderr, "failed to write the model "+err.Error())
+		os.Exit(1)
+	}
+}
+
+//the variants of the products a variant level model is built of
+const (
+	VARIANT_COLOR = "color"
+	VARIANT_SKU   = "sku"
+)
+
+//build the bought together model of the products and the one of their variants, the colors of the colored order
+//items or the skus of the plain ones, in one run out of the same orders. the product level model is written as
+//the part-00000 file of modelDir and the variant level one as its part-00001 file, one dataset the engine loads
+//with -dataLocation modelDir and falls back from a variant to its product in. every variant is stored under its
+//variant key and recommended with the variants bought together with it, the items without a variant only count
+//in the product level model. the colors are paired by the rule of pairing, the mode of the pairing does not
+//apply as every pair is scored both ways
+func buildVariantModel(stream orderStream, modelDir string, level string, pairing colorPairing, options copurchase.Options, decay copurchase.Decay) {
+	builder := copurchase.NewBuilder()
+	variantBuilder := copurchase.NewBuilder()
+	variants := make(map[string]variant)
+	if level == VARIANT_COLOR && pairing.rule != PAIR_VARIANTS {
+		variantBuilder.PairFilter = func(a string, b string) bool {
+			return pairing.pairs(variants[a].item(), variants[b].item())
+		}
+	}
+	read := readColoredOrders
+	if level == VARIANT_SKU {
+		read = readPlainOrders
+	}
+	read(stream, func(order OrderItems) {
+		weight := decay.Weight(order.Time)
+		builder.AddWeightedOrder(nil, orderProductIds(order), weight)
+		keys := levelVariantKeys(order, level, variants)
+		for i, item := range order.Items {
+			if keys[i] == item.ProductId {
+				keys[i] = ""
+			}
+		}
+		variantBuilder.AddWeightedOrder(nil, keys, weight)
+	})
+	variantProducts := buildProducts(variantBuilder, options)
+	restoreVariants(variantProducts, variants)
+	products := buildProducts(builder, options)
+	if err := writeModel(modelDir, copurchase.NewModelInfo(options, decay, builder.Orders, len(products)+len(variantProducts)), products, variantProducts); err != nil {
+		fmt.Fprintln(os.Stderr, "failed to write the model "+err.Error())
+		os.Exit(1)
+	}
+	fmt.Fprintf(os.Stderr, "%d of the products are %s variants\n", len(variantProducts), level)
+}
+
+//the product and the color or sku of a variant key
+type variant struct {
+	ProductId string `json:"productId"`
+	Color     string `json:"color"`
+	SkuId     string `json:"skuId,omitempty"`
+}
+
+//the item of the product and color of the variant
+func (v variant) item() Item {
+	return Item{ProductId: v.ProductId, Color: v.Color}
+}
+
+//the color variant keys of the items of an order, each noted in variants
+func variantKeys(order OrderItems, variants map[string]variant) []string {
+	return levelVariantKeys(order, VARIANT_COLOR, variants)
+}
+
+//the variant keys of the items of an order by their color or sku, each noted in variants. the key of an item
+//without a variant is its product id
+func levelVariantKeys(order OrderItems, level string, variants map[string]variant) []string {
+	keys := make([]string, len(order.Items))
+	for i, item := range order.Items {
+		v := variant{ProductId: item.ProductId, Color: item.Color}
+		key := model.VariantKey(item.ProductId, item.Color)
+		if level == VARIANT_SKU {
+			v = variant{ProductId: item.ProductId, SkuId: item.SkuId}
+			key = model.VariantKey(item.ProductId, item.SkuId)
+		}
+		keys[i] = key
+		variants[key] = v
+	}
+	return keys
+}
+
+//set the ProductID, Color and SkuID of the products and items built out of variant keys
+func restoreVariants(products []model.Product, variants map[string]variant) {
+	for i := range products {
+		prod := &products[i]
+		v := variants[prod.ProductID]
+		prod.ProductID, prod.Color, prod.SkuID = v.ProductId, v.Color, v.SkuId
+		for j := range prod.BoughtTogetherItems {
+			item := &prod.BoughtTogetherItems[j]
+			v := variants[item.ProductID]
+			item.ProductID, item.Color, item.SkuID = v.ProductId, v.Color, v.SkuId
+		}
+	}
+}
+
+//build the model of the geo aware order items, with the ScoreByRegion of every item scored within the
+//orders of each state, region group or both
+func buildGeoModel(stream orderStream, modelDir string, options copurchase.Options, decay copurchase.Decay, regionLevel string, regionGroupsFile string) {
+	regions := loadRegions(regionLevel, regionGroupsFile)
+	builder := copurchase.NewBuilder()
+	readGeoOrders(stream, func(order OrderItems) {
+		builder.AddWeightedOrder(regions.Of(order.State), orderProductIds(order), decay.Weight(order.Time))
+	})
+	products := buildProducts(builder, options)
+	if err := writeModel(modelDir, copurchase.NewModelInfo(options, decay, builder.Orders, len(products)), products); err != nil {
+		fmt.Fprintln(os.Stderr, "failed to write the model "+err.Error())
+		os.Exit(1)
+	}
+}
+
+//the regions of regionLevel, the groups read from regionGroupsFile
+func loadRegions(regionLevel string, regionGroupsFile string) *copurchase.Regions {
+	groups := make(map[string][]string)
+	if regionGroupsFile != "" {
+		var err error
+		if groups, err = copurchase.LoadRegionGroups(regionGroupsFile); err != nil {
+			fmt.Fprintln(os.Stderr, "failed to read the region groups "+regionGroupsFile+" "+err.Error())
+			os.Exit(1)
+		}
+	}
+	regions, err := copurchase.NewRegions(regionLevel, groups)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(2)
+	}
+	return regions
+}
+
+func orderProductIds(order OrderItems) []string {
+	productIds := make([]string, len(order.Items))
+	for i, item := range order.Items {
+		productIds[i] = item.ProductId
+	}
+	return productIds
+}
+
+func buildProducts(builder *copurchase.Builder, options copurchase.Options) []model.Product {
+	products, err := builder.Products(options)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(2)
+	}
+	return products
+}
+
+//write the parts of the model as the part files of modelDir, along with its info telling how its scores read
+//as the MODEL_INFO file
+func writeModel(modelDir string, info copurchase.ModelInfo, parts ...[]model.Product) error {
+	if err := writeProducts(modelDir, parts...); err != nil {
+		return err
+	}
+	if err := writeJSON(filepath.Join(modelDir, copurchase.MODEL_INFO), info); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "built the model of %d products out of %d orders\n", info.Products, info.Orders)
+	return nil
+}
+
+//write every part of the products as the part file of its index in modelDir, part-00000 first, removing the
+//part files of a former model beyond them
+func writeProducts(modelDir string, parts ...[]model.Product) error {
+	if err := os.MkdirAll(modelDir, 0755); err != nil {
+		return err
+	}
+	written := make(map[string]bool, len(parts))
+	for i, products := range parts {
+		name := filepath.Join(modelDir, fmt.Sprintf("part-%05d", i))
+		written[name] = true
+		fo, err := os.Create(name)
+		if err != nil {
+			return err
+		}
+		if err := copurchase.WriteSparkTuples(fo, products); err != nil {
+			fo.Close()
+			return err
+		}
+		if err := fo.Close(); err != nil {
+			return err
+		}
+	}
+	return removeStaleParts(modelDir, written)
+}
+
+//remove the part files of dir left by an earlier run, those not written by this one
+func removeStaleParts(dir string, written map[string]bool) error {
+	stale, _ := filepath.Glob(filepath.Join(dir, "part-[0-9][0-9][0-9][0-9][0-9]"))
+	for _, name := range stale {
+		if !written[name] {
+			if err := os.Remove(name); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"urbn.com/recengine/copurchase"
+	"urbn.com/recengine/model"
+)
+
+//three colored orders: P1 in BLACK and NUDE bought together, and with P2
+const testColoredExport = `O1	P1	BLACK
+O1	P1	NUDE
+O1	P2	BLACK
+O2	P1	BLACK
+O2	P2	BLACK
+O3	P1	NUDE
+O3	P2	BLACK
+`
+
+//build the variant model of the export with the pairing rule, returning its part files as lines of
+//
+//key:items like P1/BLACK:P2/BLACK,P1/NUDE
+func buildTestVariantModel(t *testing.T, export string, level string, rule string) []string {
+	pairing, err := newColorPairing(rule, PAIRS_SYMMETRIC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	modelDir := t.TempDir()
+	stream := orderStream{fileName: writeExport(t, export), delimiter: '\t', quoting: QUOTING_STRICT}
+	buildVariantModel(stream, modelDir, level, pairing, copurchase.DefaultOptions(), copurchase.Decay{})
+	var parts []string
+	for _, part := range []string{"part-00000", "part-00001"} {
+		contents, err := ioutil.ReadFile(filepath.Join(modelDir, part))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var lines []string
+		for _, line := range strings.Split(strings.TrimSpace(string(contents)), "\n") {
+			if line == "" {
+				continue
+			}
+			key, _, _ := strings.Cut(strings.TrimPrefix(line, "("), ",")
+			lines = append(lines, strings.ReplaceAll(key, model.VARIANT_SEPARATOR, "/"))
+		}
+		parts = append(parts, strings.Join(lines, " "))
+	}
+	return parts
+}
+
+func TestBuildVariantModel(t *testing.T) {
+	for rule, want := range map[string]string{
+		PAIR_VARIANTS:    "P1/BLACK P1/NUDE P2/BLACK",
+		PAIR_PRODUCTS:    "P1/BLACK P1/NUDE P2/BLACK",
+		PAIR_CROSS_COLOR: "P1/BLACK P1/NUDE",
+	} {
+		parts := buildTestVariantModel(t, testColoredExport, VARIANT_COLOR, rule)
+		if parts[0] != "P1 P2" {
+			t.Errorf("%s: the product level model has %s, want P1 P2", rule, parts[0])
+		}
+		if parts[1] != want {
+			t.Errorf("%s: the variant level model has %s, want %s", rule, parts[1], want)
+		}
+	}
+	//an item without a sku only counts in the product level model
+	parts := buildTestVariantModel(t, "O1\tP1\tS1\nO1\tP2\tS2\nO1\tP3\t\n", VARIANT_SKU, PAIR_VARIANTS)
+	if parts[0] != "P1 P2 P3" || parts[1] != "P1/S1 P2/S2" {
+		t.Errorf("the sku model has %q", parts)
+	}
+}
+
+func TestCheckPairing(t *testing.T) {
+	for _, test := range []struct {
+		rule, mode                           string
+		colored, buildModel, popular, update bool
+		ok                                   bool
+	}{
+		{PAIR_VARIANTS, PAIRS_SYMMETRIC, false, true, false, false, true},
+		{PAIR_CROSS_COLOR, PAIRS_ASYMMETRIC, true, false, false, false, true},
+		{PAIR_CROSS_COLOR, PAIRS_SYMMETRIC, true, true, false, false, true},
+		{PAIR_PRODUCTS, PAIRS_SYMMETRIC, false, true, false, false, false},
+		{PAIR_PRODUCTS, PAIRS_SYMMETRIC, true, false, true, false, false},
+		{PAIR_VARIANTS, PAIRS_ASYMMETRIC, true, true, false, false, false},
+		{PAIR_VARIANTS, PAIRS_ASYMMETRIC, true, false, false, true, false},
+	} {
+		pairing, _ := newColorPairing(test.rule, test.mode)
+		if err := checkPairing(pairing, test.colored, test.buildModel, test.popular, test.update); (err == nil) != test.ok {
+			t.Errorf("%+v: %v", test, err)
+		}
+	}
+}
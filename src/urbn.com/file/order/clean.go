@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"urbn.com/recengine/copurchase"
+)
+
+//the rules the cleaning stage drops items and orders by
+const (
+	//the order is on the exclusion list, a returned, cancelled or employee order
+	DROP_EXCLUDED_ORDER = "excluded order"
+	//the product of the item is on the exclusion list, like a gift card
+	DROP_EXCLUDED_PRODUCT = "excluded product"
+	//the sku of the item is already in the order
+	DROP_REPEATED_SKU = "repeated sku"
+	//the order did not ship to a state or region group of the whitelist
+	DROP_REGION = "region not allowed"
+	//fewer items are left in the order than the lower bound, or none at all
+	DROP_TOO_FEW_ITEMS = "too few items"
+	//more items are left in the order than the upper bound
+	DROP_TOO_MANY_ITEMS = "too many items"
+)
+
+//orderCleaner drops the items and orders which are no purchases to learn from before they are paired, and
+//counts what every rule dropped. the item rules apply first, the order size bounds count the items left
+type orderCleaner struct {
+	excludedOrders   map[string]bool
+	excludedProducts map[string]bool
+	dedupSkus        bool
+	//the states and region groups an order must ship to, any when empty
+	allowRegions map[string]bool
+	regions      *copurchase.Regions
+	//the bounds of the number of items of an order, none when 0
+	minItems int
+	maxItems int
+
+	orders        int
+	kept          int
+	droppedOrders map[string]int
+	droppedItems  map[string]int
+}
+
+func newOrderCleaner() *orderCleaner {
+	return &orderCleaner{droppedOrders: make(map[string]int), droppedItems: make(map[string]int)}
+}
+
+//only keep the orders shipped to one of the states or region groups of allow, the states of the groups
+//given by groups. a name of allow which is neither a state code nor a group is an error rather than a
+//region no order ships to
+func (cleaner *orderCleaner) allow(allow []string, groups map[string][]string) error {
+	regions, err := copurchase.NewRegions(copurchase.REGIONS_BOTH, groups)
+	if err != nil {
+		return err
+	}
+	cleaner.regions = regions
+	cleaner.allowRegions = make(map[string]bool)
+	for _, region := range allow {
+		if region = strings.ToUpper(strings.TrimSpace(region)); region == "" {
+			continue
+		} else if !regions.Known(region) {
+			return fmt.Errorf("unknown region %s to allow, expecting a state code or a region group of -regionGroups", region)
+		}
+		cleaner.allowRegions[region] = true
+	}
+	if len(cleaner.allowRegions) == 0 {
+		return errors.New("no region to allow")
+	}
+	return nil
+}
+
+//the order cleaned, false when it is dropped
+func (cleaner *orderCleaner) clean(order OrderItems) (OrderItems, bool) {
+	cleaner.orders++
+	if cleaner.excludedOrders[order.OrderId] {
+		return cleaner.drop(order, DROP_EXCLUDED_ORDER)
+	}
+	if len(cleaner.allowRegions) > 0 && !cleaner.allowed(order.State) {
+		return cleaner.drop(order, DROP_REGION)
+	}
+	items := make([]Item, 0, len(order.Items))
+	skus := make(map[string]bool, len(order.Items))
+	for _, item := range order.Items {
+		if cleaner.excludedProducts[item.ProductId] {
+			cleaner.droppedItems[DROP_EXCLUDED_PRODUCT]++
+			continue
+		}
+		if cleaner.dedupSkus {
+			sku := item.SkuId
+			if sku == "" {
+				sku = item.ProductId + "\t" + item.Color
+			}
+			if skus[sku] {
+				cleaner.droppedItems[DROP_REPEATED_SKU]++
+				continue
+			}
+			skus[sku] = true
+		}
+		items = append(items, item)
+	}
+	order.Items = items
+	if len(items) == 0 || len(items) < cleaner.minItems {
+		return cleaner.drop(order, DROP_TOO_FEW_ITEMS)
+	} else if cleaner.maxItems > 0 && len(items) > cleaner.maxItems {
+		return cleaner.drop(order, DROP_TOO_MANY_ITEMS)
+	}
+	cleaner.kept++
+	return order, true
+}
+
+func (cleaner *orderCleaner) allowed(state string) bool {
+	for _, region := range cleaner.regions.Of(state) {
+		if cleaner.allowRegions[region] {
+			return true
+		}
+	}
+	return false
+}
+
+func (cleaner *orderCleaner) drop(order OrderItems, rule string) (OrderItems, bool) {
+	cleaner.droppedOrders[rule]++
+	return order, false
+}
+
+//hand emit the orders which are kept
+func (cleaner *orderCleaner) wrap(emit func(OrderItems)) func(OrderItems) {
+	return func(order OrderItems) {
+		if order, ok := cleaner.clean(order); ok {
+			emit(order)
+		}
+	}
+}
+
+//print the orders kept and what every rule dropped
+func (cleaner *orderCleaner) report() {
+	fmt.Fprintf(os.Stderr, "kept %d of %d orders\n", cleaner.kept, cleaner.orders)
+	for _, rule := range sortedRules(cleaner.droppedItems) {
+		fmt.Fprintf(os.Stderr, "dropped %d items: %s\n", cleaner.droppedItems[rule], rule)
+	}
+	for _, rule := range sortedRules(cleaner.droppedOrders) {
+		fmt.Fprintf(os.Stderr, "dropped %d orders: %s\n", cleaner.droppedOrders[rule], rule)
+	}
+}
+
+func sortedRules(counts map[string]int) []string {
+	rules := make([]string, 0, len(counts))
+	for rule := range counts {
+		rules = append(rules, rule)
+	}
+	sort.Strings(rules)
+	return rules
+}
+
+//read a list of ids, one per line. blank lines and lines starting with # are skipped
+func loadIdList(fileName string) (map[string]bool, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	ids := make(map[string]bool)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		id := strings.Trim(strings.TrimSpace(scanner.Text()), "\"")
+		if id != "" && !strings.HasPrefix(id, "#") {
+			ids[id] = true
+		}
+	}
+	return ids, scanner.Err()
+}
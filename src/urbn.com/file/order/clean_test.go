@@ -0,0 +1,33 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestCleanerAllow(t *testing.T) {
+	cleaner := newOrderCleaner()
+	if err := cleaner.allow([]string{" pa", "NORTHEAST", ""}, map[string][]string{"NORTHEAST": {"NY"}}); err != nil {
+		t.Fatal(err)
+	}
+	for state, want := range map[string]bool{"PA": true, "ny": true, "TX": false, "": false} {
+		if _, ok := cleaner.clean(OrderItems{OrderId: "O1", State: state, Items: []Item{{ProductId: "P1"}}}); ok != want {
+			t.Errorf("kept the order shipped to %q: %t, want %t", state, ok, want)
+		}
+	}
+	if cleaner.droppedOrders[DROP_REGION] != 2 {
+		t.Errorf("dropped %d orders by region, want 2", cleaner.droppedOrders[DROP_REGION])
+	}
+
+	for _, allow := range [][]string{{"PA", "XX"}, {"SOUTH"}, {"", " "}} {
+		if err := newOrderCleaner().allow(allow, map[string][]string{"NORTHEAST": {"NY"}}); err == nil {
+			t.Errorf("allowed %q", allow)
+		}
+	}
+}
+
+func TestCleanerAllowNeedsState(t *testing.T) {
+	stream := orderStream{fileName: writeExport(t, "O1\tP1\tS1\n"), delimiter: '\t', quoting: QUOTING_STRICT, require: []string{COLUMN_STATE}}
+	if _, err := readTestOrders(t, stream); err == nil {
+		t.Error("read the orders without the state column the allowed regions need")
+	}
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bufio"
+	"container/heap"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+)
+
+//the most run files merged at once, and so open at once. more runs are merged in several passes
+const MAX_MERGE_RUNS = 64
+
+//externalSorter sorts records by their key, sortBuffer records at a time in memory, spilling every sorted run
+//to a file in tmpDir, then merges the runs back, mergeRuns of them at a time. the records of the same key keep
+//the order they were added in. the order export read without -sorted and the records written sorted by
+//product are both sorted by one
+type externalSorter struct {
+	sortBuffer int
+	tmpDir     string
+	mergeRuns  int
+	buffer     []sortedRecord
+	runs       []string
+}
+
+type sortedRecord struct {
+	key   string
+	value []byte
+}
+
+func newExternalSorter(sortBuffer int, tmpDir string) *externalSorter {
+	if sortBuffer <= 0 {
+		sortBuffer = DEFAULT_SORT_BUFFER
+	}
+	return &externalSorter{sortBuffer: sortBuffer, tmpDir: tmpDir, mergeRuns: MAX_MERGE_RUNS}
+}
+
+func (sorter *externalSorter) add(key string, value []byte) error {
+	sorter.buffer = append(sorter.buffer, sortedRecord{key: key, value: value})
+	if len(sorter.buffer) < sorter.sortBuffer {
+		return nil
+	}
+	return sorter.spill()
+}
+
+func (sorter *externalSorter) sort() {
+	sort.SliceStable(sorter.buffer, func(i, j int) bool {
+		return sorter.buffer[i].key < sorter.buffer[j].key
+	})
+}
+
+//sort the buffer and write it to a new run file
+func (sorter *externalSorter) spill() error {
+	sorter.sort()
+	run, err := sorter.writeRun(func(write func(record sortedRecord) error) error {
+		for _, record := range sorter.buffer {
+			if err := write(record); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	sorter.runs = append(sorter.runs, run)
+	sorter.buffer = sorter.buffer[:0]
+	return nil
+}
+
+//write the records handed to write by records to a new run file, the length prefixed key and value of every
+//record, returning its name
+func (sorter *externalSorter) writeRun(records func(write func(record sortedRecord) error) error) (string, error) {
+	fo, err := ioutil.TempFile(sorter.tmpDir, "sort-run-")
+	if err != nil {
+		return "", err
+	}
+	bw := bufio.NewWriter(fo)
+	var encoded []byte
+	err = records(func(record sortedRecord) error {
+		encoded = appendChunk(appendChunk(encoded[:0], []byte(record.key)), record.value)
+		_, err := bw.Write(encoded)
+		return err
+	})
+	if err == nil {
+		err = bw.Flush()
+	}
+	if closeErr := fo.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(fo.Name())
+		return "", err
+	}
+	return fo.Name(), nil
+}
+
+//hand every record to output in key order
+func (sorter *externalSorter) each(output func(key string, value []byte) error) error {
+	if len(sorter.runs) == 0 {
+		sorter.sort()
+		for _, record := range sorter.buffer {
+			if err := output(record.key, record.value); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if len(sorter.buffer) > 0 {
+		if err := sorter.spill(); err != nil {
+			return err
+		}
+	}
+	//merge the first runs into one until they can all be open at once. the merged run takes their place, so
+	//the records of a key still come in the order they were added
+	for len(sorter.runs) > sorter.mergeRuns {
+		merging := sorter.runs[:sorter.mergeRuns]
+		run, err := sorter.writeRun(func(write func(record sortedRecord) error) error {
+			return mergeRunFiles(merging, write)
+		})
+		if err != nil {
+			return err
+		}
+		for _, merged := range merging {
+			os.Remove(merged)
+		}
+		sorter.runs = append([]string{run}, sorter.runs[sorter.mergeRuns:]...)
+	}
+	return mergeRunFiles(sorter.runs, func(record sortedRecord) error {
+		return output(record.key, record.value)
+	})
+}
+
+//remove the run files
+func (sorter *externalSorter) close() {
+	for _, run := range sorter.runs {
+		os.Remove(run)
+	}
+	sorter.runs = nil
+}
+
+//merge the sorted run files by key, handing every record to output. the records of a key read from several
+//runs keep the order of the runs
+func mergeRunFiles(runs []string, output func(record sortedRecord) error) error {
+	heads := make(runHeads, 0, len(runs))
+	for i, run := range runs {
+		file, err := os.Open(run)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		head := &runHead{index: i, reader: bufio.NewReader(file)}
+		if ok, err := head.next(); err != nil {
+			return err
+		} else if ok {
+			heads = append(heads, head)
+		}
+	}
+	heap.Init(&heads)
+	for len(heads) > 0 {
+		head := heads[0]
+		if err := output(head.record); err != nil {
+			return err
+		}
+		if ok, err := head.next(); err != nil {
+			return err
+		} else if ok {
+			heap.Fix(&heads, 0)
+		} else {
+			heap.Pop(&heads)
+		}
+	}
+	return nil
+}
+
+//the next record of a run file
+type runHead struct {
+	index  int
+	reader *bufio.Reader
+	record sortedRecord
+}
+
+//read the next record of the run, false at its end
+func (head *runHead) next() (bool, error) {
+	key, err := readChunk(head.reader)
+	if err == io.EOF {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	value, err := readChunk(head.reader)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return false, err
+	}
+	head.record = sortedRecord{key: string(key), value: value}
+	return true, nil
+}
+
+//append the uvarint length of chunk and chunk to b
+func appendChunk(b []byte, chunk []byte) []byte {
+	b = binary.AppendUvarint(b, uint64(len(chunk)))
+	return append(b, chunk...)
+}
+
+//the readers of chunks, a bufio.Reader of a run file or a bytes.Reader of a value
+type chunkReader interface {
+	io.Reader
+	io.ByteReader
+}
+
+//read a chunk written by appendChunk
+func readChunk(reader chunkReader) ([]byte, error) {
+	length, err := binary.ReadUvarint(reader)
+	if err != nil {
+		return nil, err
+	}
+	chunk := make([]byte, length)
+	if _, err := io.ReadFull(reader, chunk); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return chunk, nil
+}
+
+//the heap of the runs by their next key
+type runHeads []*runHead
+
+func (heads runHeads) Len() int { return len(heads) }
+func (heads runHeads) Less(i, j int) bool {
+	if heads[i].record.key != heads[j].record.key {
+		return heads[i].record.key < heads[j].record.key
+	}
+	return heads[i].index < heads[j].index
+}
+func (heads runHeads) Swap(i, j int)       { heads[i], heads[j] = heads[j], heads[i] }
+func (heads *runHeads) Push(x interface{}) { *heads = append(*heads, x.(*runHead)) }
+func (heads *runHeads) Pop() interface{} {
+	old := *heads
+	head := old[len(old)-1]
+	*heads = old[:len(old)-1]
+	return head
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+)
+
+//sort the records, keys and values like B:1, with a sorter of sortBuffer and mergeRuns in tmpDir
+func externalSort(t *testing.T, records []string, sortBuffer int, mergeRuns int, tmpDir string) string {
+	sorter := newExternalSorter(sortBuffer, tmpDir)
+	sorter.mergeRuns = mergeRuns
+	defer sorter.close()
+	for _, record := range records {
+		key, value, _ := strings.Cut(record, ":")
+		if err := sorter.add(key, []byte(value)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var sorted []string
+	err := sorter.each(func(key string, value []byte) error {
+		sorted = append(sorted, key+":"+string(value))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Join(sorted, " ")
+}
+
+func TestExternalSorter(t *testing.T) {
+	var records []string
+	for i := 0; i < 20; i++ {
+		records = append(records, fmt.Sprintf("%c:%d", 'E'-rune(i%5), i))
+	}
+	want := "A:4 A:9 A:14 A:19 B:3 B:8 B:13 B:18 C:2 C:7 C:12 C:17 D:1 D:6 D:11 D:16 E:0 E:5 E:10 E:15"
+	//in memory, merged in one pass, and in several passes of 2 or 3 runs at a time. the records of a key keep
+	//the order they were added in
+	for _, test := range []struct{ sortBuffer, mergeRuns int }{{0, MAX_MERGE_RUNS}, {3, MAX_MERGE_RUNS}, {1, 2}, {2, 3}, {7, 2}} {
+		tmpDir := t.TempDir()
+		if got := externalSort(t, records, test.sortBuffer, test.mergeRuns, tmpDir); got != want {
+			t.Errorf("sort buffer %d, merging %d runs: %s, want %s", test.sortBuffer, test.mergeRuns, got, want)
+		}
+		if runs, _ := ioutil.ReadDir(tmpDir); len(runs) != 0 {
+			t.Errorf("sort buffer %d, merging %d runs: %d run files left", test.sortBuffer, test.mergeRuns, len(runs))
+		}
+	}
+}
+
+func TestItemEncoding(t *testing.T) {
+	item := Item{ProductId: "37418258", SkuId: "", Color: "OFF\x00WHITE", State: "PA", Time: time.Date(2026, 10, 18, 12, 30, 0, 5, time.UTC)}
+	got, err := decodeItem(encodeItem(item))
+	if err != nil || got != item {
+		t.Errorf("decoded %+v, %v, want %+v", got, err, item)
+	}
+	if _, err := decodeItem(encodeItem(item)[:5]); err == nil {
+		t.Error("decoded a truncated item")
+	}
+}
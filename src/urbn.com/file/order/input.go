@@ -0,0 +1,217 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+//how the fields of an export are quoted
+const (
+	//fields may be enclosed in double quotes, a quote in a quoted field doubled, a stray quote rejects the row
+	QUOTING_STRICT = "strict"
+	//as strict, but a stray quote is read as part of the field
+	QUOTING_LAZY = "lazy"
+	//fields are split on the delimiter as they are, quotes are only trimmed from their ends
+	QUOTING_NONE = "none"
+)
+
+//why a row is rejected
+var (
+	ErrMalformedRow   = errors.New("malformed row")
+	ErrMissingColumns = errors.New("missing columns")
+	ErrEmptyOrderId   = errors.New("empty order id")
+	ErrEmptyProductId = errors.New("empty product id")
+	ErrBadTime        = errors.New("missing or unreadable time")
+)
+
+var ErrUnknownQuoting = errors.New("unknown quoting, expecting strict, lazy or none")
+
+//the delimiter of a -delimiter flag, a single character or tab
+func parseDelimiter(delimiter string) (rune, error) {
+	switch delimiter {
+	case "tab", "\\t":
+		return '\t', nil
+	}
+	r, size := utf8.DecodeRuneInString(delimiter)
+	if size == 0 || size != len(delimiter) || r == '"' || r == '\r' || r == '\n' {
+		return 0, fmt.Errorf("invalid delimiter %q, expecting a single character or tab", delimiter)
+	}
+	return r, nil
+}
+
+//rowReader reads the rows of an export one record at a time
+type rowReader interface {
+	//the next record and the line it starts on, io.EOF at the end. a row which can not be read is returned as
+	//its raw text along with an error wrapping ErrMalformedRow, and reading goes on with the next row
+	Read() ([]string, int, error)
+}
+
+func newRowReader(r io.Reader, delimiter rune, quoting string) (rowReader, error) {
+	switch quoting {
+	case QUOTING_STRICT, QUOTING_LAZY:
+		input := &rawInput{r: r}
+		reader := csv.NewReader(input)
+		reader.Comma = delimiter
+		reader.FieldsPerRecord = -1
+		reader.LazyQuotes = quoting == QUOTING_LAZY
+		reader.ReuseRecord = true
+		return &csvRowReader{reader: reader, input: input}, nil
+	case QUOTING_NONE:
+		return &plainRowReader{reader: bufio.NewReaderSize(r, LINE_BUFFER_SIZE), delimiter: string(delimiter)}, nil
+	}
+	return nil, ErrUnknownQuoting
+}
+
+//csvRowReader reads the delimited rows of an export with fields enclosed in double quotes, which may span lines.
+//the record returned is reused by the next Read
+type csvRowReader struct {
+	reader *csv.Reader
+	input  *rawInput
+}
+
+//the record of the next row and the line it starts on, or its raw text along with the error when it is malformed
+func (reader *csvRowReader) Read() ([]string, int, error) {
+	record, err := reader.reader.Read()
+	raw := reader.input.advance(reader.reader.InputOffset())
+	if err != nil {
+		if parseErr, ok := err.(*csv.ParseError); ok {
+			return []string{strings.Trim(string(raw), "\r\n")}, parseErr.StartLine, fmt.Errorf("%w: %s", ErrMalformedRow, parseErr.Error())
+		}
+		return nil, 0, err
+	}
+	line, _ := reader.reader.FieldPos(0)
+	return record, line, nil
+}
+
+//rawInput keeps the text read through it from the start of the row being read, to give a malformed row as it is
+type rawInput struct {
+	r      io.Reader
+	text   []byte
+	offset int64 //the input offset of the start of text
+	next   int64 //the input offset the row being read starts at
+}
+
+func (input *rawInput) Read(p []byte) (int, error) {
+	//the text of the rows read is dropped here, once per buffer filled rather than once per row
+	n := copy(input.text, input.text[input.next-input.offset:])
+	input.text, input.offset = input.text[:n], input.next
+	n, err := input.r.Read(p)
+	input.text = append(input.text, p[:n]...)
+	return n, err
+}
+
+//the text of the row ending at the input offset end, valid until the next Read
+func (input *rawInput) advance(end int64) []byte {
+	text := input.text[input.next-input.offset : end-input.offset]
+	input.next = end
+	return text
+}
+
+//plainRowReader splits the rows of an export on the delimiter as they are
+type plainRowReader struct {
+	reader    *bufio.Reader
+	delimiter string
+	line      int
+}
+
+func (reader *plainRowReader) Read() ([]string, int, error) {
+	for {
+		line, err := reader.reader.ReadString('\n')
+		if line == "" && err != nil {
+			return nil, 0, err
+		} else if err != nil && err != io.EOF {
+			return nil, 0, err
+		}
+		reader.line++
+		line = strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r")
+		if line == "" {
+			continue
+		}
+		record := strings.Split(line, reader.delimiter)
+		for i := range record {
+			record[i] = strings.Trim(record[i], "\"")
+		}
+		return record, reader.line, nil
+	}
+}
+
+//rowRejects counts the rows read and rejected, and writes every rejected row with its line number and reason
+//to the reject file
+type rowRejects struct {
+	fileName string
+	rows     int
+	rejected int
+	reasons  map[string]int
+	out      *os.File
+	w        *csv.Writer
+}
+
+//the rejects of the export fileName, written to rejectFile unless it is empty
+func newRowRejects(fileName string, rejectFile string) (*rowRejects, error) {
+	rejects := &rowRejects{fileName: fileName, reasons: make(map[string]int)}
+	if rejectFile == "" {
+		return rejects, nil
+	}
+	out, err := os.Create(rejectFile)
+	if err != nil {
+		return nil, err
+	}
+	rejects.out = out
+	rejects.w = csv.NewWriter(out)
+	rejects.w.Comma = '\t'
+	rejects.w.Write([]string{"line", "reason", "row"})
+	return rejects, nil
+}
+
+//count a row read
+func (rejects *rowRejects) read() {
+	rejects.rows++
+}
+
+//count and write a rejected row
+func (rejects *rowRejects) reject(line int, err error, record []string) {
+	rejects.rejected++
+	rejects.reasons[rowReason(err)]++
+	if rejects.w != nil {
+		rejects.w.Write([]string{strconv.Itoa(line), err.Error(), strings.Join(record, "\t")})
+	}
+}
+
+//flush the reject file and print the counts
+func (rejects *rowRejects) close() error {
+	fmt.Fprintf(os.Stderr, "read %d rows of %s, rejected %d\n", rejects.rows, rejects.fileName, rejects.rejected)
+	reasons := make([]string, 0, len(rejects.reasons))
+	for reason := range rejects.reasons {
+		reasons = append(reasons, reason)
+	}
+	sort.Strings(reasons)
+	for _, reason := range reasons {
+		fmt.Fprintf(os.Stderr, "rejected %d rows: %s\n", rejects.reasons[reason], reason)
+	}
+	if rejects.out == nil {
+		return nil
+	}
+	rejects.w.Flush()
+	if err := rejects.w.Error(); err != nil {
+		rejects.out.Close()
+		return err
+	}
+	return rejects.out.Close()
+}
+
+func rowReason(err error) string {
+	for _, sentinel := range []error{ErrMalformedRow, ErrMissingColumns, ErrEmptyOrderId, ErrEmptyProductId, ErrBadTime} {
+		if errors.Is(err, sentinel) {
+			return sentinel.Error()
+		}
+	}
+	return err.Error()
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+//the rows of export read with quoting as line:field,field, a malformed row as line:rejected "raw text"
+func readTestRows(t *testing.T, export string, quoting string) string {
+	reader, err := newRowReader(strings.NewReader(export), '\t', quoting)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rows []string
+	for {
+		record, line, err := reader.Read()
+		if err == io.EOF {
+			break
+		} else if errors.Is(err, ErrMalformedRow) {
+			rows = append(rows, fmt.Sprintf("%d:rejected %q", line, record[0]))
+			continue
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		rows = append(rows, fmt.Sprintf("%d:%s", line, strings.Join(record, ",")))
+	}
+	return strings.Join(rows, " ")
+}
+
+func TestReadQuoted(t *testing.T) {
+	for _, test := range []struct {
+		export  string
+		quoting string
+		want    string
+	}{
+		{"O1\t\"P\t1\"\t\"\"\nO2\t\"say \"\"hi\"\"\"\tS2\n", QUOTING_STRICT, `1:O1,P	1, 2:O2,say "hi",S2`},
+		//a quoted field spans lines, the row after it is numbered by the line it starts on
+		{"O1\t\"P1\nP2\"\tS1\n\nO2\tP3\tS3\n", QUOTING_STRICT, "1:O1,P1\nP2,S1 4:O2,P3,S3"},
+		{"O1\tP1\tS1\nO2\tP\"2\tS2\nO3\tP3\tS3\n", QUOTING_STRICT, `1:O1,P1,S1 2:rejected "O2\tP\"2\tS2" 3:O3,P3,S3`},
+		{"O1\tP1\tS1\nO2\tP\"2\tS2\nO3\tP3\tS3\n", QUOTING_LAZY, `1:O1,P1,S1 2:O2,P"2,S2 3:O3,P3,S3`},
+		//a quote left open reads to the end of the export
+		{"O1\tP1\tS1\nO2\t\"P2\tS2\nO3\tP3\tS3\n", QUOTING_STRICT, `1:O1,P1,S1 2:rejected "O2\t\"P2\tS2\nO3\tP3\tS3"`},
+		{"O1\tP1\tS1\nO2\t\"P2\tS2\nO3\tP3\tS3\n", QUOTING_LAZY, "1:O1,P1,S1 2:O2,P2\tS2\nO3\tP3\tS3\n"},
+	} {
+		if got := readTestRows(t, test.export, test.quoting); got != test.want {
+			t.Errorf("%q %s: %s, want %s", test.export, test.quoting, got, test.want)
+		}
+	}
+}
+
+func TestRejectFile(t *testing.T) {
+	dir := t.TempDir()
+	rejectFile := filepath.Join(dir, "rejects.tsv")
+	stream := orderStream{
+		fileName:  writeExport(t, "O1\tP1\tS1\nO2\tP\"2\tS2\nO3\t\tS3\n"),
+		delimiter: '\t',
+		quoting:   QUOTING_STRICT,
+		rejects:   rejectFile,
+	}
+	got, err := readTestOrders(t, stream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "O1:P1" {
+		t.Errorf("read %s, want O1:P1", got)
+	}
+	fi, err := os.Open(rejectFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fi.Close()
+	reader := csv.NewReader(fi)
+	reader.Comma = '\t'
+	rejects, err := reader.ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rejects) != 3 {
+		t.Fatalf("rejects %q, want a header and 2 rows", rejects)
+	}
+	//the raw text of the malformed row is kept
+	if rejects[1][0] != "2" || !strings.HasPrefix(rejects[1][1], "malformed row: ") || rejects[1][2] != "O2\tP\"2\tS2" {
+		t.Errorf("rejected row 2 as %q", rejects[1])
+	}
+	if rejects[2][0] != "3" || rejects[2][1] != "empty product id" || rejects[2][2] != "O3\t\tS3" {
+		t.Errorf("rejected row 3 as %q", rejects[2])
+	}
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"urbn.com/recengine/loader"
+)
+
+//the formats the flattened orders are written in. the records of every mode are
+//
+//	plain    text, csv: the product ids of the items of an order, in the order of its rows
+//	         jsonl:     {"orderId": "V1", "productIds": ["37418258", "36022135"]}
+//	colored  text, csv: productId, color, otherProductId, otherColor of every two items of an order paired by
+//	                    -pairs, both ways or with -pairMode asymmetric once
+//	         jsonl:     {"productId": "37418258", "color": "BLACK", "otherProductId": "36022135", "otherColor": "NUDE"}
+//	geo      text, csv: the product ids of an order of two or more items followed by the state it shipped to
+//	         jsonl:     {"orderId": "V1", "productIds": ["37418258", "36022135"], "state": "PA"}
+//
+//the records of an order are written together, the orders by order id in byte order or, with -sortOutput
+//product, by the first product id of the record then order id. a -sorted export is written in the order it is
+//read, which -sorted requires to be the byte order of the order ids, so the same export is always written the
+//same, byte for byte, with or without -sorted
+const (
+	//the fields of a record joined by commas, unquoted
+	OUTPUT_TEXT = "text"
+	//the fields of a record as a quoted csv row
+	OUTPUT_CSV = "csv"
+	//every record as a json object on its own line
+	OUTPUT_JSONL = "jsonl"
+
+	//the -out of the standard output
+	OUT_STDOUT = "-"
+)
+
+//the orders of the records written
+const (
+	SORT_ORDER   = "order"
+	SORT_PRODUCT = "product"
+)
+
+var (
+	ErrUnknownOutputFormat = errors.New("unknown output format, expecting text, csv or jsonl")
+	ErrUnknownOutputSort   = errors.New("unknown output sort, expecting order or product")
+)
+
+//recordWriter writes the records of the processing modes to -out in the -format, sorted by -sortOutput
+type recordWriter struct {
+	out      string
+	format   string
+	sorter   *externalSorter
+	dest     io.WriteCloser
+	w        *bufio.Writer
+	encoded  bytes.Buffer
+	csv      *csv.Writer
+	checksum hash.Hash
+	records  int
+	err      error
+}
+
+//open the destination out, a file, - for the standard output or an s3://bucket/key url. sorting by product
+//spills sortBuffer records at a time to tmpDir
+func newRecordWriter(out string, format string, sortBy string, sortBuffer int, tmpDir string) (*recordWriter, error) {
+	if format != OUTPUT_TEXT && format != OUTPUT_CSV && format != OUTPUT_JSONL {
+		return nil, ErrUnknownOutputFormat
+	} else if sortBy != SORT_ORDER && sortBy != SORT_PRODUCT {
+		return nil, ErrUnknownOutputSort
+	}
+	destination, err := openOutput(out)
+	if err != nil {
+		return nil, err
+	}
+	writer := &recordWriter{out: out, format: format, dest: destination, w: bufio.NewWriter(destination),
+		checksum: sha256.New()}
+	writer.csv = csv.NewWriter(&writer.encoded)
+	if sortBy == SORT_PRODUCT {
+		writer.sorter = newExternalSorter(sortBuffer, tmpDir)
+	}
+	return writer, nil
+}
+
+//write a record of the order orderId, its fields in the text and csv formats and record itself in the json
+//lines format. the first error is kept and returned by close
+func (writer *recordWriter) write(orderId string, fields []string, record interface{}) {
+	if writer.err != nil {
+		return
+	}
+	line, err := writer.encode(fields, record)
+	if err != nil {
+		writer.err = err
+	} else if writer.sorter != nil {
+		var first string
+		if len(fields) > 0 {
+			first = fields[0]
+		}
+		writer.err = writer.sorter.add(first+"\x00"+orderId, line)
+	} else {
+		writer.err = writer.output(line)
+	}
+}
+
+//the line of a record in the format
+func (writer *recordWriter) encode(fields []string, record interface{}) ([]byte, error) {
+	writer.encoded.Reset()
+	switch writer.format {
+	case OUTPUT_CSV:
+		writer.csv.Write(fields)
+		writer.csv.Flush()
+		if err := writer.csv.Error(); err != nil {
+			return nil, err
+		}
+	case OUTPUT_JSONL:
+		line, err := json.Marshal(record)
+		if err != nil {
+			return nil, err
+		}
+		writer.encoded.Write(line)
+		writer.encoded.WriteByte('\n')
+	default:
+		writer.encoded.WriteString(strings.Join(fields, ",") + "\n")
+	}
+	return append([]byte(nil), writer.encoded.Bytes()...), nil
+}
+
+func (writer *recordWriter) output(line []byte) error {
+	writer.records++
+	writer.checksum.Write(line)
+	_, err := writer.w.Write(line)
+	return err
+}
+
+//write the sorted records, flush them and close the destination, uploading it when it is on s3
+func (writer *recordWriter) close() error {
+	if writer.sorter != nil {
+		if writer.err == nil {
+			writer.err = writer.sorter.each(func(key string, line []byte) error {
+				return writer.output(line)
+			})
+		}
+		writer.sorter.close()
+	}
+	if writer.err == nil {
+		writer.err = writer.w.Flush()
+	}
+	if err := writer.dest.Close(); writer.err == nil {
+		writer.err = err
+	}
+	if writer.err == nil {
+		fmt.Fprintf(os.Stderr, "wrote %d records to %s, sha256 %x\n", writer.records, writer.out, writer.checksum.Sum(nil))
+	}
+	return writer.err
+}
+
+func openOutput(out string) (io.WriteCloser, error) {
+	if out == "" || out == OUT_STDOUT {
+		return nopWriteCloser{os.Stdout}, nil
+	} else if strings.HasPrefix(out, "s3://") {
+		return newS3Output(out)
+	}
+	return os.Create(out)
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+//s3Output spools the output to a temporary file, put to s3 when it is closed
+type s3Output struct {
+	*os.File
+	bucket string
+	key    string
+}
+
+func newS3Output(url string) (*s3Output, error) {
+	bucket, key := loader.ParseS3Params(url)
+	key = strings.TrimPrefix(key, "/")
+	if bucket == "" || key == "" {
+		return nil, errors.New("expecting s3://bucket/key, got " + url)
+	}
+	spool, err := ioutil.TempFile("", "orders-out-")
+	if err != nil {
+		return nil, err
+	}
+	return &s3Output{File: spool, bucket: bucket, key: key}, nil
+}
+
+func (output *s3Output) Close() error {
+	defer os.Remove(output.Name())
+	defer output.File.Close()
+	if _, err := output.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	svc := s3.New(session.New(), &aws.Config{Region: aws.String("us-east-1")})
+	return loader.PutObject(svc, output.bucket, output.key, output.File)
+}
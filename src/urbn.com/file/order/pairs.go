@@ -0,0 +1,84 @@
+package main
+
+import (
+	"errors"
+)
+
+//which two items of an order of colored items are paired
+const (
+	//items of two different products, in any colors
+	PAIR_PRODUCTS = "products"
+	//items of two different variants, another product or the same product in another color
+	PAIR_VARIANTS = "variants"
+	//items of the same product in two different colors
+	PAIR_CROSS_COLOR = "crossColor"
+)
+
+//how the pairs of two items are written
+const (
+	//both ways, the item with the other and the other with the item
+	PAIRS_SYMMETRIC = "symmetric"
+	//once, the item of the lower product id and color first
+	PAIRS_ASYMMETRIC = "asymmetric"
+)
+
+var (
+	ErrUnknownPairRule = errors.New("unknown pairing rule, expecting products, variants or crossColor")
+	ErrUnknownPairMode = errors.New("unknown pair mode, expecting symmetric or asymmetric")
+)
+
+//colorPairing pairs the colored items of an order
+type colorPairing struct {
+	rule string
+	mode string
+}
+
+func newColorPairing(rule string, mode string) (colorPairing, error) {
+	if rule != PAIR_PRODUCTS && rule != PAIR_VARIANTS && rule != PAIR_CROSS_COLOR {
+		return colorPairing{}, ErrUnknownPairRule
+	} else if mode != PAIRS_SYMMETRIC && mode != PAIRS_ASYMMETRIC {
+		return colorPairing{}, ErrUnknownPairMode
+	}
+	return colorPairing{rule: rule, mode: mode}, nil
+}
+
+//whether the rule pairs a with b
+func (pairing colorPairing) pairs(a Item, b Item) bool {
+	switch pairing.rule {
+	case PAIR_PRODUCTS:
+		return a.ProductId != b.ProductId
+	case PAIR_CROSS_COLOR:
+		return a.ProductId == b.ProductId && a.Color != b.Color
+	}
+	return a.ProductId != b.ProductId || a.Color != b.Color
+}
+
+//call f with every pair of the items. a variant in the order more than once is paired once
+func (pairing colorPairing) each(items []Item, f func(item Item, other Item)) {
+	variants := make([]Item, 0, len(items))
+	seen := make(map[Item]bool, len(items))
+	for _, item := range items {
+		variant := Item{ProductId: item.ProductId, Color: item.Color}
+		if !seen[variant] {
+			seen[variant] = true
+			variants = append(variants, variant)
+		}
+	}
+	for i, item := range variants {
+		for j, other := range variants {
+			if i == j || !pairing.pairs(item, other) {
+				continue
+			} else if pairing.mode == PAIRS_ASYMMETRIC && !lessVariant(item, other) {
+				continue
+			}
+			f(item, other)
+		}
+	}
+}
+
+func lessVariant(a Item, b Item) bool {
+	if a.ProductId != b.ProductId {
+		return a.ProductId < b.ProductId
+	}
+	return a.Color < b.Color
+}
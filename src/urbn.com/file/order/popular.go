@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+	"urbn.com/recengine/copurchase"
+	"urbn.com/recengine/model"
+)
+
+//popularLists builds the popularity lists of the orders of the plain, colored or geo aware items
+type popularLists struct {
+	popularDir string
+	//the trending products are those of the orders of the trendWindow up to the -asOf time, no trending list
+	//is built when it is 0
+	trendWindow time.Duration
+	//the lists are of variants, or scored in the regions of the state of every order when regions is set
+	colored bool
+	regions *copurchase.Regions
+}
+
+//build the top sellers, and the trending products when there is a trend window, of the orders of the stream
+//and write them as the json lines of the part-00000 file of popularDir, which the engine loads with
+//-popularLocation popularDir. every order weighs as decay tells by its time
+func buildPopularLists(stream orderStream, popular popularLists, options copurchase.Options, decay copurchase.Decay) {
+	builder := copurchase.NewBuilder()
+	recent := copurchase.NewBuilder()
+	since := decay.AsOf.Add(-popular.trendWindow)
+	variants := make(map[string]variant)
+	read := readPlainOrders
+	if popular.colored {
+		read = readColoredOrders
+	} else if popular.regions != nil {
+		read = readGeoOrders
+	}
+	read(stream, func(order OrderItems) {
+		var regions []string
+		keys := orderProductIds(order)
+		if popular.colored {
+			keys = variantKeys(order, variants)
+		} else if popular.regions != nil {
+			regions = popular.regions.Of(order.State)
+		}
+		weight := decay.Weight(order.Time)
+		builder.AddWeightedOrder(regions, keys, weight)
+		if popular.trendWindow > 0 && !order.Time.Before(since) {
+			recent.AddWeightedOrder(regions, keys, weight)
+		}
+	})
+
+	lists := []model.PopularList{builder.TopSellers(options)}
+	if popular.trendWindow > 0 {
+		lists = append(lists, builder.Trending(recent, options))
+	}
+	for _, list := range lists {
+		for i := range list.Items {
+			if item := &list.Items[i]; popular.colored {
+				item.ProductID, item.Color = variants[item.ProductID].ProductId, variants[item.ProductID].Color
+			}
+		}
+	}
+	if err := writePopularLists(lists, popular.popularDir); err != nil {
+		fmt.Fprintln(os.Stderr, "failed to write the popularity lists "+err.Error())
+		os.Exit(1)
+	}
+	fmt.Fprintf(os.Stderr, "built %d popularity lists out of %d orders, %d of them recent\n", len(lists), builder.Orders, recent.Orders)
+}
+
+//write the lists as the json lines of the part-00000 file of popularDir, removing the other part files
+func writePopularLists(lists []model.PopularList, popularDir string) error {
+	if err := os.MkdirAll(popularDir, 0755); err != nil {
+		return err
+	}
+	name := filepath.Join(popularDir, "part-00000")
+	fo, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(fo)
+	encoder := json.NewEncoder(w)
+	for _, list := range lists {
+		if err := encoder.Encode(list); err != nil {
+			fo.Close()
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		fo.Close()
+		return err
+	}
+	if err := fo.Close(); err != nil {
+		return err
+	}
+	return removeStaleParts(popularDir, map[string]bool{name: true})
+}
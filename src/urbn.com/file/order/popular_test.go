@@ -0,0 +1,45 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"urbn.com/recengine/copurchase"
+	"urbn.com/recengine/model"
+)
+
+func TestBuildPopularLists(t *testing.T) {
+	modelDir := t.TempDir()
+	popularDir := filepath.Join(modelDir, "popular")
+	if err := os.Mkdir(popularDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{filepath.Join(modelDir, "part-00000"), filepath.Join(popularDir, "part-00001")} {
+		if err := ioutil.WriteFile(name, []byte("earlier\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	stream := orderStream{fileName: writeExport(t, "O1\tP1\tS1\nO1\tP2\tS2\nO2\tP1\tS1\n"), delimiter: '\t', quoting: QUOTING_STRICT}
+	buildPopularLists(stream, popularLists{popularDir: popularDir}, copurchase.DefaultOptions(), copurchase.Decay{})
+
+	contents, err := ioutil.ReadFile(filepath.Join(popularDir, "part-00000"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var list model.PopularList
+	if err := json.Unmarshal(contents, &list); err != nil {
+		t.Fatal(err)
+	}
+	if list.List != model.LIST_TOP_SELLERS || len(list.Items) != 2 || list.Items[0].ProductID != "P1" {
+		t.Errorf("the lists are %s", contents)
+	}
+	//the model is left as it is, the stale part file of the lists is removed
+	if contents, err := ioutil.ReadFile(filepath.Join(modelDir, "part-00000")); err != nil || string(contents) != "earlier\n" {
+		t.Errorf("the model part file is %q, %v", contents, err)
+	}
+	if _, err := os.Stat(filepath.Join(popularDir, "part-00001")); !os.IsNotExist(err) {
+		t.Errorf("the stale part file is left: %v", err)
+	}
+}
@@ -1,16 +1,22 @@
 package main
 import (
+	"errors"
 	"flag"
 	"fmt"
-	"io/ioutil"
 	"strings"
 	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+	"urbn.com/recengine/copurchase"
 )
 
 
 type OrderItems struct {
 	OrderId    string
 	State string
+	//the latest time of its items
+	Time time.Time
 	Items  []Item
 }
 
@@ -19,215 +25,338 @@ type Item struct{
 	SkuId string
 	Color string
 	State string
+	Time time.Time
 }
 func main(){
 	fileName := flag.String("file", "", "file to be parsed")
 	coloredItems:=flag.Bool("coloredItem",false,"colored item indicator")
 	geodItems:=flag.Bool("geoedItem",false,"geo awared item indicator")
+	skuItems:=flag.Bool("skuItem",false,"with -buildModel, build the sku level model of the plain order items along with the product level one")
+	buildModel:=flag.Bool("buildModel",false,"build the bought together model of the plain, with -coloredItem or -skuItem the product and variant level, or with -geoedItem the geo aware, order items instead of flattening them")
+	modelDir:=flag.String("modelDir",".","directory the part files of the model are written to, part-00000 and the part-00001 of the variant level model")
+	options:=copurchase.DefaultOptions()
+	flag.StringVar(&options.Score,"score",options.Score,"the TotalScore of an item: count, confidence (permille) or lift (hundredths)")
+	flag.IntVar(&options.MinCount,"minCount",options.MinCount,"drop the pairs bought together in fewer orders")
+	flag.Float64Var(&options.MinConfidence,"minConfidence",options.MinConfidence,"drop the pairs with a lower confidence, between 0 and 1")
+	flag.Float64Var(&options.MinLift,"minLift",options.MinLift,"drop the pairs with a lower lift")
+	flag.IntVar(&options.MaxItems,"maxItems",options.MaxItems,"the most items kept per product, 0 keeps them all")
+	popular:=flag.Bool("popular",false,"build the top sellers, and with -trendWindow the trending products, of the plain, with -coloredItem the variant level, or with -geoedItem the region scored, order items as the popularity lists the engine serves with -popularLocation popularDir")
+	popularDir:=flag.String("popularDir","","directory the part-00000 file of the -popular lists is written to, modelDir/popular by default")
+	trendWindow:=flag.String("trendWindow","","the orders of the trending products, the last days up to -asOf like 7d or a duration like 72h. needs a time column")
+	update:=flag.Bool("update",false,"apply the orders of the file, a daily delta, to the day counts of -stateDir and rebuild the model of the plain, colored or geo aware items out of them, writing the products which changed to -changesDir")
+	stateDir:=flag.String("stateDir","","directory of the day counts and the manifest of the incrementally updated model")
+	changesDir:=flag.String("changesDir","","directory the part-00000 file of the products added or changed by -update and its diff.json are written to, modelDir/changes by default")
+	window:=flag.Int("window",0,"the days of orders the updated model is built out of, up to the day of -asOf. older days are expired, 0 keeps them all")
+	day:=flag.String("day","","the day, like 2026-10-18, the orders of the delta without a time were placed on, the day of -asOf by default")
+	halfLife:=flag.String("halfLife","","decay the weight of every order of the model by its age, halving it every half life like 30d or 720h. needs a time column, and the count score is in hundredths of an order, as the countScale of the _model.json of the model tells")
+	asOf:=flag.String("asOf","","the time the age of the orders is taken at, the time the model is built by default")
+	timeFormat:=flag.String("timeFormat","","the go layout of the time column, like 2006-01-02 15:04:05. by default RFC 3339, 2006-01-02 15:04:05, 2006-01-02, unix seconds or milliseconds")
+	regionLevel:=flag.String("regions",copurchase.REGIONS_STATE,"the regions of the ScoreByRegion of the geo aware model: state, group or both")
+	regionGroups:=flag.String("regionGroups","","json file of the region groups, {\"NORTHEAST\": [\"PA\", \"NY\"]}")
+	sorted:=flag.Bool("sorted",false,"the file is sorted by order id in byte order, like by LC_ALL=C sort, every order is processed as soon as its rows are read and the memory used does not grow with the file. a file sorted otherwise, like by numeric order ids, fails on its first order out of byte order")
+	sortBuffer:=flag.Int("sortBuffer",DEFAULT_SORT_BUFFER,"rows of an unsorted file, or records of -sortOutput product, sorted in memory before they are spilled to disk")
+	tmpDir:=flag.String("tmpDir","","directory of the sorted runs spilled to disk, the system temp directory by default")
+	columns:=flag.String("columns","","comma separated names of the columns of the file: orderId, productId, sku, color, state, or _ for a column not read. by default the columns of the mode, orderId,productId,sku orderId,productId,color or orderId,state,productId")
+	header:=flag.Bool("header",false,"the first row of the file is a header naming its columns")
+	delimiter:=flag.String("delimiter","tab","the delimiter of the columns of the file, tab or a single character like ,")
+	quoting:=flag.String("quoting",QUOTING_STRICT,"how the fields of the file are quoted: strict, lazy to read stray quotes as part of a field, or none to split on the delimiter as is")
+	rejects:=flag.String("rejects","","file the rows which can not be read are written to, with their line number and reason")
+	excludeProducts:=flag.String("excludeProducts","","file of the product ids dropped from every order, one per line, like gift cards")
+	excludeOrders:=flag.String("excludeOrders","","file of the order ids dropped, one per line, like returned, cancelled or employee orders")
+	dedupSkus:=flag.Bool("dedupSkus",false,"keep one item of every sku repeated in an order, the product and color when there is no sku")
+	allowRegions:=flag.String("allowRegions","","comma separated state codes and region groups of -regionGroups, only the orders shipped to them are kept. needs a state column")
+	minOrderItems:=flag.Int("minOrderItems",0,"drop the orders with fewer items, after the excluded products and repeated skus are dropped")
+	maxOrderItems:=flag.Int("maxOrderItems",0,"drop the orders with more items, 0 keeps them all")
+	out:=flag.String("out",OUT_STDOUT,"where the flattened orders are written: a file, - for the standard output or an s3://bucket/key url")
+	format:=flag.String("format",OUTPUT_TEXT,"the format of the flattened orders: text, csv or jsonl")
+	pairRule:=flag.String("pairs",PAIR_VARIANTS,"which colored items of an order are paired, in the flattened pairs and the variant model of -buildModel: products of two different products, variants of two different products or colors, crossColor of the same product in two colors")
+	pairMode:=flag.String("pairMode",PAIRS_SYMMETRIC,"symmetric to write every pair of colored items both ways, asymmetric to write it once, the lower product id and color first")
+	sortOutput:=flag.String("sortOutput",SORT_ORDER,"the order of the flattened orders: order by order id, or product by the first product id of every record then order id")
 	flag.Parse()
-	if *coloredItems {
-		processColoredItems(*fileName)
+	if *fileName==""{
+		fmt.Fprintln(os.Stderr, "-file is required")
+		flag.Usage()
+		os.Exit(2)
+	}
+	if *skuItems && (!*buildModel || *coloredItems || *geodItems || *update || *popular){
+		fmt.Fprintln(os.Stderr, "-skuItem only builds the model of the plain order items, with -buildModel")
+		os.Exit(2)
+	}
+	comma,err:=parseDelimiter(*delimiter)
+	if err!=nil{
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(2)
+	}
+	stream:=orderStream{fileName:*fileName,delimiter:comma,quoting:*quoting,header:*header,rejects:*rejects,sorted:*sorted,sortBuffer:*sortBuffer,tmpDir:*tmpDir}
+	if *columns!=""{
+		stream.columns=strings.Split(*columns,",")
+	}
+	stream.timeLayout=*timeFormat
+	decay,err:=newDecay(*halfLife,*asOf,*timeFormat)
+	if err!=nil{
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(2)
+	}
+	if decay.HalfLife>0{
+		stream.require=append(stream.require,COLUMN_TIME)
+		options.CountScale=copurchase.DECAYED_COUNT_SCALE
+	}
+	var trend time.Duration
+	if *trendWindow!=""{
+		if trend,err=parseDays(*trendWindow);err!=nil{
+			fmt.Fprintln(os.Stderr, "invalid trend window "+*trendWindow)
+			os.Exit(2)
+		}
+		stream.require=append(stream.require,COLUMN_TIME)
+	}
+	if *allowRegions!=""{
+		//without the state of every order each one would be dropped
+		stream.require=append(stream.require,COLUMN_STATE)
+	}
+	pairing,err:=newColorPairing(*pairRule,*pairMode)
+	if err!=nil{
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(2)
+	}
+	if err:=checkPairing(pairing,*coloredItems,*buildModel,*popular,*update);err!=nil{
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(2)
+	}
+	stream.clean,err=newCleaner(*excludeProducts,*excludeOrders,*dedupSkus,*allowRegions,*regionGroups,*minOrderItems,*maxOrderItems)
+	if err!=nil{
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(2)
+	}
+	if *update {
+		if *stateDir==""{
+			fmt.Fprintln(os.Stderr, "-update needs a -stateDir")
+			os.Exit(2)
+		}
+		modelUpdate:=modelUpdate{stateDir:*stateDir,modelDir:*modelDir,changesDir:*changesDir,window:*window,day:*day,colored:*coloredItems}
+		if modelUpdate.changesDir==""{
+			modelUpdate.changesDir=filepath.Join(*modelDir,"changes")
+		}
+		if *geodItems{
+			modelUpdate.regions=loadRegions(*regionLevel, *regionGroups)
+		}
+		updateModel(stream, modelUpdate, options, decay)
 		return
-	}else if *geodItems {
-		processGeoAwareItems(*fileName)
+	}else if *popular {
+		lists:=popularLists{popularDir:*popularDir,trendWindow:trend,colored:*coloredItems}
+		if lists.popularDir==""{
+			lists.popularDir=filepath.Join(*modelDir,"popular")
+		}
+		if *geodItems{
+			lists.regions=loadRegions(*regionLevel, *regionGroups)
+		}
+		buildPopularLists(stream, lists, options, decay)
+		return
+	}else if *buildModel && *coloredItems {
+		buildVariantModel(stream, *modelDir, VARIANT_COLOR, pairing, options, decay)
+		return
+	}else if *buildModel && *skuItems {
+		buildVariantModel(stream, *modelDir, VARIANT_SKU, pairing, options, decay)
 		return
+	}else if *buildModel && *geodItems {
+		buildGeoModel(stream, *modelDir, options, decay, *regionLevel, *regionGroups)
+		return
+	}else if *buildModel {
+		buildPlainModel(stream, *modelDir, options, decay)
+		return
+	}
+
+	writer,err:=newRecordWriter(*out,*format,*sortOutput,*sortBuffer,*tmpDir)
+	if err!=nil{
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+	if *coloredItems {
+		processColoredItems(stream, pairing, writer)
+	}else if *geodItems {
+		processGeoAwareItems(stream, writer)
 	}else{
-		processPlainProducts(*fileName)
+		processPlainProducts(stream, writer)
+	}
+	if err:=writer.close();err!=nil{
+		fmt.Fprintln(os.Stderr, "failed to write "+*out+" "+err.Error())
+		os.Exit(1)
 	}
 
-	fmt.Println("done")
+	fmt.Fprintln(os.Stderr, "done")
 
 
 }
 
-func processPlainProducts(fileName string){
-	orderItems := make(map[string]OrderItems)
-	contents, _ := ioutil.ReadFile(fileName)
-	strContent := string(contents[:])
-	lines := strings.Split(strContent, "\n")
-	for i:=0;i<len(lines);i++{
-		parts := strings.Split(lines[i],"\t")
-		if len(parts)<3{
-			continue
-		}else{
-			orderId :=strings.Trim(parts[0],"\"");
-			productId :=strings.Trim(parts[1],"\"")
-			skuId :=strings.Trim(parts[2],"\"")
-			item :=Item{ProductId:productId,SkuId:skuId}
+//whether the -pairs and -pairMode of pairing apply: the rule to the flattened pairs and the variant model of the
+//colored items, the mode to the flattened pairs alone, the model scoring every pair both ways
+func checkPairing(pairing colorPairing, colored bool, buildModel bool, popular bool, update bool) error{
+	if pairing.rule!=PAIR_VARIANTS && (!colored || popular || update){
+		return errors.New("-pairs only applies to the flattened pairs and the -buildModel model of the colored items")
+	}else if pairing.mode!=PAIRS_SYMMETRIC && (buildModel || popular || update){
+		return errors.New("-pairMode only applies to the flattened pairs, a model scores every pair both ways")
+	}
+	return nil
+}
 
-			order,ok := orderItems[orderId]
-			if !ok {
-				order=OrderItems{OrderId:orderId}
-			}
-			order.Items=append(order.Items, item)
-			orderItems[order.OrderId]=order
+//the decay of the -halfLife, in days like 30d or as a duration like 720h, at the -asOf time or now
+func newDecay(halfLife string, asOf string, timeFormat string) (copurchase.Decay, error){
+	decay:=copurchase.Decay{AsOf:time.Now().UTC()}
+	if asOf!=""{
+		at,err:=parseTime(asOf,timeFormat)
+		if err!=nil{
+			return decay,fmt.Errorf("invalid -asOf %s",asOf)
 		}
-
-
+		decay.AsOf=at
 	}
-
-	fo, err := os.Create("/Users/tengj6/Downloads/flatOrderItems.txt")
-	if err != nil {
-		panic(err)
+	if halfLife==""{
+		return decay,nil
 	}
-	// close fo on exit and check for its returned error
-	defer func() {
-		if err := fo.Close(); err != nil {
-			panic(err)
-		}
-	}()
-
-
+	var err error
+	if decay.HalfLife,err=parseDays(halfLife);err!=nil{
+		return decay,fmt.Errorf("invalid half life %s",halfLife)
+	}
+	return decay,nil
+}
 
-	for _,val := range orderItems{
-		//fo.WriteString(val.OrderId)
-		//fmt.Printf("%s",val.OrderId)
-		first :=true
-		for _,item :=range val.Items{
-			if !first {
-				fo.WriteString(","+item.ProductId)
-			}else{
-				fo.WriteString(item.ProductId)
-				first=false
-			}
-			//fmt.Printf(",%s",item.ProductId)
+//a positive duration in days like 30d, or in the go syntax like 720h
+func parseDays(value string) (time.Duration, error){
+	var duration time.Duration
+	if strings.HasSuffix(value,"d"){
+		days,err:=strconv.ParseFloat(strings.TrimSuffix(value,"d"),64)
+		if err!=nil{
+			return 0,err
+		}
+		duration=time.Duration(days*float64(24*time.Hour))
+	}else{
+		var err error
+		if duration,err=time.ParseDuration(value);err!=nil{
+			return 0,err
 		}
-		fo.WriteString("\n")
 	}
-
+	if duration<=0{
+		return 0,fmt.Errorf("not a positive duration %s",value)
+	}
+	return duration,nil
 }
-//convert these to
-//"V1017153288"	"37418258"	"BLACK"
-//"V1017153288"	"36022135"	"NUDE"
-//"V1017153288"	"36022135"	"BLACK"
 
-//37418258-BLACK 36022135-NUDE
-//37418258-BLACK 36022135-BLACK
-//36022135-NUDE 36022135-BLACK
-//36022135-NUDE 37418258-BLACK
-//36022135-BLACK 37418258-BLACK
-//36022135-BLACK 36022135-BLACK
-
-
-func processColoredItems(fileName string){
-	orderItems := make(map[string]OrderItems)
-	contents, _ := ioutil.ReadFile(fileName)
-	strContent := string(contents[:])
-	lines := strings.Split(strContent, "\n")
-	for i:=0;i<len(lines);i++{
-		parts := strings.Split(lines[i],"\t")
-		if len(parts)<3{
-			continue
-		}else{
-			orderId :=strings.Trim(parts[0],"\"");
-			productId :=strings.Trim(parts[1],"\"")
-			color :=strings.Trim(parts[2],"\"")
-			item :=Item{ProductId:productId,Color:color}
-
-			order,ok := orderItems[orderId]
-			if !ok {
-				order=OrderItems{OrderId:orderId}
-			}
-			order.Items=append(order.Items, item)
-			orderItems[order.OrderId]=order
+//the cleaning stage of the flags
+func newCleaner(excludeProducts string, excludeOrders string, dedupSkus bool, allowRegions string, regionGroups string, minItems int, maxItems int) (*orderCleaner, error){
+	cleaner:=newOrderCleaner()
+	cleaner.dedupSkus=dedupSkus
+	cleaner.minItems=minItems
+	cleaner.maxItems=maxItems
+	var err error
+	if excludeProducts!=""{
+		if cleaner.excludedProducts,err=loadIdList(excludeProducts);err!=nil{
+			return nil,fmt.Errorf("failed to read the excluded products %s %s",excludeProducts,err.Error())
 		}
-
-
 	}
-
-	fo, err := os.Create("/Users/tengj6/Downloads/flatOrderColoredItems.txt")
-	if err != nil {
-		panic(err)
+	if excludeOrders!=""{
+		if cleaner.excludedOrders,err=loadIdList(excludeOrders);err!=nil{
+			return nil,fmt.Errorf("failed to read the excluded orders %s %s",excludeOrders,err.Error())
+		}
 	}
-	// close fo on exit and check for its returned error
-	defer func() {
-		if err := fo.Close(); err != nil {
-			panic(err)
+	if allowRegions!=""{
+		groups:=make(map[string][]string)
+		if regionGroups!=""{
+			if groups,err=copurchase.LoadRegionGroups(regionGroups);err!=nil{
+				return nil,fmt.Errorf("failed to read the region groups %s %s",regionGroups,err.Error())
+			}
+		}
+		if err=cleaner.allow(strings.Split(allowRegions,","),groups);err!=nil{
+			return nil,err
 		}
-	}()
+	}
+	return cleaner,nil
+}
 
+//an order flattened to its products, in the json lines output
+type flatOrder struct {
+	OrderId string `json:"orderId"`
+	ProductIds []string `json:"productIds"`
+	State string `json:"state,omitempty"`
+}
 
+//a color bought with another product in another color, in the json lines output
+type colorPair struct {
+	ProductId string `json:"productId"`
+	Color string `json:"color"`
+	OtherProductId string `json:"otherProductId"`
+	OtherColor string `json:"otherColor"`
+}
 
-	for _,val := range orderItems{
-		for _,item :=range val.Items{
-			for _,inner:=range val.Items{
-				if item.ProductId!=inner.ProductId && item.Color!=inner.Color{
-					fo.WriteString(item.ProductId+","+item.Color+","+inner.ProductId+"-"+inner.Color+"\n")
-				}
-			}
-		}
-	}
+//write every order as the product ids of its items
+func processPlainProducts(stream orderStream, writer *recordWriter){
+	readPlainOrders(stream, func(val OrderItems){
+		productIds:=orderProductIds(val)
+		writer.write(val.OrderId, productIds, flatOrder{OrderId:val.OrderId,ProductIds:productIds})
+	})
 
 }
 
-func processGeoAwareItems(fileName string){
-	fmt.Println("processing geo awared items.")
-	orderItems := make(map[string]OrderItems)
-	contents, _ := ioutil.ReadFile(fileName)
-	strContent := string(contents[:])
-	lines := strings.Split(strContent, "\n")
-	for i:=0;i<len(lines);i++{
-		parts := strings.Split(lines[i],"\t")
-		if len(parts)<3{
-			continue
-		}else{
-			orderId :=strings.Trim(parts[0],"\"");
-			state :=strings.Trim(parts[1],"\"")
-			productId :=strings.Trim(parts[2],"\"")
+//read the orderId	productId	sku rows of the order export, and hand every order to emit
+func readPlainOrders(stream orderStream, emit func(OrderItems)){
+	readOrders(stream, PLAIN_COLUMNS, []string{COLUMN_ORDER_ID,COLUMN_PRODUCT_ID}, emit)
+}
 
-			item :=Item{ProductId:productId,State:state}
+//pair the colored items of every order by the pairing rule and mode, by default every two variants both ways
+//"V1017153288"	"37418258"	"BLACK"
+//"V1017153288"	"36022135"	"NUDE"
+//"V1017153288"	"36022135"	"BLACK"
 
-			order,ok := orderItems[orderId]
-			if !ok {
-				order=OrderItems{OrderId:orderId,State:state}
-			}
-			order.Items=append(order.Items, item)
-			orderItems[order.OrderId]=order
-		}
+//productId,color,otherProductId,otherColor
+//37418258,BLACK,36022135,NUDE
+//37418258,BLACK,36022135,BLACK
+//36022135,NUDE,37418258,BLACK
+//36022135,NUDE,36022135,BLACK
+//36022135,BLACK,37418258,BLACK
+//36022135,BLACK,36022135,NUDE
 
 
-	}
+func processColoredItems(stream orderStream, pairing colorPairing, writer *recordWriter){
+	readColoredOrders(stream, func(val OrderItems){
+		pairing.each(val.Items, func(item Item, inner Item){
+			writer.write(val.OrderId, []string{item.ProductId,item.Color,inner.ProductId,inner.Color},
+				colorPair{ProductId:item.ProductId,Color:item.Color,OtherProductId:inner.ProductId,OtherColor:inner.Color})
+		})
+	})
 
-	fo, err := os.Create("/Users/tengj6/Downloads/flatOrderGeoAwaredItems.txt")
-	if err != nil {
-		panic(err)
-	}
-	// close fo on exit and check for its returned error
-	defer func() {
-		if err := fo.Close(); err != nil {
-			panic(err)
+}
+
+//write every order of two or more items, all of them shipped to a known state, as their product ids followed by the state
+func processGeoAwareItems(stream orderStream, writer *recordWriter){
+	fmt.Fprintln(os.Stderr, "processing geo awared items.")
+
+	readGeoOrders(stream, func(val OrderItems){
+		if len(val.Items)<2{
+			return
 		}
-	}()
-
-
-
-	for _,val := range orderItems{
-		//fo.WriteString(val.OrderId)
-		//fmt.Printf("%s",val.OrderId)
-		first :=true
-		skipLine:=false
-		if len(val.Items)>=2{
-			for _,item :=range val.Items{
-				if item.State==""{
-					skipLine=true
-					continue
-				}
-				if !first {
-					fo.WriteString(","+item.ProductId)
-				}else{
-					fo.WriteString(item.ProductId)
-					first=false
-				}
-				//fmt.Printf(",%s",item.ProductId)
-			}
-			if !skipLine{
-				fo.WriteString(","+val.State+"\n")
-			}else{
-				skipLine=false
+		productIds:=make([]string,0,len(val.Items))
+		for _,item :=range val.Items{
+			if item.State==""{
+				return
 			}
+			productIds=append(productIds,item.ProductId)
 		}
-	}
+		writer.write(val.OrderId, append(productIds,val.State), flatOrder{OrderId:val.OrderId,ProductIds:productIds,State:val.State})
+	})
 
 }
 
+//read the orderId	productId	color rows of the colored order export, and hand every order to emit
+func readColoredOrders(stream orderStream, emit func(OrderItems)){
+	readOrders(stream, COLORED_COLUMNS, []string{COLUMN_ORDER_ID,COLUMN_PRODUCT_ID,COLUMN_COLOR}, emit)
+}
+
+//read the orderId	state	productId rows of the geo aware order export, and hand every order to emit
+func readGeoOrders(stream orderStream, emit func(OrderItems)){
+	readOrders(stream, GEO_COLUMNS, []string{COLUMN_ORDER_ID,COLUMN_STATE,COLUMN_PRODUCT_ID}, emit)
+}
 
+//stream the orders of the export with the columns of the stream or defaults, exiting when it can not be read
+func readOrders(stream orderStream, defaults []string, required []string, emit func(OrderItems)){
+	if err:=stream.each(defaults, required, emit);err!=nil{
+		fmt.Fprintln(os.Stderr, "failed to read the orders of "+stream.fileName+" "+err.Error())
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//the columns of an order export
+const (
+	COLUMN_ORDER_ID   = "orderId"
+	COLUMN_PRODUCT_ID = "productId"
+	COLUMN_SKU        = "sku"
+	COLUMN_COLOR      = "color"
+	COLUMN_STATE      = "state"
+	//the time the order was placed
+	COLUMN_TIME = "time"
+	//a column which is not read
+	COLUMN_SKIP = "_"
+)
+
+//the columns of the exports of every mode, when neither -columns nor -header tell them
+var (
+	PLAIN_COLUMNS   = []string{COLUMN_ORDER_ID, COLUMN_PRODUCT_ID, COLUMN_SKU}
+	COLORED_COLUMNS = []string{COLUMN_ORDER_ID, COLUMN_PRODUCT_ID, COLUMN_COLOR}
+	GEO_COLUMNS     = []string{COLUMN_ORDER_ID, COLUMN_STATE, COLUMN_PRODUCT_ID}
+)
+
+//the names a header may give a column, compared in lower case without _ and spaces
+var columnAliases = map[string]string{
+	"orderid":   COLUMN_ORDER_ID,
+	"order":     COLUMN_ORDER_ID,
+	"productid": COLUMN_PRODUCT_ID,
+	"product":   COLUMN_PRODUCT_ID,
+	"sku":       COLUMN_SKU,
+	"skuid":     COLUMN_SKU,
+	"color":     COLUMN_COLOR,
+	"colour":    COLUMN_COLOR,
+	"state":     COLUMN_STATE,
+	"shipstate": COLUMN_STATE,
+	"time":      COLUMN_TIME,
+	"timestamp": COLUMN_TIME,
+	"ordertime": COLUMN_TIME,
+	"orderdate": COLUMN_TIME,
+	"date":      COLUMN_TIME,
+	"createdat": COLUMN_TIME,
+}
+
+//the layouts a time column is read in when -timeFormat does not tell it, besides unix seconds and milliseconds.
+//a time without a zone is in UTC
+var TIME_LAYOUTS = []string{time.RFC3339Nano, "2006-01-02 15:04:05", "2006-01-02T15:04:05", "2006-01-02"}
+
+//orderSchema tells which column of a row holds each field of an item
+type orderSchema struct {
+	index map[string]int
+	//the number of columns a row needs
+	width int
+	//the layout of the time column, any of TIME_LAYOUTS when empty
+	timeLayout string
+}
+
+//the schema of the columns named in order, every name one of the COLUMN constants
+func newOrderSchema(columns []string) (*orderSchema, error) {
+	schema := &orderSchema{index: make(map[string]int)}
+	for i, column := range columns {
+		column = strings.TrimSpace(column)
+		if column == COLUMN_SKIP || column == "" {
+			continue
+		}
+		name, ok := columnAliases[normalizeColumn(column)]
+		if !ok {
+			return nil, fmt.Errorf("unknown column %s, expecting orderId, productId, sku, color, state, time or _", column)
+		} else if _, ok := schema.index[name]; ok {
+			return nil, fmt.Errorf("column %s is given twice", name)
+		}
+		schema.index[name] = i
+		schema.width = i + 1
+	}
+	return schema, nil
+}
+
+//the schema of a header row. the columns it does not know are not read
+func headerSchema(header []string) *orderSchema {
+	schema := &orderSchema{index: make(map[string]int)}
+	for i, column := range header {
+		name, ok := columnAliases[normalizeColumn(column)]
+		if !ok {
+			continue
+		} else if _, ok := schema.index[name]; ok {
+			continue
+		}
+		schema.index[name] = i
+		schema.width = i + 1
+	}
+	return schema
+}
+
+//fail unless the schema has every column of names
+func (schema *orderSchema) require(names ...string) error {
+	for _, name := range names {
+		if _, ok := schema.index[name]; !ok {
+			return fmt.Errorf("the %s column is missing", name)
+		}
+	}
+	return nil
+}
+
+//split a record into its order id and item, rejecting a record without every column, an order id or a product id
+func (schema *orderSchema) parse(record []string) (string, Item, error) {
+	if len(record) < schema.width {
+		return "", Item{}, ErrMissingColumns
+	}
+	orderId := schema.field(record, COLUMN_ORDER_ID)
+	item := Item{
+		ProductId: schema.field(record, COLUMN_PRODUCT_ID),
+		SkuId:     schema.field(record, COLUMN_SKU),
+		Color:     schema.field(record, COLUMN_COLOR),
+		State:     schema.field(record, COLUMN_STATE),
+	}
+	if orderId == "" {
+		return "", Item{}, ErrEmptyOrderId
+	} else if item.ProductId == "" {
+		return "", Item{}, ErrEmptyProductId
+	}
+	if _, ok := schema.index[COLUMN_TIME]; ok {
+		at, err := parseTime(schema.field(record, COLUMN_TIME), schema.timeLayout)
+		if err != nil {
+			return "", Item{}, err
+		}
+		item.Time = at
+	}
+	return orderId, item, nil
+}
+
+//read a time in layout, or when it is empty in any of TIME_LAYOUTS or as unix seconds or milliseconds
+func parseTime(value string, layout string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, ErrBadTime
+	} else if layout != "" {
+		at, err := time.Parse(layout, value)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("%w: %s", ErrBadTime, err.Error())
+		}
+		return at, nil
+	}
+	if unix, err := strconv.ParseInt(value, 10, 64); err == nil {
+		if len(value) > 11 {
+			return time.UnixMilli(unix).UTC(), nil
+		}
+		return time.Unix(unix, 0).UTC(), nil
+	}
+	for _, layout := range TIME_LAYOUTS {
+		if at, err := time.Parse(layout, value); err == nil {
+			return at, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("%w: %s", ErrBadTime, value)
+}
+
+func (schema *orderSchema) field(record []string, name string) string {
+	i, ok := schema.index[name]
+	if !ok {
+		return ""
+	}
+	return strings.TrimSpace(record[i])
+}
+
+func normalizeColumn(column string) string {
+	column = strings.ToLower(strings.Trim(strings.TrimSpace(column), "\""))
+	return strings.NewReplacer("_", "", " ", "", "-", "").Replace(column)
+}
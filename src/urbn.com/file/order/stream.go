@@ -0,0 +1,242 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+)
+
+const (
+	//how many rows of an unsorted export, or records sorted by product, are sorted in memory before they are
+	//spilled to a run file on disk
+	DEFAULT_SORT_BUFFER = 1000000
+	LINE_BUFFER_SIZE    = 64 * 1024
+)
+
+//orderStream reads an order export a row at a time and hands every order to emit once all its rows are read.
+//an export sorted by order id is grouped as it is read, any other is sorted by an externalSorter: sortBuffer
+//rows at a time are sorted and spilled to run files in tmpDir, which are merged back by order id.
+//the rows are delimited records quoted as quoting tells. their columns are named by columns, else by the first
+//row when header is set, else they are the default columns of the mode. the rows which can not be read are
+//counted, and written to the rejects file when it is set. the orders are cleaned by clean before they are
+//emitted, when it is set. the times of a time column are read in timeLayout, and the columns of require are
+//needed on top of those of the mode
+type orderStream struct {
+	fileName   string
+	delimiter  rune
+	quoting    string
+	columns    []string
+	header     bool
+	timeLayout string
+	require    []string
+	rejects    string
+	sorted     bool
+	sortBuffer int
+	tmpDir     string
+	clean      *orderCleaner
+}
+
+//a row of the export, the order id it is sorted by and its item
+type orderRow struct {
+	orderId string
+	item    Item
+}
+
+//read every order of the export, in order id order. the rows are read with the schema of the columns of the
+//stream, or defaults, which must have the required columns
+func (stream orderStream) each(defaults []string, required []string, emit func(OrderItems)) error {
+	file, err := os.Open(stream.fileName)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	reader, err := newRowReader(bufio.NewReaderSize(file, LINE_BUFFER_SIZE), stream.delimiter, stream.quoting)
+	if err != nil {
+		return err
+	}
+	var header []string
+	if stream.header {
+		if header, _, err = reader.Read(); err != nil && err != io.EOF {
+			return fmt.Errorf("failed to read the header: %s", err.Error())
+		}
+		//the record is reused by the rows read after it
+		header = append([]string(nil), header...)
+	}
+	var schema *orderSchema
+	if len(stream.columns) > 0 {
+		schema, err = newOrderSchema(stream.columns)
+	} else if stream.header {
+		schema = headerSchema(header)
+	} else {
+		schema, err = newOrderSchema(defaults)
+	}
+	if err != nil {
+		return err
+	} else if err := schema.require(append(required, stream.require...)...); err != nil {
+		return err
+	}
+	schema.timeLayout = stream.timeLayout
+	rejects, err := newRowRejects(stream.fileName, stream.rejects)
+	if err != nil {
+		return err
+	}
+	rows := &rowSource{reader: reader, schema: schema, rejects: rejects}
+	if stream.clean != nil {
+		emit = stream.clean.wrap(emit)
+	}
+	group := &orderGroup{emit: emit}
+	if stream.sorted {
+		err = stream.readSorted(rows, group)
+	} else {
+		err = stream.readUnsorted(rows, group)
+	}
+	if closeErr := rejects.close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	group.close()
+	if stream.clean != nil {
+		stream.clean.report()
+	}
+	return nil
+}
+
+//rowSource reads the rows of the export with the schema, rejecting the rows it can not read
+type rowSource struct {
+	reader  rowReader
+	schema  *orderSchema
+	rejects *rowRejects
+}
+
+//call f with every row read, and the line it starts on
+func (rows *rowSource) each(f func(line int, row orderRow) error) error {
+	for {
+		record, line, err := rows.reader.Read()
+		if err == io.EOF {
+			return nil
+		} else if err != nil && line == 0 {
+			return err
+		}
+		rows.rejects.read()
+		if err != nil {
+			rows.rejects.reject(line, err, record)
+			continue
+		}
+		orderId, item, err := rows.schema.parse(record)
+		if err != nil {
+			rows.rejects.reject(line, err, record)
+			continue
+		}
+		if err := f(line, orderRow{orderId: orderId, item: item}); err != nil {
+			return err
+		}
+	}
+}
+
+//group the rows of an export sorted by order id in byte order, the order of LC_ALL=C sort and of the external
+//sorter, failing on the first row whose order id is lower than the one of the row before. only that id is kept,
+//so reading takes the same memory however long the export is
+func (stream orderStream) readSorted(rows *rowSource, group *orderGroup) error {
+	previous, started := "", false
+	return rows.each(func(line int, row orderRow) error {
+		if started && row.orderId < previous {
+			return fmt.Errorf("%s is not sorted by order id in byte order, order %s on line %d comes after order %s, read it without -sorted",
+				stream.fileName, row.orderId, line, previous)
+		}
+		previous, started = row.orderId, true
+		group.add(row.orderId, row.item)
+		return nil
+	})
+}
+
+//sort the rows of the export by order id with an external sorter, sortBuffer rows at a time in memory
+func (stream orderStream) readUnsorted(rows *rowSource, group *orderGroup) error {
+	sorter := newExternalSorter(stream.sortBuffer, stream.tmpDir)
+	defer sorter.close()
+	err := rows.each(func(line int, row orderRow) error {
+		return sorter.add(row.orderId, encodeItem(row.item))
+	})
+	if err != nil {
+		return err
+	}
+	return sorter.each(func(orderId string, value []byte) error {
+		item, err := decodeItem(value)
+		if err != nil {
+			return err
+		}
+		group.add(orderId, item)
+		return nil
+	})
+}
+
+//the fields of an item as the value of a sorted record, its time in unix nanoseconds
+func encodeItem(item Item) []byte {
+	var at string
+	if !item.Time.IsZero() {
+		at = strconv.FormatInt(item.Time.UnixNano(), 10)
+	}
+	var value []byte
+	for _, field := range []string{item.ProductId, item.SkuId, item.Color, item.State, at} {
+		value = appendChunk(value, []byte(field))
+	}
+	return value
+}
+
+//the item of the value of a sorted record
+func decodeItem(value []byte) (Item, error) {
+	reader := bytes.NewReader(value)
+	var fields [5]string
+	for i := range fields {
+		field, err := readChunk(reader)
+		if err != nil {
+			return Item{}, err
+		}
+		fields[i] = string(field)
+	}
+	item := Item{ProductId: fields[0], SkuId: fields[1], Color: fields[2], State: fields[3]}
+	if fields[4] != "" {
+		at, err := strconv.ParseInt(fields[4], 10, 64)
+		if err != nil {
+			return Item{}, err
+		}
+		item.Time = time.Unix(0, at).UTC()
+	}
+	return item, nil
+}
+
+//orderGroup gathers the items of consecutive rows of the same order, and emits the order when the next
+//one starts
+type orderGroup struct {
+	emit    func(OrderItems)
+	current OrderItems
+	open    bool
+}
+
+func (group *orderGroup) add(orderId string, item Item) {
+	if group.open && group.current.OrderId != orderId {
+		group.close()
+	}
+	if !group.open {
+		group.current = OrderItems{OrderId: orderId, State: item.State}
+		group.open = true
+	}
+	if item.Time.After(group.current.Time) {
+		group.current.Time = item.Time
+	}
+	group.current.Items = append(group.current.Items, item)
+}
+
+//emit the order being gathered
+func (group *orderGroup) close() {
+	if group.open {
+		group.emit(group.current)
+		group.current = OrderItems{}
+		group.open = false
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+//an unsorted export of five orders, O2 and O3 with rows apart
+const testExport = `O3	P1	S1
+O2	P2	S2
+O1	P1	S1
+O3	P2	S2
+O2	P3	S3
+O5	P4	S4
+O4	P1	S1
+O3	P3	S3
+`
+
+//write contents as an export in a test directory
+func writeExport(t *testing.T, contents string) string {
+	fileName := filepath.Join(t.TempDir(), "orders.tsv")
+	if err := ioutil.WriteFile(fileName, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return fileName
+}
+
+//the orders of the stream as O1:P1,P2 in the order they are emitted
+func readTestOrders(t *testing.T, stream orderStream) (string, error) {
+	var orders []string
+	err := stream.each(PLAIN_COLUMNS, []string{COLUMN_ORDER_ID, COLUMN_PRODUCT_ID}, func(order OrderItems) {
+		var productIds []string
+		for _, item := range order.Items {
+			productIds = append(productIds, item.ProductId)
+		}
+		orders = append(orders, order.OrderId+":"+strings.Join(productIds, ","))
+	})
+	return strings.Join(orders, " "), err
+}
+
+func TestReadUnsorted(t *testing.T) {
+	want := "O1:P1 O2:P2,P3 O3:P1,P2,P3 O4:P1 O5:P4"
+	//sorted in memory, then spilled in runs of 3, 2 and 1 rows merged back, an order spread across the runs
+	//keeping the order its rows were read in
+	for _, sortBuffer := range []int{0, 3, 2, 1} {
+		tmpDir := t.TempDir()
+		stream := orderStream{fileName: writeExport(t, testExport), delimiter: '\t', quoting: QUOTING_STRICT, sortBuffer: sortBuffer, tmpDir: tmpDir}
+		got, err := readTestOrders(t, stream)
+		if err != nil {
+			t.Fatalf("sort buffer %d: %s", sortBuffer, err.Error())
+		}
+		if got != want {
+			t.Errorf("sort buffer %d: %s, want %s", sortBuffer, got, want)
+		}
+		if runs, _ := ioutil.ReadDir(tmpDir); len(runs) != 0 {
+			t.Errorf("sort buffer %d: %d run files left", sortBuffer, len(runs))
+		}
+	}
+}
+
+func TestReadSorted(t *testing.T) {
+	//in byte order, 10 before 9
+	export := "10\tP3\t\n10\tP1\t\n11\tP2\t\n9\tP1\t\n9\tP2\t\n"
+	stream := orderStream{fileName: writeExport(t, export), delimiter: '\t', quoting: QUOTING_STRICT, sorted: true}
+	got, err := readTestOrders(t, stream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "10:P3,P1 11:P2 9:P1,P2"; got != want {
+		t.Errorf("%s, want %s", got, want)
+	}
+
+	for export, want := range map[string]string{
+		//grouped, but sorted by numeric order id
+		"9\tP1\t\n9\tP2\t\n10\tP3\t\n": "order 10 on line 3 comes after order 9",
+		//the rows of O3 apart
+		testExport: "order O2 on line 2 comes after order O3",
+	} {
+		stream.fileName = writeExport(t, export)
+		if _, err := readTestOrders(t, stream); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("read %q: %v, want %s", export, err, want)
+		}
+	}
+}
+
+func TestSpillRoundTrip(t *testing.T) {
+	stream := orderStream{fileName: writeExport(t, "O1\t37418258\tS,1\nO1\tP\"2\t\n"), delimiter: '\t', quoting: QUOTING_LAZY, sortBuffer: 1, tmpDir: t.TempDir()}
+	var items []Item
+	err := stream.each(PLAIN_COLUMNS, nil, func(order OrderItems) {
+		items = append(items, order.Items...)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 2 || items[0].ProductId != "37418258" || items[0].SkuId != "S,1" || items[1].ProductId != "P\"2" {
+		t.Errorf("the spilled items came back as %+v", items)
+	}
+}
+
+func TestReadMissingFile(t *testing.T) {
+	stream := orderStream{fileName: filepath.Join(t.TempDir(), "missing.tsv"), delimiter: '\t', quoting: QUOTING_STRICT}
+	if _, err := readTestOrders(t, stream); !os.IsNotExist(err) {
+		t.Errorf("read a missing export: %v", err)
+	}
+}
@@ -0,0 +1,412 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+	"urbn.com/recengine/copurchase"
+	"urbn.com/recengine/model"
+)
+
+//the files of the state directory of an incrementally updated model
+const (
+	//the deltas applied and the records of the last model built
+	STATE_MANIFEST = "manifest.json"
+	//the counts of the orders of every day, days/2026-10-18.json
+	STATE_DAYS = "days"
+	//the day files of an update written before its manifest, moved into days once the manifest records them
+	STATE_STAGED = "staged"
+	DAY_LAYOUT   = "2006-01-02"
+	//the diff of the last update, next to the part-00000 file of the changed products
+	CHANGES_DIFF = "diff.json"
+)
+
+//the modes of the model of a state directory, the orders of one mode can not be counted in the state of another
+const (
+	MODE_PLAIN   = "plain"
+	MODE_COLORED = "colored"
+	MODE_GEO     = "geo"
+)
+
+//modelUpdate applies a delta file of orders to the day counts kept in stateDir, expires the days out of the
+//window and rebuilds the model out of the days left into modelDir, and the products which changed into
+//changesDir. the orders without a time are counted in day.
+//the engine does not read changesDir, it loads the whole model of modelDir: the changed products and their
+//diff are for the jobs which publish them elsewhere, a cache invalidation or a dynamo db upsert.
+//an update is committed by its manifest: the day files are staged and only moved into place, and the expired
+//ones removed, once the manifest recording the delta is written. an update failing before leaves the state as
+//it was, one failing after is finished by the next update
+type modelUpdate struct {
+	stateDir   string
+	modelDir   string
+	changesDir string
+	//the days kept up to the day of the -asOf time, all of them when 0
+	window int
+	day    string
+	//the orders are counted by variant, or in the regions of their state when regions is set
+	colored bool
+	regions *copurchase.Regions
+}
+
+//the manifest of the state directory
+type modelState struct {
+	//the mode the model is built in, plain, colored or geo
+	Mode    string         `json:"mode"`
+	Applied []appliedDelta `json:"applied"`
+	//the sha256 of the record of every product of the last model, by its key
+	Products map[string]string `json:"products"`
+	//the product and color of the variant keys of a colored model
+	Variants map[string]variant `json:"variants,omitempty"`
+	//the day files of the last update not committed yet
+	Pending *pendingDays `json:"pending,omitempty"`
+}
+
+//the day files an update stages and expires, committed along with its manifest
+type pendingDays struct {
+	Staged  []string `json:"staged"`
+	Expired []string `json:"expired"`
+}
+
+//a delta file applied to the state
+type appliedDelta struct {
+	File      string    `json:"file"`
+	Sha256    string    `json:"sha256"`
+	Orders    int       `json:"orders"`
+	Days      []string  `json:"days"`
+	AppliedAt time.Time `json:"appliedAt"`
+}
+
+//the products of the model which changed with an update, by key, and the days expired out of the window
+type modelDiff struct {
+	Added   []string `json:"added"`
+	Changed []string `json:"changed"`
+	Removed []string `json:"removed"`
+	Expired []string `json:"expired"`
+}
+
+//apply the orders of the stream to the state and write the updated model and its changes, exiting on failure
+func updateModel(stream orderStream, update modelUpdate, options copurchase.Options, decay copurchase.Decay) {
+	if err := update.apply(stream, options, decay); err != nil {
+		fmt.Fprintln(os.Stderr, "failed to update the model "+err.Error())
+		os.Exit(1)
+	}
+}
+
+func (update modelUpdate) apply(stream orderStream, options copurchase.Options, decay copurchase.Decay) error {
+	if update.day == "" {
+		update.day = decay.AsOf.UTC().Format(DAY_LAYOUT)
+	} else if _, err := time.Parse(DAY_LAYOUT, update.day); err != nil {
+		return fmt.Errorf("invalid day %s, expecting %s", update.day, DAY_LAYOUT)
+	}
+	state, err := update.loadState()
+	if err != nil {
+		return err
+	}
+	checksum, err := fileChecksum(stream.fileName)
+	if err != nil {
+		return err
+	}
+	for _, applied := range state.Applied {
+		if applied.Sha256 == checksum {
+			return fmt.Errorf("%s was already applied as %s on %s", stream.fileName, applied.File, applied.AppliedAt.Format(time.RFC3339))
+		}
+	}
+
+	//count the orders of the delta in the days they were placed
+	days := make(map[string]*copurchase.Builder)
+	delta := appliedDelta{File: filepath.Base(stream.fileName), Sha256: checksum, AppliedAt: time.Now().UTC()}
+	columns, required := PLAIN_COLUMNS, []string{COLUMN_ORDER_ID, COLUMN_PRODUCT_ID}
+	switch update.mode() {
+	case MODE_COLORED:
+		columns, required = COLORED_COLUMNS, []string{COLUMN_ORDER_ID, COLUMN_PRODUCT_ID, COLUMN_COLOR}
+	case MODE_GEO:
+		columns, required = GEO_COLUMNS, []string{COLUMN_ORDER_ID, COLUMN_STATE, COLUMN_PRODUCT_ID}
+	}
+	var readErr error
+	err = stream.each(columns, required, func(order OrderItems) {
+		if readErr != nil {
+			return
+		}
+		day := update.day
+		if !order.Time.IsZero() {
+			day = order.Time.UTC().Format(DAY_LAYOUT)
+		}
+		builder, ok := days[day]
+		if !ok {
+			if builder, readErr = update.loadDay(day); readErr != nil {
+				return
+			}
+			days[day] = builder
+		}
+		var regions []string
+		keys := orderProductIds(order)
+		if update.colored {
+			keys = variantKeys(order, state.Variants)
+		} else if update.regions != nil {
+			regions = update.regions.Of(order.State)
+		}
+		builder.AddRegionOrder(regions, keys)
+		delta.Orders++
+	})
+	if err != nil {
+		return fmt.Errorf("failed to read the orders of %s %s", stream.fileName, err.Error())
+	} else if readErr != nil {
+		return readErr
+	}
+	for day := range days {
+		delta.Days = append(delta.Days, day)
+	}
+	sort.Strings(delta.Days)
+
+	//the days of the state and of the delta, the ones out of the window expired, counted weighted by their age
+	diff := modelDiff{Added: []string{}, Changed: []string{}, Removed: []string{}, Expired: []string{}}
+	kept, err := update.days()
+	if err != nil {
+		return err
+	}
+	stateDays := make(map[string]bool, len(kept))
+	for _, day := range kept {
+		stateDays[day] = true
+	}
+	for _, day := range delta.Days {
+		if !stateDays[day] {
+			kept = append(kept, day)
+		}
+	}
+	sort.Strings(kept)
+	if update.window > 0 {
+		asOf, _ := time.Parse(DAY_LAYOUT, decay.AsOf.UTC().Format(DAY_LAYOUT))
+		cutoff := asOf.AddDate(0, 0, 1-update.window).Format(DAY_LAYOUT)
+		for len(kept) > 0 && kept[0] < cutoff {
+			diff.Expired = append(diff.Expired, kept[0])
+			kept = kept[1:]
+		}
+	}
+	builder := copurchase.NewBuilder()
+	dayCounts := make(map[string]copurchase.Counts, len(days))
+	for _, day := range kept {
+		var counts copurchase.Counts
+		if dayBuilder, ok := days[day]; ok {
+			counts = dayBuilder.Counts()
+			dayCounts[day] = counts
+		} else if err := readJSON(update.dayFile(day), &counts); err != nil {
+			return err
+		}
+		at, _ := time.Parse(DAY_LAYOUT, day)
+		builder.AddCounts(counts, decay.Weight(at))
+	}
+
+	products, err := builder.Products(options)
+	if err != nil {
+		return err
+	}
+	if update.colored {
+		restoreVariants(products, state.Variants)
+	}
+	records := make(map[string]string, len(products))
+	var changed []model.Product
+	for _, prod := range products {
+		record, err := json.Marshal(prod)
+		if err != nil {
+			return err
+		}
+		sum := sha256.Sum256(record)
+		records[prod.Key()] = hex.EncodeToString(sum[:])
+		if previous, ok := state.Products[prod.Key()]; !ok {
+			diff.Added = append(diff.Added, prod.Key())
+			changed = append(changed, prod)
+		} else if previous != records[prod.Key()] {
+			diff.Changed = append(diff.Changed, prod.Key())
+			changed = append(changed, prod)
+		}
+	}
+	for key := range state.Products {
+		if _, ok := records[key]; !ok {
+			diff.Removed = append(diff.Removed, key)
+		}
+	}
+	sort.Strings(diff.Removed)
+
+	//the model and its changes are written the same way again when the same delta is applied again
+	if err := writeModel(update.modelDir, copurchase.NewModelInfo(options, decay, builder.Orders, len(products)), products); err != nil {
+		return err
+	}
+	if err := writeProducts(update.changesDir, changed); err != nil {
+		return err
+	}
+	if err := writeJSON(filepath.Join(update.changesDir, CHANGES_DIFF), diff); err != nil {
+		return err
+	}
+
+	//stage the day files of the delta, and commit them along with the manifest recording it
+	if err := os.RemoveAll(filepath.Join(update.stateDir, STATE_STAGED)); err != nil {
+		return err
+	}
+	pending := &pendingDays{Staged: []string{}, Expired: diff.Expired}
+	for _, day := range delta.Days {
+		if counts, ok := dayCounts[day]; ok {
+			if err := writeJSON(update.stagedFile(day), counts); err != nil {
+				return err
+			}
+			pending.Staged = append(pending.Staged, day)
+		}
+	}
+	state.Mode = update.mode()
+	state.Applied = append(state.Applied, delta)
+	state.Products = records
+	state.Pending = pending
+	if err := writeJSON(filepath.Join(update.stateDir, STATE_MANIFEST), state); err != nil {
+		return err
+	}
+	if err := update.commit(state); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "applied %d orders of %s to %d days, expired %d days: %d products added, %d changed, %d removed\n",
+		delta.Orders, delta.File, len(delta.Days), len(diff.Expired), len(diff.Added), len(diff.Changed), len(diff.Removed))
+	return nil
+}
+
+//the mode of the orders of the update
+func (update modelUpdate) mode() string {
+	if update.colored {
+		return MODE_COLORED
+	} else if update.regions != nil {
+		return MODE_GEO
+	}
+	return MODE_PLAIN
+}
+
+//the manifest of the state directory, empty when there is none yet. a state of another mode is refused, and
+//the update committed by the manifest is finished when it failed before its day files were in place
+func (update modelUpdate) loadState() (*modelState, error) {
+	state := &modelState{Products: make(map[string]string), Variants: make(map[string]variant)}
+	err := readJSON(filepath.Join(update.stateDir, STATE_MANIFEST), state)
+	if os.IsNotExist(err) {
+		return state, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if state.Mode != update.mode() {
+		return nil, fmt.Errorf("the state of %s is of a %s model, not of a %s one", update.stateDir, state.Mode, update.mode())
+	}
+	if state.Products == nil {
+		state.Products = make(map[string]string)
+	}
+	if state.Variants == nil {
+		state.Variants = make(map[string]variant)
+	}
+	if state.Pending != nil {
+		if err := update.commit(state); err != nil {
+			return nil, err
+		}
+	}
+	return state, nil
+}
+
+//move the staged day files of the manifest into place and remove the expired ones, then write the manifest
+//without them. the files already moved or removed by an update which failed halfway are skipped
+func (update modelUpdate) commit(state *modelState) error {
+	if len(state.Pending.Staged) > 0 {
+		if err := os.MkdirAll(filepath.Join(update.stateDir, STATE_DAYS), 0755); err != nil {
+			return err
+		}
+	}
+	for _, day := range state.Pending.Staged {
+		if err := os.Rename(update.stagedFile(day), update.dayFile(day)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	for _, day := range state.Pending.Expired {
+		if err := os.Remove(update.dayFile(day)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	state.Pending = nil
+	return writeJSON(filepath.Join(update.stateDir, STATE_MANIFEST), state)
+}
+
+//a builder of the counts of day kept so far
+func (update modelUpdate) loadDay(day string) (*copurchase.Builder, error) {
+	builder := copurchase.NewBuilder()
+	var counts copurchase.Counts
+	if err := readJSON(update.dayFile(day), &counts); os.IsNotExist(err) {
+		return builder, nil
+	} else if err != nil {
+		return nil, err
+	}
+	builder.AddCounts(counts, 1)
+	return builder, nil
+}
+
+func (update modelUpdate) dayFile(day string) string {
+	return filepath.Join(update.stateDir, STATE_DAYS, day+".json")
+}
+
+func (update modelUpdate) stagedFile(day string) string {
+	return filepath.Join(update.stateDir, STATE_STAGED, day+".json")
+}
+
+//the days counted in the state, oldest first
+func (update modelUpdate) days() ([]string, error) {
+	fileInfos, err := ioutil.ReadDir(filepath.Join(update.stateDir, STATE_DAYS))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var days []string
+	for _, fileInfo := range fileInfos {
+		if day := strings.TrimSuffix(fileInfo.Name(), ".json"); day != fileInfo.Name() {
+			days = append(days, day)
+		}
+	}
+	sort.Strings(days)
+	return days, nil
+}
+
+func fileChecksum(fileName string) (string, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	checksum := sha256.New()
+	if _, err := io.Copy(checksum, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(checksum.Sum(nil)), nil
+}
+
+func readJSON(fileName string, v interface{}) error {
+	contents, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(contents, v); err != nil {
+		return fmt.Errorf("%s: %s", fileName, err.Error())
+	}
+	return nil
+}
+
+//write v as the json of fileName, replacing it only once it is written in full
+func writeJSON(fileName string, v interface{}) error {
+	contents, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(fileName), 0755); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(fileName+".tmp", contents, 0644); err != nil {
+		return err
+	}
+	return os.Rename(fileName+".tmp", fileName)
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+	"urbn.com/recengine/copurchase"
+)
+
+//apply the orders of export, orderId productId time rows, to the state of update as of the day asOf, returning
+//the diff written
+func applyTestDelta(t *testing.T, update modelUpdate, export string, asOf string) (modelDiff, error) {
+	at, err := time.Parse(DAY_LAYOUT, asOf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream := orderStream{fileName: writeExport(t, export), delimiter: '\t', quoting: QUOTING_STRICT,
+		columns: []string{COLUMN_ORDER_ID, COLUMN_PRODUCT_ID, COLUMN_TIME}}
+	var diff modelDiff
+	if err := update.apply(stream, copurchase.DefaultOptions(), copurchase.Decay{AsOf: at}); err != nil {
+		return diff, err
+	}
+	if err := readJSON(filepath.Join(update.changesDir, CHANGES_DIFF), &diff); err != nil {
+		t.Fatal(err)
+	}
+	return diff, nil
+}
+
+func testModelUpdate(t *testing.T, window int) modelUpdate {
+	dir := t.TempDir()
+	return modelUpdate{stateDir: filepath.Join(dir, "state"), modelDir: filepath.Join(dir, "model"),
+		changesDir: filepath.Join(dir, "changes"), window: window}
+}
+
+func TestUpdateModel(t *testing.T) {
+	update := testModelUpdate(t, 2)
+	deltas := []struct {
+		asOf   string
+		export string
+		want   modelDiff
+	}{
+		{"2026-10-17", "O1\tP1\t2026-10-17\nO1\tP2\t2026-10-17\n",
+			modelDiff{Added: []string{"P1", "P2"}, Changed: []string{}, Removed: []string{}, Expired: []string{}}},
+		{"2026-10-18", "O2\tP1\t2026-10-18\nO2\tP3\t2026-10-18\n",
+			modelDiff{Added: []string{"P3"}, Changed: []string{"P1"}, Removed: []string{}, Expired: []string{}}},
+		//the 17th is out of the window of 2 days as of the 19th, with the only order of P2
+		{"2026-10-19", "O3\tP4\t2026-10-19\nO3\tP5\t2026-10-19\n",
+			modelDiff{Added: []string{"P4", "P5"}, Changed: []string{"P1"}, Removed: []string{"P2"}, Expired: []string{"2026-10-17"}}},
+	}
+	for _, delta := range deltas {
+		diff, err := applyTestDelta(t, update, delta.export, delta.asOf)
+		if err != nil {
+			t.Fatalf("%s: %s", delta.asOf, err.Error())
+		}
+		for _, keys := range [][]string{diff.Added, diff.Changed} {
+			sort.Strings(keys)
+		}
+		if !reflect.DeepEqual(diff, delta.want) {
+			t.Errorf("%s: the diff is %+v, want %+v", delta.asOf, diff, delta.want)
+		}
+	}
+	days, err := update.days()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(days, " ") != "2026-10-18 2026-10-19" {
+		t.Errorf("the state has the days %v, want 2026-10-18 2026-10-19", days)
+	}
+	state, err := update.loadState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.Mode != MODE_PLAIN || state.Pending != nil || len(state.Applied) != 3 {
+		t.Errorf("the manifest has the mode %s, %d deltas and pending %+v", state.Mode, len(state.Applied), state.Pending)
+	}
+	if staged, _ := filepath.Glob(filepath.Join(update.stateDir, STATE_STAGED, "*")); len(staged) > 0 {
+		t.Errorf("the staged day files %v are left", staged)
+	}
+
+	//the same orders again are refused
+	if _, err := applyTestDelta(t, update, deltas[2].export, "2026-10-19"); err == nil || !strings.Contains(err.Error(), "already applied") {
+		t.Errorf("applying a delta again got %v", err)
+	}
+}
+
+func TestUpdateModelMode(t *testing.T) {
+	update := testModelUpdate(t, 0)
+	if _, err := applyTestDelta(t, update, "O1\tP1\t2026-10-17\nO1\tP2\t2026-10-17\n", "2026-10-17"); err != nil {
+		t.Fatal(err)
+	}
+	update.colored = true
+	_, err := applyTestDelta(t, update, "O2\tP1\t2026-10-18\nO2\tP2\t2026-10-18\n", "2026-10-18")
+	if err == nil || !strings.Contains(err.Error(), "of a plain model, not of a colored one") {
+		t.Errorf("applying colored orders to a plain state got %v", err)
+	}
+}
+
+//an update failing once its manifest is written, before its day files are in place, is finished by the next one
+func TestUpdateModelPending(t *testing.T) {
+	update := testModelUpdate(t, 0)
+	if _, err := applyTestDelta(t, update, "O1\tP1\t2026-10-17\nO1\tP2\t2026-10-17\n", "2026-10-17"); err != nil {
+		t.Fatal(err)
+	}
+	state, err := update.loadState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var counts copurchase.Counts
+	if err := readJSON(update.dayFile("2026-10-17"), &counts); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeJSON(update.stagedFile("2026-10-18"), counts); err != nil {
+		t.Fatal(err)
+	}
+	state.Pending = &pendingDays{Staged: []string{"2026-10-18"}, Expired: []string{"2026-10-17"}}
+	if err := writeJSON(filepath.Join(update.stateDir, STATE_MANIFEST), state); err != nil {
+		t.Fatal(err)
+	}
+
+	if state, err = update.loadState(); err != nil {
+		t.Fatal(err)
+	} else if state.Pending != nil {
+		t.Errorf("the loaded state is still pending %+v", state.Pending)
+	}
+	days, err := update.days()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(days, " ") != "2026-10-18" {
+		t.Errorf("the state has the days %v, want 2026-10-18", days)
+	}
+	if _, err := os.Stat(update.stagedFile("2026-10-18")); !os.IsNotExist(err) {
+		t.Errorf("the staged day file is left: %v", err)
+	}
+	var manifest modelState
+	if err := readJSON(filepath.Join(update.stateDir, STATE_MANIFEST), &manifest); err != nil {
+		t.Fatal(err)
+	} else if manifest.Pending != nil {
+		t.Errorf("the manifest is still pending %+v", manifest.Pending)
+	}
+}
@@ -0,0 +1,232 @@
+package api
+
+import (
+	"compress/gzip"
+	"fmt"
+	"github.com/andybalholm/brotli"
+	"hash/fnv"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"urbn.com/recengine/rules"
+)
+
+const (
+	HTTP_HEADER_ETAG              = "ETag"
+	HTTP_HEADER_IF_NONE_MATCH     = "If-None-Match"
+	HTTP_HEADER_LAST_MODIFIED     = "Last-Modified"
+	HTTP_HEADER_IF_MODIFIED_SINCE = "If-Modified-Since"
+	HTTP_HEADER_CACHE_CONTROL     = "Cache-Control"
+	HTTP_HEADER_ACCEPT_ENCODING   = "Accept-Encoding"
+	HTTP_HEADER_CONTENT_ENCODING  = "Content-Encoding"
+	HTTP_HEADER_CONTENT_LENGTH    = "Content-Length"
+	HTTP_HEADER_VARY              = "Vary"
+)
+
+//CachingHandler adds the http caching headers to the responses of a product handler and compresses them.
+//The ETag is derived from the dataset version and the requested product, so it changes exactly when a new
+//dataset is loaded. A handler without a dataset version, like the dynamo db one, gets no validators.
+//With Rules set the validators follow the version of the business rules too, which may be reloaded at any time.
+type CachingHandler struct {
+	handler  http.Handler
+	version  string
+	modified time.Time
+	maxAge   time.Duration
+	Rules    *rules.File
+}
+
+//wrap handler. maxAge should follow the schedule the dataset is reloaded at, 0 asks clients to revalidate every time
+func NewCachingHandler(handler http.Handler, version string, modified time.Time, maxAge time.Duration) *CachingHandler {
+	return &CachingHandler{
+		handler:  handler,
+		version:  version,
+		modified: modified.UTC().Truncate(time.Second),
+		maxAge:   maxAge,
+	}
+}
+
+func (caching *CachingHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	header := w.Header()
+	if caching.maxAge > 0 {
+		header.Set(HTTP_HEADER_CACHE_CONTROL, fmt.Sprintf("public, max-age=%d", int64(caching.maxAge/time.Second)))
+	} else {
+		header.Set(HTTP_HEADER_CACHE_CONTROL, "no-cache")
+	}
+	if pathSchema(r) == "" {
+		header.Set(HTTP_HEADER_VARY, HTTP_HEADER_ACCEPT_ENCODING+", "+HTTP_HEADER_ACCEPT)
+	} else {
+		header.Set(HTTP_HEADER_VARY, HTTP_HEADER_ACCEPT_ENCODING)
+	}
+	if caching.version != "" {
+		etag := caching.etag(r)
+		header.Set(HTTP_HEADER_ETAG, etag)
+		header.Set(HTTP_HEADER_LAST_MODIFIED, caching.lastModified().Format(http.TimeFormat))
+		if caching.notModified(r, etag) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+
+	coding := negotiateEncoding(r)
+	if coding == "" {
+		caching.handler.ServeHTTP(w, r)
+		return
+	}
+	cw := &compressWriter{ResponseWriter: w, coding: coding}
+	defer cw.Close()
+	caching.handler.ServeHTTP(cw, r)
+}
+
+//a weak validator: the gzip, br and identity responses carry the same json.
+//the path and the schema asked for in the Accept header tell the v1 and v2 responses apart
+func (caching *CachingHandler) etag(r *http.Request) string {
+	h := fnv.New64a()
+	io.WriteString(h, r.URL.Path)
+	if r.URL.RawQuery != "" {
+		io.WriteString(h, "?"+r.URL.RawQuery)
+	}
+	io.WriteString(h, "#"+RequestSchema(r, ""))
+	if ruleSet := caching.Rules.Rules(); ruleSet != nil {
+		io.WriteString(h, "#"+ruleSet.Version)
+	}
+	return fmt.Sprintf("W/\"%s-%016x\"", caching.version, h.Sum64())
+}
+
+//the time the dataset was loaded, or the business rules were modified when that is later
+func (caching *CachingHandler) lastModified() time.Time {
+	if caching.Rules != nil {
+		if modified := caching.Rules.Modified().UTC().Truncate(time.Second); modified.After(caching.modified) {
+			return modified
+		}
+	}
+	return caching.modified
+}
+
+//If-None-Match takes precedence over If-Modified-Since
+func (caching *CachingHandler) notModified(r *http.Request, etag string) bool {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		return false
+	}
+	if ifNoneMatch := r.Header.Get(HTTP_HEADER_IF_NONE_MATCH); ifNoneMatch != "" {
+		return etagMatches(ifNoneMatch, etag)
+	}
+	since, err := http.ParseTime(r.Header.Get(HTTP_HEADER_IF_MODIFIED_SINCE))
+	return err == nil && !caching.lastModified().After(since)
+}
+
+//check the If-None-Match header, a comma separated list of etags or *, against etag using the weak comparison
+func etagMatches(ifNoneMatch string, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+//pick the response compression, br is preferred over gzip. empty means identity
+func negotiateEncoding(r *http.Request) string {
+	if acceptsEncoding(r, "br") {
+		return "br"
+	} else if acceptsEncoding(r, "gzip") {
+		return "gzip"
+	}
+	return ""
+}
+
+//whether the Accept-Encoding header of the request allows the given content coding: the coding is listed, or
+//else * is, with a q value above 0. the coding named outright wins over *, wherever they are in the list
+func acceptsEncoding(r *http.Request, coding string) bool {
+	named, wildcard := -1.0, -1.0
+	for _, accepted := range strings.Split(r.Header.Get(HTTP_HEADER_ACCEPT_ENCODING), ",") {
+		parts := strings.Split(accepted, ";")
+		name := strings.ToLower(strings.TrimSpace(parts[0]))
+		if name != coding && name != "*" {
+			continue
+		}
+		q := qValue(parts[1:])
+		if name == coding && q > named {
+			named = q
+		} else if name == "*" && q > wildcard {
+			wildcard = q
+		}
+	}
+	if named >= 0 {
+		return named > 0
+	}
+	return wildcard > 0
+}
+
+//the q value of the parameters of an Accept or Accept-Encoding element, 1 when it has none, 0 when it is invalid
+func qValue(params []string) float64 {
+	for _, param := range params {
+		param = strings.TrimSpace(param)
+		if len(param) > 2 && strings.EqualFold(param[:2], "q=") {
+			q, err := strconv.ParseFloat(param[2:], 64)
+			if err != nil || q < 0 || q > 1 {
+				return 0
+			}
+			return q
+		}
+	}
+	return 1
+}
+
+//compresses the body unless the wrapped handler already wrote an encoded one, like the prerendered gzip responses
+type compressWriter struct {
+	http.ResponseWriter
+	coding  string
+	writer  io.WriteCloser
+	started bool
+}
+
+func (cw *compressWriter) WriteHeader(status int) {
+	if cw.started {
+		return
+	}
+	cw.started = true
+	header := cw.Header()
+	if header.Get(HTTP_HEADER_CONTENT_ENCODING) == "" && status != http.StatusNoContent && status != http.StatusNotModified {
+		header.Set(HTTP_HEADER_CONTENT_ENCODING, cw.coding)
+		header.Del(HTTP_HEADER_CONTENT_LENGTH)
+		if cw.coding == "br" {
+			cw.writer = brotli.NewWriterLevel(cw.ResponseWriter, brotli.DefaultCompression)
+		} else {
+			cw.writer = gzip.NewWriter(cw.ResponseWriter)
+		}
+	}
+	cw.ResponseWriter.WriteHeader(status)
+}
+
+func (cw *compressWriter) Write(b []byte) (int, error) {
+	if !cw.started {
+		cw.WriteHeader(http.StatusOK)
+	}
+	if cw.writer != nil {
+		return cw.writer.Write(b)
+	}
+	return cw.ResponseWriter.Write(b)
+}
+
+//flush what was compressed so far to the client, the http.Flusher of a streamed response
+func (cw *compressWriter) Flush() {
+	if !cw.started {
+		cw.WriteHeader(http.StatusOK)
+	}
+	if flusher, ok := cw.writer.(interface{ Flush() error }); ok {
+		flusher.Flush()
+	}
+	if flusher, ok := cw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (cw *compressWriter) Close() error {
+	if cw.writer != nil {
+		return cw.writer.Close()
+	}
+	return nil
+}
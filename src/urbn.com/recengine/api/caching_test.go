@@ -0,0 +1,85 @@
+package api
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestAcceptsEncoding(t *testing.T) {
+	for _, test := range []struct {
+		header string
+		coding string
+		want   bool
+	}{
+		{"", "gzip", false},
+		{"gzip", "gzip", true},
+		{"deflate, gzip", "gzip", true},
+		{"GZIP", "gzip", true},
+		{"gzip;q=0", "gzip", false},
+		{"gzip; q=0.000", "gzip", false},
+		{"gzip;q=0.5", "gzip", true},
+		{"*", "br", true},
+		{"*;q=0", "br", false},
+		//the coding named outright wins over the wildcard, in any order
+		{"*, gzip;q=0", "gzip", false},
+		{"gzip;q=0, *", "gzip", false},
+		{"*;q=0, gzip", "gzip", true},
+		{"br;q=0, gzip", "br", false},
+		{"gzip;q=abc", "gzip", false},
+	} {
+		r := httptest.NewRequest("GET", "/recommendation/A", nil)
+		r.Header.Set(HTTP_HEADER_ACCEPT_ENCODING, test.header)
+		if got := acceptsEncoding(r, test.coding); got != test.want {
+			t.Errorf("acceptsEncoding(%q, %s) = %t, want %t", test.header, test.coding, got, test.want)
+		}
+	}
+}
+
+func TestCachingLastModified(t *testing.T) {
+	modified := time.Date(2026, 3, 1, 10, 30, 15, 500, time.UTC)
+	caching := NewCachingHandler(NewProductHandler(testRelatedProducts(), SCHEMA_V2), "v1", modified, 0)
+	r := httptest.NewRequest("GET", "/recommendation/A", nil)
+	w := httptest.NewRecorder()
+	caching.ServeHTTP(w, r)
+	if got := w.Header().Get(HTTP_HEADER_LAST_MODIFIED); got != "Sun, 01 Mar 2026 10:30:15 GMT" {
+		t.Errorf("Last-Modified %q, want the dataset modification time", got)
+	}
+
+	r = httptest.NewRequest("GET", "/recommendation/A", nil)
+	r.Header.Set(HTTP_HEADER_IF_MODIFIED_SINCE, modified.Format(http.TimeFormat))
+	w = httptest.NewRecorder()
+	caching.ServeHTTP(w, r)
+	if w.Code != http.StatusNotModified {
+		t.Errorf("If-Modified-Since the dataset time: status %d, want %d", w.Code, http.StatusNotModified)
+	}
+}
+
+func TestCompressWriterFlush(t *testing.T) {
+	flushed := make(chan []byte, 1)
+	caching := NewCachingHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("{\"productId\":\"A\"}\n"))
+		w.(http.Flusher).Flush()
+		flushed <- append([]byte(nil), w.(*compressWriter).ResponseWriter.(*httptest.ResponseRecorder).Body.Bytes()...)
+	}), "", time.Time{}, 0)
+	r := httptest.NewRequest("GET", "/recommendation/A", nil)
+	r.Header.Set(HTTP_HEADER_ACCEPT_ENCODING, "gzip")
+	w := httptest.NewRecorder()
+	caching.ServeHTTP(w, r)
+	if !w.Flushed {
+		t.Error("the response was not flushed")
+	}
+	//what was flushed before the handler returned decodes to the whole json
+	zr, err := gzip.NewReader(bytes.NewReader(<-flushed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(zr)
+	if string(body) != "{\"productId\":\"A\"}\n" {
+		t.Errorf("flushed %q", body)
+	}
+}
@@ -0,0 +1,148 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"github.com/golang/glog"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+	"net"
+	"urbn.com/recengine/model"
+	"urbn.com/recengine/ranking"
+	pb "urbn.com/recengine/recommendationpb"
+	"urbn.com/recengine/rules"
+	"urbn.com/recengine/store"
+)
+
+//the most products a single BatchGet may ask for
+const GRPC_MAX_BATCH = 100
+
+//RecommendationServer is the grpc api of the engine, it serves the same backends, relations and filtering as
+//the http one
+type RecommendationServer struct {
+	pb.UnimplementedRecommendationServiceServer
+	source    store.ProductSource
+	relations *store.Relations
+	rules     *rules.File
+}
+
+//a server of source and the relation types of relations, its items filtered by the business rules of rulesFile.
+//either may be nil
+func NewRecommendationServer(source store.ProductSource, relations *store.Relations, rulesFile *rules.File) *RecommendationServer {
+	return &RecommendationServer{source: source, relations: relations, rules: rulesFile}
+}
+
+//the filter of the current rules
+func (server *RecommendationServer) filter() ranking.Filter {
+	return ranking.Filter{Rules: server.rules.Rules()}
+}
+
+func (server *RecommendationServer) Get(ctx context.Context, req *pb.GetRequest) (*pb.Product, error) {
+	if req.GetProductId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "product_id is required")
+	}
+	filter := server.filter()
+	prod := ranking.FindVariant(server.source, req.GetProductId(), requestVariants(req), filter)
+	prod = server.findRelations(prod, requestVariants(req), req.GetTypes(), filter)
+	glog.V(2).Infof("served grpc Get %s", req.GetProductId())
+	return toProtoProduct(prod), nil
+}
+
+//the product with the items of the relation types asked for, unchanged when none is
+func (server *RecommendationServer) findRelations(prod model.Product, variants []string, types []string, filter ranking.Filter) model.Product {
+	if types = UniqueTypes(types); len(types) == 0 {
+		return prod
+	}
+	return ranking.FindRelations(server.relations, prod, variants, types, filter)
+}
+
+//the variants asked for, the sku first so it wins when the model has both, then the color
+func requestVariants(req *pb.GetRequest) []string {
+	return []string{req.GetSkuId(), req.GetColor()}
+}
+
+//answer the product ids, then the variant requests, in the order they were asked for, so the products of the
+//response line up with them one to one
+func (server *RecommendationServer) BatchGet(ctx context.Context, req *pb.BatchGetRequest) (*pb.BatchGetResponse, error) {
+	count := len(req.GetProductIds()) + len(req.GetRequests())
+	if count > GRPC_MAX_BATCH {
+		return nil, status.Errorf(codes.InvalidArgument, "at most %d product_ids and requests per batch", GRPC_MAX_BATCH)
+	}
+	resp := &pb.BatchGetResponse{Products: make([]*pb.Product, 0, count)}
+	filter := server.filter()
+	for _, productId := range req.GetProductIds() {
+		if err := ctx.Err(); err != nil {
+			return nil, status.FromContextError(err).Err()
+		}
+		prod := server.findRelations(ranking.FindProduct(server.source, productId, filter), nil, req.GetTypes(), filter)
+		resp.Products = append(resp.Products, toProtoProduct(prod))
+	}
+	for _, variantReq := range req.GetRequests() {
+		if err := ctx.Err(); err != nil {
+			return nil, status.FromContextError(err).Err()
+		}
+		prod := ranking.FindVariant(server.source, variantReq.GetProductId(), requestVariants(variantReq), filter)
+		prod = server.findRelations(prod, requestVariants(variantReq), variantReq.GetTypes(), filter)
+		resp.Products = append(resp.Products, toProtoProduct(prod))
+	}
+	glog.V(2).Infof("served grpc BatchGet of %d products", count)
+	return resp, nil
+}
+
+func toProtoProduct(prod model.Product) *pb.Product {
+	out := &pb.Product{
+		ProductId:           prod.ProductID,
+		Color:               prod.Color,
+		SkuId:               prod.SkuID,
+		BoughtTogetherItems: toProtoItems(prod.BoughtTogetherItems),
+	}
+	if prod.Relations != nil {
+		out.Relations = make(map[string]*pb.RelatedItems, len(prod.Relations))
+		for relationType, items := range prod.Relations {
+			out.Relations[relationType] = &pb.RelatedItems{Items: toProtoItems(items)}
+		}
+	}
+	return out
+}
+
+func toProtoItems(items []model.BoughtTogetherItem) []*pb.BoughtTogetherItem {
+	out := make([]*pb.BoughtTogetherItem, len(items))
+	for i, item := range items {
+		scores := make([]*pb.RegionScore, len(item.ScoreByRegion))
+		for j, score := range item.ScoreByRegion {
+			scores[j] = &pb.RegionScore{Region: score.Region, Score: int64(score.Score)}
+		}
+		out[i] = &pb.BoughtTogetherItem{
+			ProductId:     item.ProductID,
+			Color:         item.Color,
+			SkuId:         item.SkuID,
+			TotalScore:    int64(item.TotalScore),
+			ScoreByRegion: scores,
+		}
+	}
+	return out
+}
+
+//start the grpc server, with server reflection, in the background. an empty address leaves it off and returns
+//nil. the caller stops the server returned with GracefulStop
+func StartGrpc(address string, source store.ProductSource, relations *store.Relations, rulesFile *rules.File) (*grpc.Server, error) {
+	if address == "" {
+		return nil, nil
+	}
+	lis, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen for grpc on %s %s", address, err.Error())
+	}
+	server := grpc.NewServer()
+	pb.RegisterRecommendationServiceServer(server, NewRecommendationServer(source, relations, rulesFile))
+	reflection.Register(server)
+	go func() {
+		if err := server.Serve(lis); err != nil && err != grpc.ErrServerStopped {
+			glog.Errorf("grpc service failed %s\n", err.Error())
+		}
+	}()
+	glog.Infof("grpc service ready on %s", address)
+	return server, nil
+}
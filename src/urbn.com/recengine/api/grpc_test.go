@@ -0,0 +1,151 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"net"
+	"sort"
+	"strings"
+	"testing"
+	"urbn.com/recengine/model"
+	pb "urbn.com/recengine/recommendationpb"
+	"urbn.com/recengine/store"
+)
+
+//a client of a server of the test products and their similar products, over an in-memory connection
+func grpcTestClient(t *testing.T) pb.RecommendationServiceClient {
+	relates := testRelatedProducts()
+	relates.Add(model.Product{ProductID: "A", Color: "blue", BoughtTogetherItems: []model.BoughtTogetherItem{
+		{ProductID: "C", Color: "red", TotalScore: 5, ScoreByRegion: []model.RegionScore{{Region: "PA", Score: 5}}},
+	}})
+	similar := store.NewRelatedProducts()
+	similar.Add(model.Product{ProductID: "A", BoughtTogetherItems: []model.BoughtTogetherItem{{ProductID: "D", TotalScore: 7}}})
+	relations := &store.Relations{Sources: map[string]store.ProductSource{model.RELATION_SIMILAR: similar}}
+	lis := bufconn.Listen(1024 * 1024)
+	server := grpc.NewServer()
+	pb.RegisterRecommendationServiceServer(server, NewRecommendationServer(relates, relations, nil))
+	go server.Serve(lis)
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		conn.Close()
+		server.Stop()
+	})
+	return pb.NewRecommendationServiceClient(conn)
+}
+
+func TestGrpcGet(t *testing.T) {
+	client := grpcTestClient(t)
+	ctx := context.Background()
+	prod, err := client.Get(ctx, &pb.GetRequest{ProductId: "A"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if prod.GetProductId() != "A" || len(prod.GetBoughtTogetherItems()) != 1 || prod.GetBoughtTogetherItems()[0].GetProductId() != "B" {
+		t.Errorf("Get(A) = %v", prod)
+	}
+	prod, err = client.Get(ctx, &pb.GetRequest{ProductId: "A", Color: "blue"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if prod.GetColor() != "blue" || len(prod.GetBoughtTogetherItems()) != 1 || prod.GetBoughtTogetherItems()[0].GetColor() != "red" {
+		t.Errorf("Get(A, blue) = %v", prod)
+	}
+	prod, err = client.Get(ctx, &pb.GetRequest{ProductId: "unknown"})
+	if err != nil || len(prod.GetBoughtTogetherItems()) != 0 {
+		t.Errorf("Get(unknown) = %v, %v, want no items", prod, err)
+	}
+	if _, err := client.Get(ctx, &pb.GetRequest{}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Get() = %v, want %s", err, codes.InvalidArgument)
+	}
+}
+
+func TestGrpcBatchGet(t *testing.T) {
+	client := grpcTestClient(t)
+	ctx := context.Background()
+	resp, err := client.BatchGet(ctx, &pb.BatchGetRequest{
+		ProductIds: []string{"unknown", "A"},
+		Requests:   []*pb.GetRequest{{ProductId: "A", Color: "blue"}, {ProductId: "A", SkuId: "none"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	//the product ids, then the requests, in the order they were asked for
+	var got []string
+	for _, prod := range resp.GetProducts() {
+		got = append(got, fmt.Sprintf("%s/%s/%d", prod.GetProductId(), prod.GetColor(), len(prod.GetBoughtTogetherItems())))
+	}
+	want := []string{"unknown//0", "A//1", "A/blue/1", "A//1"}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("BatchGet = %v, want %v", got, want)
+	}
+
+	var productIds []string
+	var requests []*pb.GetRequest
+	for i := 0; i < GRPC_MAX_BATCH/2; i++ {
+		productIds = append(productIds, "A")
+		requests = append(requests, &pb.GetRequest{ProductId: "A"})
+	}
+	resp, err = client.BatchGet(ctx, &pb.BatchGetRequest{ProductIds: productIds, Requests: requests})
+	if err != nil || len(resp.GetProducts()) != GRPC_MAX_BATCH {
+		t.Errorf("BatchGet of %d: %d products, %v", GRPC_MAX_BATCH, len(resp.GetProducts()), err)
+	}
+	_, err = client.BatchGet(ctx, &pb.BatchGetRequest{ProductIds: append(productIds, "B"), Requests: requests})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("BatchGet of %d = %v, want %s", GRPC_MAX_BATCH+1, err, codes.InvalidArgument)
+	}
+}
+
+//the relations of a product as type:items like similar:D
+func formatProtoRelations(prod *pb.Product) string {
+	var relations []string
+	for relationType, related := range prod.GetRelations() {
+		var items []string
+		for _, item := range related.GetItems() {
+			items = append(items, item.GetProductId())
+		}
+		relations = append(relations, relationType+":"+strings.Join(items, ","))
+	}
+	sort.Strings(relations)
+	return strings.Join(relations, " ")
+}
+
+func TestGrpcRelations(t *testing.T) {
+	client := grpcTestClient(t)
+	ctx := context.Background()
+	prod, err := client.Get(ctx, &pb.GetRequest{ProductId: "A", Types: []string{"similar", " boughtTogether", "viewedTogether", "similar"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := formatProtoRelations(prod); got != "boughtTogether:B similar:D viewedTogether:" {
+		t.Errorf("Get(A) has the relations %s", got)
+	}
+	if prod, err = client.Get(ctx, &pb.GetRequest{ProductId: "A"}); err != nil || prod.GetRelations() != nil {
+		t.Errorf("Get(A) without types = %v, %v, want no relations", prod, err)
+	}
+
+	resp, err := client.BatchGet(ctx, &pb.BatchGetRequest{
+		ProductIds: []string{"A"},
+		Types:      []string{"similar"},
+		Requests:   []*pb.GetRequest{{ProductId: "A", Color: "blue", Types: []string{"boughtTogether"}}, {ProductId: "A"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, prod := range resp.GetProducts() {
+		got = append(got, "["+formatProtoRelations(prod)+"]")
+	}
+	if want := "[similar:D] [boughtTogether:C] []"; strings.Join(got, " ") != want {
+		t.Errorf("BatchGet has the relations %s, want %s", strings.Join(got, " "), want)
+	}
+}
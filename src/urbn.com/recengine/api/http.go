@@ -0,0 +1,110 @@
+//Package api serves the recommendations over http and grpc.
+package api
+
+import (
+	"encoding/json"
+	"github.com/golang/glog"
+	"net/http"
+	"strconv"
+	"strings"
+	"urbn.com/recengine/model"
+	"urbn.com/recengine/ranking"
+	"urbn.com/recengine/rules"
+	"urbn.com/recengine/store"
+)
+
+const (
+	HTTP_HEADER_CONTENT_TYPE = "Content-Type"
+	HTTP_HEADER_VALUE_JSON   = "application/json; charset=UTF-8"
+	//the query parameters asking for the items of one variant, /recommendation/37418258?color=BLACK
+	QUERY_PARAM_COLOR = "color"
+	QUERY_PARAM_SKU   = "sku"
+	//the comma separated relation types a v2 response carries in its relations, ?types=similar,viewedTogether
+	QUERY_PARAM_TYPES = "types"
+	//the query parameter asking a v2 response for the decisions of the business rules, ?debug=true
+	QUERY_PARAM_DEBUG = "debug"
+)
+
+//ProductHandler serves /recommendation/{productId} from any backend, in the schema the request asks for,
+//with the items of the relation types asked for out of Relations, filtered by the business rules of Rules.
+//MaxItems is the most items served, ranking.MAX_ITEMS when 0 and every item when negative
+type ProductHandler struct {
+	Source        store.ProductSource
+	DefaultSchema string
+	Relations     *store.Relations
+	Rules         *rules.File
+	MaxItems      int
+}
+
+func NewProductHandler(source store.ProductSource, defaultSchema string) *ProductHandler {
+	return &ProductHandler{Source: source, DefaultSchema: defaultSchema}
+}
+
+func (handler *ProductHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	filter := ranking.Filter{Rules: handler.Rules.Rules(), Debug: GetDebug(r), MaxItems: handler.MaxItems}
+	ServeProduct(handler.Source, handler.Relations, filter, RequestSchema(r, handler.DefaultSchema), w, r)
+}
+
+//serve the product, or variant, looked up from source and filtered by filter, an unknown product gets an empty
+//list. a v2 response has the relations of the types asked for, looked up in relations
+func ServeProduct(source store.ProductSource, relations *store.Relations, filter ranking.Filter, schema string, w http.ResponseWriter, r *http.Request) {
+	glog.V(2).Infof("serving %s", r.URL.Path)
+	prod := ranking.FindVariant(source, GetProductId(r), GetVariants(r), filter)
+	if types := GetTypes(r); len(types) > 0 && schema != SCHEMA_V1 {
+		prod = ranking.FindRelations(relations, prod, GetVariants(r), types, filter)
+	}
+
+	w.Header().Set(HTTP_HEADER_CONTENT_TYPE, HTTP_HEADER_VALUE_JSON)
+	if schema == SCHEMA_V1 {
+		recommendation := model.ToRecommendation(prod)
+		json.NewEncoder(w).Encode(recommendation)
+		glog.V(3).Infof("served %s %+v", r.URL.Path, recommendation)
+	} else {
+		json.NewEncoder(w).Encode(prod)
+		glog.V(3).Infof("served %s %+v", r.URL.Path, prod)
+	}
+	glog.V(2).Infof("served %s", r.URL.Path)
+}
+
+//get the variants asked for by the sku and color query parameters, the sku first so it wins when the model
+//has both, then the color it falls back to
+func GetVariants(r *http.Request) []string {
+	query := r.URL.Query()
+	return []string{query.Get(QUERY_PARAM_SKU), query.Get(QUERY_PARAM_COLOR)}
+}
+
+//whether the debug query parameter asks for the decisions of the business rules
+func GetDebug(r *http.Request) bool {
+	debug, _ := strconv.ParseBool(r.URL.Query().Get(QUERY_PARAM_DEBUG))
+	return debug
+}
+
+//get the relation types asked for by the types query parameter, each once
+func GetTypes(r *http.Request) []string {
+	return UniqueTypes(strings.Split(r.URL.Query().Get(QUERY_PARAM_TYPES), ","))
+}
+
+//the relation types trimmed, each once in the order first asked for, without the empty ones
+func UniqueTypes(relationTypes []string) []string {
+	var types []string
+	seen := make(map[string]bool)
+	for _, relationType := range relationTypes {
+		relationType = strings.TrimSpace(relationType)
+		if relationType != "" && !seen[relationType] {
+			seen[relationType] = true
+			types = append(types, relationType)
+		}
+	}
+	return types
+}
+
+//get the productId from the url path, for instance /recommendation/prod123 will return prod123
+func GetProductId(r *http.Request) string {
+	p := strings.Split(r.URL.Path, "/")
+	length := len(p)
+	if length >= 1 {
+		return p[length-1]
+	} else {
+		return ""
+	}
+}
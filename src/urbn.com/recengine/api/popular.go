@@ -0,0 +1,53 @@
+package api
+
+import (
+	"encoding/json"
+	"github.com/golang/glog"
+	"net/http"
+	"strings"
+	"urbn.com/recengine/model"
+	"urbn.com/recengine/ranking"
+	"urbn.com/recengine/rules"
+)
+
+const (
+	//the routes of the popularity lists, /topsellers/?region=PA
+	ROUTE_TOP_SELLERS = "/topsellers/"
+	ROUTE_TRENDING    = "/trending/"
+	//the query parameter filtering a list by a region, a state code like PA or a region group like NORTHEAST
+	QUERY_PARAM_REGION = "region"
+)
+
+//PopularHandler serves the popularity lists, the top sellers and the trending products, overall or filtered
+//by region, and by the business rules of Rules
+type PopularHandler struct {
+	Lists map[string]model.PopularList
+	Rules *rules.File
+}
+
+func NewPopularHandler(lists map[string]model.PopularList) *PopularHandler {
+	return &PopularHandler{Lists: lists}
+}
+
+func (handler *PopularHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	glog.V(2).Infof("serving %s", r.URL.Path)
+	name := model.LIST_TOP_SELLERS
+	if strings.HasPrefix(r.URL.Path, ROUTE_TRENDING) {
+		name = model.LIST_TRENDING
+	}
+	list, ok := handler.Lists[name]
+	if !ok {
+		list = model.EmptyPopularList(name)
+	}
+	list = ranking.FilterPopular(list, GetRegion(r), ranking.Filter{Rules: handler.Rules.Rules(), Debug: GetDebug(r)})
+
+	w.Header().Set(HTTP_HEADER_CONTENT_TYPE, HTTP_HEADER_VALUE_JSON)
+	json.NewEncoder(w).Encode(list)
+	glog.V(3).Infof("served %s %+v", r.URL.Path, list)
+	glog.V(2).Infof("served %s", r.URL.Path)
+}
+
+//get the region asked for by the region query parameter, in the upper case of the region codes
+func GetRegion(r *http.Request) string {
+	return strings.ToUpper(strings.TrimSpace(r.URL.Query().Get(QUERY_PARAM_REGION)))
+}
@@ -0,0 +1,86 @@
+package api
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"github.com/golang/glog"
+	"net/http"
+	"urbn.com/recengine/model"
+	"urbn.com/recengine/ranking"
+	"urbn.com/recengine/store"
+)
+
+//PrerenderedProducts serves the default v2 response of every loaded product from bytes encoded once at load time.
+//Requests carrying query parameters, v1 requests and requests for unknown products fall back to the dynamic ProductHandler.
+//The caching headers are left to the CachingHandler wrapping it.
+type PrerenderedProducts struct {
+	fallback  *ProductHandler
+	responses map[string][]byte
+	gzipped   bool
+}
+
+//render the default response of every product in relates. with gzipped set only the compressed body is kept,
+//clients which do not accept gzip are served the dynamic encoding, as are the requests for relations
+func NewPrerenderedProducts(relates *store.RelatedProducts, relations *store.Relations, gzipped bool, defaultSchema string) *PrerenderedProducts {
+	fallback := NewProductHandler(relates, defaultSchema)
+	fallback.Relations = relations
+	pre := &PrerenderedProducts{
+		fallback:  fallback,
+		responses: make(map[string][]byte, len(relates.Relates)),
+		gzipped:   gzipped,
+	}
+	var size int
+	for productId, prod := range relates.Relates {
+		body, err := renderProduct(ranking.FilterResult(prod), gzipped)
+		if err != nil {
+			glog.Errorf("failed to prerender %s %s\n", productId, err.Error())
+			continue
+		}
+		pre.responses[productId] = body
+		size += len(body)
+	}
+	glog.Infof("prerendered %d responses, %d bytes, gzipped: %t", len(pre.responses), size, gzipped)
+	return pre
+}
+
+//encode the product exactly the way the dynamic path writes it
+func renderProduct(prod model.Product, gzipped bool) ([]byte, error) {
+	var buf bytes.Buffer
+	if !gzipped {
+		err := json.NewEncoder(&buf).Encode(prod)
+		return buf.Bytes(), err
+	}
+	zw, _ := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+	if err := json.NewEncoder(zw).Encode(prod); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (pre *PrerenderedProducts) Get(productId string) (model.Product, bool) {
+	return pre.fallback.Source.Get(productId)
+}
+
+func (pre *PrerenderedProducts) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	productId := GetProductId(r)
+	body, ok := pre.responses[productId]
+	//the gzip and the identity fallback responses of a product differ, both must tell caches they vary by encoding
+	if pre.gzipped && w.Header().Get(HTTP_HEADER_VARY) == "" {
+		w.Header().Set(HTTP_HEADER_VARY, HTTP_HEADER_ACCEPT_ENCODING)
+	}
+	if !ok || r.URL.RawQuery != "" || (pre.gzipped && !acceptsEncoding(r, "gzip")) ||
+		RequestSchema(r, pre.fallback.DefaultSchema) != SCHEMA_V2 {
+		pre.fallback.ServeHTTP(w, r)
+		return
+	}
+	w.Header().Set(HTTP_HEADER_CONTENT_TYPE, HTTP_HEADER_VALUE_JSON)
+	if pre.gzipped {
+		w.Header().Set(HTTP_HEADER_CONTENT_ENCODING, "gzip")
+	}
+	w.Write(body)
+	glog.V(2).Infof("served %s prerendered", r.URL.Path)
+}
@@ -0,0 +1,32 @@
+package api
+
+import (
+	"net/http/httptest"
+	"testing"
+	"urbn.com/recengine/model"
+	"urbn.com/recengine/store"
+)
+
+func testRelatedProducts() *store.RelatedProducts {
+	relates := store.NewRelatedProducts()
+	relates.Add(model.Product{ProductID: "A", BoughtTogetherItems: []model.BoughtTogetherItem{
+		{ProductID: "B", TotalScore: 2, ScoreByRegion: []model.RegionScore{}},
+	}})
+	return relates
+}
+
+func TestPrerenderedVary(t *testing.T) {
+	pre := NewPrerenderedProducts(testRelatedProducts(), nil, true, SCHEMA_V2)
+	for acceptEncoding, contentEncoding := range map[string]string{"gzip": "gzip", "": "", "gzip;q=0": ""} {
+		r := httptest.NewRequest("GET", "/recommendation/A", nil)
+		r.Header.Set(HTTP_HEADER_ACCEPT_ENCODING, acceptEncoding)
+		w := httptest.NewRecorder()
+		pre.ServeHTTP(w, r)
+		if vary := w.Header().Get(HTTP_HEADER_VARY); vary != HTTP_HEADER_ACCEPT_ENCODING {
+			t.Errorf("Accept-Encoding %q: Vary %q, want %q", acceptEncoding, vary, HTTP_HEADER_ACCEPT_ENCODING)
+		}
+		if got := w.Header().Get(HTTP_HEADER_CONTENT_ENCODING); got != contentEncoding {
+			t.Errorf("Accept-Encoding %q: Content-Encoding %q, want %q", acceptEncoding, got, contentEncoding)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+)
+
+//the response schemas. v1 is ProductRecommendation with boughtTogether, v2 is Product with boughtTogetherItems
+const (
+	SCHEMA_V1          = "v1"
+	SCHEMA_V2          = "v2"
+	MEDIA_TYPE_V1      = "application/vnd.urbn.recommendation.v1+json"
+	MEDIA_TYPE_V2      = "application/vnd.urbn.recommendation.v2+json"
+	HTTP_HEADER_ACCEPT = "Accept"
+)
+
+//the schema a request asks for: the /v1/ or /v2/ path prefix wins, then the Accept header, then defaultSchema
+func RequestSchema(r *http.Request, defaultSchema string) string {
+	if schema := pathSchema(r); schema != "" {
+		return schema
+	}
+	if schema := acceptSchema(r.Header.Get(HTTP_HEADER_ACCEPT)); schema != "" {
+		return schema
+	}
+	return defaultSchema
+}
+
+//the schema of the media type of the Accept header with the highest q value, the first listed of equal ones.
+//a media range with q=0 is not acceptable, empty when neither media type is accepted
+func acceptSchema(accept string) string {
+	schema, best := "", 0.0
+	for _, mediaRange := range strings.Split(accept, ",") {
+		parts := strings.Split(mediaRange, ";")
+		var candidate string
+		switch strings.ToLower(strings.TrimSpace(parts[0])) {
+		case MEDIA_TYPE_V1:
+			candidate = SCHEMA_V1
+		case MEDIA_TYPE_V2:
+			candidate = SCHEMA_V2
+		default:
+			continue
+		}
+		if q := qValue(parts[1:]); q > best {
+			schema, best = candidate, q
+		}
+	}
+	return schema
+}
+
+//the schema named by the path prefix, empty for /recommendation/ which is negotiated
+func pathSchema(r *http.Request) string {
+	if strings.HasPrefix(r.URL.Path, "/v1/") {
+		return SCHEMA_V1
+	} else if strings.HasPrefix(r.URL.Path, "/v2/") {
+		return SCHEMA_V2
+	}
+	return ""
+}
+
+//register /v1/recommendation/, /v2/recommendation/ and the negotiated /recommendation/ on mux
+func RegisterRoutes(mux *http.ServeMux, handler http.Handler) {
+	mux.Handle("/recommendation/", handler)
+	mux.Handle("/v1/recommendation/", handler)
+	mux.Handle("/v2/recommendation/", handler)
+}
+
+//register the /topsellers/ and /trending/ popularity lists on mux
+func RegisterPopularRoutes(mux *http.ServeMux, handler http.Handler) {
+	mux.Handle(ROUTE_TOP_SELLERS, handler)
+	mux.Handle(ROUTE_TRENDING, handler)
+}
@@ -0,0 +1,59 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"urbn.com/recengine/model"
+	"urbn.com/recengine/store"
+)
+
+func TestRequestSchema(t *testing.T) {
+	for _, test := range []struct {
+		path   string
+		accept string
+		want   string
+	}{
+		{"/recommendation/A", "", SCHEMA_V2},
+		{"/recommendation/A", "application/json", SCHEMA_V2},
+		{"/recommendation/A", MEDIA_TYPE_V1, SCHEMA_V1},
+		{"/recommendation/A", "application/json, " + MEDIA_TYPE_V1 + "; charset=utf-8", SCHEMA_V1},
+		{"/recommendation/A", MEDIA_TYPE_V1 + ";q=0", SCHEMA_V2},
+		{"/recommendation/A", MEDIA_TYPE_V1 + ";q=0, " + MEDIA_TYPE_V2 + ";q=0", SCHEMA_V2},
+		{"/recommendation/A", MEDIA_TYPE_V1 + ";q=0.5, " + MEDIA_TYPE_V2 + ";q=0.9", SCHEMA_V2},
+		{"/recommendation/A", MEDIA_TYPE_V2 + ";q=0.1, " + MEDIA_TYPE_V1, SCHEMA_V1},
+		{"/recommendation/A", MEDIA_TYPE_V1 + ", " + MEDIA_TYPE_V2, SCHEMA_V1},
+		{"/recommendation/A", MEDIA_TYPE_V1 + "x", SCHEMA_V2},
+		{"/v1/recommendation/A", MEDIA_TYPE_V2, SCHEMA_V1},
+		{"/v2/recommendation/A", MEDIA_TYPE_V1, SCHEMA_V2},
+	} {
+		r := httptest.NewRequest("GET", test.path, nil)
+		r.Header.Set(HTTP_HEADER_ACCEPT, test.accept)
+		if got := RequestSchema(r, SCHEMA_V2); got != test.want {
+			t.Errorf("%s Accept %q: %s, want %s", test.path, test.accept, got, test.want)
+		}
+	}
+}
+
+func TestProductHandlerMaxItems(t *testing.T) {
+	relates := store.NewRelatedProducts()
+	prod := model.Product{ProductID: "A"}
+	for i := 0; i < 12; i++ {
+		prod.BoughtTogetherItems = append(prod.BoughtTogetherItems, model.BoughtTogetherItem{ProductID: strconv.Itoa(i), TotalScore: 12 - i})
+	}
+	relates.Add(prod)
+	for maxItems, want := range map[int]int{-1: 12, 0: 10, 3: 3} {
+		handler := NewProductHandler(relates, SCHEMA_V1)
+		handler.MaxItems = maxItems
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", "/recommendation/A", nil))
+		var recommendation model.ProductRecommendation
+		if err := json.Unmarshal(w.Body.Bytes(), &recommendation); err != nil {
+			t.Fatal(err)
+		}
+		if len(recommendation.BoughtWith) != want {
+			t.Errorf("MaxItems %d: %d items, want %d", maxItems, len(recommendation.BoughtWith), want)
+		}
+	}
+}
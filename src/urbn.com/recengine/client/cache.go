@@ -0,0 +1,68 @@
+package client
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+//a size bounded, least recently used cache of products which expire after ttl
+type cache struct {
+	mu      sync.Mutex
+	size    int
+	ttl     time.Duration
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+type cacheEntry struct {
+	productId string
+	product   Product
+	expires   time.Time
+}
+
+func newCache(size int, ttl time.Duration) *cache {
+	return &cache{
+		size:    size,
+		ttl:     ttl,
+		order:   list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+func (c *cache) get(productId string) (Product, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	element, ok := c.entries[productId]
+	if !ok {
+		return Product{}, false
+	}
+	entry := element.Value.(*cacheEntry)
+	if time.Now().After(entry.expires) {
+		c.order.Remove(element)
+		delete(c.entries, productId)
+		return Product{}, false
+	}
+	c.order.MoveToFront(element)
+	return entry.product, true
+}
+
+func (c *cache) put(productId string, product Product) {
+	if c.size <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	expires := time.Now().Add(c.ttl)
+	if element, ok := c.entries[productId]; ok {
+		element.Value = &cacheEntry{productId: productId, product: product, expires: expires}
+		c.order.MoveToFront(element)
+		return
+	}
+	c.entries[productId] = c.order.PushFront(&cacheEntry{productId: productId, product: product, expires: expires})
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).productId)
+	}
+}
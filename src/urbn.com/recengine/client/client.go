@@ -0,0 +1,234 @@
+//Package client calls the recommendation service over http and returns typed products.
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+	"urbn.com/recengine/model"
+)
+
+//the service's own data model, so the client can not drift from what /recommendation/{productId} writes
+type Product = model.Product
+type BoughtTogetherItem = model.BoughtTogetherItem
+type RegionScore = model.RegionScore
+
+//Recommender is implemented by Client and by Fake, depend on it to swap the fake in unit tests
+type Recommender interface {
+	Get(ctx context.Context, productId string) (Product, error)
+	BatchGet(ctx context.Context, productIds []string) ([]Product, error)
+}
+
+var _ Recommender = (*Client)(nil)
+var _ Recommender = (*Fake)(nil)
+
+const (
+	DEFAULT_TIMEOUT     = 2 * time.Second
+	DEFAULT_RETRIES     = 2
+	DEFAULT_BACKOFF     = 50 * time.Millisecond
+	DEFAULT_CONCURRENCY = 8
+	//the longest wait between two attempts, however many retries
+	MAX_BACKOFF = 5 * time.Second
+)
+
+//StatusError is returned when the service answers with anything but 200
+type StatusError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("recommendation service returned %d: %s", e.StatusCode, e.Body)
+}
+
+type Client struct {
+	baseURL     string
+	httpClient  *http.Client
+	timeout     time.Duration
+	retries     int
+	backoff     time.Duration
+	concurrency int
+	cache       *cache
+}
+
+type Option func(*Client)
+
+//use the given http client, for instance one with a custom transport. its own timeout is kept unless
+//WithTimeout is given too, in any order, which applies to a copy of it
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) {
+		c.httpClient = httpClient
+	}
+}
+
+//the timeout of every single attempt, the context passed to the calls bounds the whole call
+func WithTimeout(timeout time.Duration) Option {
+	return func(c *Client) {
+		c.timeout = timeout
+	}
+}
+
+//retry failed attempts up to retries times, waiting a random duration up to backoff doubled every attempt,
+//at most MAX_BACKOFF
+func WithRetries(retries int, backoff time.Duration) Option {
+	return func(c *Client) {
+		c.retries = retries
+		c.backoff = backoff
+	}
+}
+
+//how many requests a BatchGet runs in parallel
+func WithConcurrency(concurrency int) Option {
+	return func(c *Client) {
+		if concurrency > 0 {
+			c.concurrency = concurrency
+		}
+	}
+}
+
+//keep up to size products in a local cache for ttl
+func WithCache(size int, ttl time.Duration) Option {
+	return func(c *Client) {
+		c.cache = newCache(size, ttl)
+	}
+}
+
+//a client for the service at baseURL, for instance http://recommendation:8080
+func New(baseURL string, options ...Option) *Client {
+	c := &Client{
+		baseURL:     strings.TrimRight(baseURL, "/"),
+		retries:     DEFAULT_RETRIES,
+		backoff:     DEFAULT_BACKOFF,
+		concurrency: DEFAULT_CONCURRENCY,
+	}
+	for _, option := range options {
+		option(c)
+	}
+	if c.httpClient == nil {
+		c.httpClient = &http.Client{Timeout: DEFAULT_TIMEOUT}
+	}
+	if c.timeout > 0 {
+		httpClient := *c.httpClient
+		httpClient.Timeout = c.timeout
+		c.httpClient = &httpClient
+	}
+	return c
+}
+
+//the recommendations of productId, an unknown product comes back with no items
+func (c *Client) Get(ctx context.Context, productId string) (Product, error) {
+	if c.cache != nil {
+		if prod, ok := c.cache.get(productId); ok {
+			return prod, nil
+		}
+	}
+	var prod Product
+	var err error
+	for attempt := 0; ; attempt++ {
+		var retry bool
+		prod, retry, err = c.fetch(ctx, productId)
+		if err == nil || !retry || attempt >= c.retries {
+			break
+		}
+		if !c.sleep(ctx, attempt) {
+			return prod, ctx.Err()
+		}
+	}
+	if err == nil && c.cache != nil {
+		c.cache.put(productId, prod)
+	}
+	return prod, err
+}
+
+//the recommendations of every product, in the order asked for. the first failure fails the whole batch
+func (c *Client) BatchGet(ctx context.Context, productIds []string) ([]Product, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	results := make([]Product, len(productIds))
+	var firstErr error
+	var once sync.Once
+	var wg sync.WaitGroup
+	limit := make(chan struct{}, c.concurrency)
+	for i, productId := range productIds {
+		wg.Add(1)
+		limit <- struct{}{}
+		go func(i int, productId string) {
+			defer wg.Done()
+			defer func() { <-limit }()
+			prod, err := c.Get(ctx, productId)
+			if err != nil {
+				once.Do(func() {
+					firstErr = err
+					cancel()
+				})
+				return
+			}
+			results[i] = prod
+		}(i, productId)
+	}
+	wg.Wait()
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	return results, nil
+}
+
+//one attempt, also telling whether a failure is worth retrying
+func (c *Client) fetch(ctx context.Context, productId string) (Product, bool, error) {
+	var prod Product
+	req, err := http.NewRequest("GET", c.baseURL+"/recommendation/"+url.PathEscape(productId), nil)
+	if err != nil {
+		return prod, false, err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := c.httpClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return prod, ctx.Err() == nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		retry := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+		return prod, retry, &StatusError{StatusCode: resp.StatusCode, Body: string(body)}
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&prod); err != nil {
+		return prod, false, err
+	}
+	return prod, false, nil
+}
+
+//wait before the next attempt, full jitter over an exponential backoff. false when ctx ended first
+func (c *Client) sleep(ctx context.Context, attempt int) bool {
+	wait := time.Duration(rand.Int63n(int64(c.backoffCeiling(attempt)) + 1))
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+//the longest wait before the attempt following attempt, backoff doubled every attempt up to MAX_BACKOFF
+func (c *Client) backoffCeiling(attempt int) time.Duration {
+	if c.backoff <= 0 {
+		return 0
+	}
+	ceiling := c.backoff
+	for i := 0; i < attempt && ceiling < MAX_BACKOFF; i++ {
+		ceiling *= 2
+	}
+	if ceiling > MAX_BACKOFF {
+		return MAX_BACKOFF
+	}
+	return ceiling
+}
@@ -0,0 +1,139 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestFake(t *testing.T) {
+	fake := NewFake(
+		Product{ProductID: "A", BoughtTogetherItems: []BoughtTogetherItem{{ProductID: "B", TotalScore: 2}}},
+		Product{ProductID: "A", Color: "blue", BoughtTogetherItems: []BoughtTogetherItem{{ProductID: "C", TotalScore: 1}}},
+	)
+	ctx := context.Background()
+	prod, err := fake.Get(ctx, "A")
+	if err != nil || len(prod.BoughtTogetherItems) != 1 || prod.BoughtTogetherItems[0].ProductID != "B" {
+		t.Errorf("Get(A) = %+v, %v", prod, err)
+	}
+	prod, err = fake.Get(ctx, "unknown")
+	if err != nil || prod.ProductID != "unknown" || prod.BoughtTogetherItems == nil || len(prod.BoughtTogetherItems) != 0 {
+		t.Errorf("Get(unknown) = %+v, %v, want no items", prod, err)
+	}
+	products, err := fake.BatchGet(ctx, []string{"unknown", "A"})
+	if err != nil || len(products) != 2 || products[0].ProductID != "unknown" || products[1].ProductID != "A" {
+		t.Errorf("BatchGet = %+v, %v", products, err)
+	}
+	if want := []string{"A", "unknown", "unknown", "A"}; fmt.Sprint(fake.Calls) != fmt.Sprint(want) {
+		t.Errorf("calls %v, want %v", fake.Calls, want)
+	}
+
+	fake.Err = errors.New("unavailable")
+	if _, err := fake.BatchGet(ctx, []string{"A"}); err != fake.Err {
+		t.Errorf("BatchGet = %v, want %v", err, fake.Err)
+	}
+	fake.Err = nil
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := fake.Get(cancelled, "A"); err != context.Canceled {
+		t.Errorf("Get with a cancelled context = %v", err)
+	}
+}
+
+func TestCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	c := newCache(2, time.Minute)
+	c.put("A", Product{ProductID: "A"})
+	c.put("B", Product{ProductID: "B"})
+	c.get("A")
+	c.put("C", Product{ProductID: "C"})
+	if _, ok := c.get("B"); ok {
+		t.Error("B, the least recently used, was kept")
+	}
+	for _, productId := range []string{"A", "C"} {
+		if prod, ok := c.get(productId); !ok || prod.ProductID != productId {
+			t.Errorf("get(%s) = %+v, %t", productId, prod, ok)
+		}
+	}
+	c.put("A", Product{ProductID: "A", Color: "blue"})
+	if prod, _ := c.get("A"); prod.Color != "blue" || c.order.Len() != 2 {
+		t.Errorf("put of a cached product did not replace it: %+v, %d entries", prod, c.order.Len())
+	}
+}
+
+func TestCacheExpires(t *testing.T) {
+	c := newCache(2, -time.Second)
+	c.put("A", Product{ProductID: "A"})
+	if _, ok := c.get("A"); ok || len(c.entries) != 0 {
+		t.Error("an expired product was served")
+	}
+	c = newCache(0, time.Minute)
+	c.put("A", Product{ProductID: "A"})
+	if _, ok := c.get("A"); ok {
+		t.Error("a cache of size 0 kept a product")
+	}
+}
+
+func TestClientCachesAndRetries(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		json.NewEncoder(w).Encode(Product{ProductID: "A", BoughtTogetherItems: []BoughtTogetherItem{{ProductID: "B", TotalScore: 2}}})
+	}))
+	defer server.Close()
+	c := New(server.URL, WithRetries(1, time.Millisecond), WithCache(10, time.Minute))
+	for i := 0; i < 2; i++ {
+		prod, err := c.Get(context.Background(), "A")
+		if err != nil || len(prod.BoughtTogetherItems) != 1 {
+			t.Fatalf("Get(A) = %+v, %v", prod, err)
+		}
+	}
+	if atomic.LoadInt32(&calls) != 2 {
+		t.Errorf("%d calls, want a retry then the cache", calls)
+	}
+}
+
+func TestOptionsOrder(t *testing.T) {
+	httpClient := &http.Client{Timeout: time.Minute, Transport: &http.Transport{}}
+	for _, options := range [][]Option{
+		{WithTimeout(time.Second), WithHTTPClient(httpClient)},
+		{WithHTTPClient(httpClient), WithTimeout(time.Second)},
+	} {
+		c := New("http://recommendation:8080", options...)
+		if c.httpClient.Timeout != time.Second || c.httpClient.Transport != httpClient.Transport {
+			t.Errorf("timeout %s, transport kept %t", c.httpClient.Timeout, c.httpClient.Transport == httpClient.Transport)
+		}
+	}
+	if httpClient.Timeout != time.Minute {
+		t.Error("the http client given was modified")
+	}
+	if c := New("http://recommendation:8080", WithHTTPClient(httpClient)); c.httpClient.Timeout != time.Minute {
+		t.Errorf("timeout %s, want the one of the http client", c.httpClient.Timeout)
+	}
+	if c := New("http://recommendation:8080"); c.httpClient.Timeout != DEFAULT_TIMEOUT {
+		t.Errorf("timeout %s, want %s", c.httpClient.Timeout, DEFAULT_TIMEOUT)
+	}
+}
+
+func TestBackoffCeiling(t *testing.T) {
+	c := New("http://recommendation:8080", WithRetries(100, 50*time.Millisecond))
+	for attempt, want := range []time.Duration{50 * time.Millisecond, 100 * time.Millisecond, 200 * time.Millisecond} {
+		if got := c.backoffCeiling(attempt); got != want {
+			t.Errorf("attempt %d: %s, want %s", attempt, got, want)
+		}
+	}
+	//past the shift that overflows an int64
+	for _, attempt := range []int{10, 63, 64, 100} {
+		if got := c.backoffCeiling(attempt); got != MAX_BACKOFF {
+			t.Errorf("attempt %d: %s, want %s", attempt, got, MAX_BACKOFF)
+		}
+	}
+}
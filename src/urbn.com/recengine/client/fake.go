@@ -0,0 +1,53 @@
+package client
+
+import (
+	"context"
+	"sync"
+)
+
+//Fake is an in-memory Recommender for the unit tests of services using the client.
+//Unknown products come back with no items, the way the service answers them.
+type Fake struct {
+	mu       sync.Mutex
+	Products map[string]Product
+	//returned by every call when set
+	Err error
+	//the product ids asked for, in call order
+	Calls []string
+}
+
+func NewFake(products ...Product) *Fake {
+	fake := &Fake{Products: make(map[string]Product)}
+	for _, prod := range products {
+		fake.Products[prod.Key()] = prod
+	}
+	return fake
+}
+
+func (fake *Fake) Get(ctx context.Context, productId string) (Product, error) {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	fake.Calls = append(fake.Calls, productId)
+	if fake.Err != nil {
+		return Product{}, fake.Err
+	}
+	if err := ctx.Err(); err != nil {
+		return Product{}, err
+	}
+	if prod, ok := fake.Products[productId]; ok {
+		return prod, nil
+	}
+	return Product{ProductID: productId, BoughtTogetherItems: []BoughtTogetherItem{}}, nil
+}
+
+func (fake *Fake) BatchGet(ctx context.Context, productIds []string) ([]Product, error) {
+	results := make([]Product, 0, len(productIds))
+	for _, productId := range productIds {
+		prod, err := fake.Get(ctx, productId)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, prod)
+	}
+	return results, nil
+}
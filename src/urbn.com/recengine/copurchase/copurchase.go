@@ -0,0 +1,315 @@
+//Package copurchase builds the bought together model out of orders: how often every pair of products is bought
+//in the same order, overall and per region, scored by count, confidence or lift, in the Product records the
+//engine loads.
+package copurchase
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"sort"
+	"urbn.com/recengine/model"
+)
+
+//what the TotalScore of a bought together item is
+const (
+	//the number of orders with both products
+	SCORE_COUNT = "count"
+	//confidence(A->B), the share of the orders of A which also have B, in permille
+	SCORE_CONFIDENCE = "confidence"
+	//lift(A->B), confidence(A->B) over the share of all orders having B, in hundredths
+	SCORE_LIFT = "lift"
+
+	CONFIDENCE_SCALE = 1000
+	LIFT_SCALE       = 100
+)
+
+var ErrUnknownScore = errors.New("unknown score, expecting count, confidence or lift")
+
+//Options of the model built from the counts
+type Options struct {
+	//SCORE_COUNT, SCORE_CONFIDENCE or SCORE_LIFT
+	Score string
+	//the count score is the number of orders times CountScale, which keeps the fractions of the weights of
+	//decayed orders. 0 is 1
+	CountScale int
+	//pairs bought together in fewer orders are dropped, whatever the weight of the orders. the pairs scoring
+	//0, like those of orders decayed to a weight under half a count, are dropped too
+	MinCount int
+	//pairs under these confidence and lift are dropped, 0 keeps every pair
+	MinConfidence float64
+	MinLift       float64
+	//the most items kept per product, 0 keeps them all
+	MaxItems int
+}
+
+func DefaultOptions() Options {
+	return Options{Score: SCORE_COUNT, CountScale: 1, MinCount: 1, MaxItems: 50}
+}
+
+//an unordered pair of products, a < b
+type pairKey struct {
+	a string
+	b string
+}
+
+func newPairKey(a string, b string) pairKey {
+	if b < a {
+		a, b = b, a
+	}
+	return pairKey{a: a, b: b}
+}
+
+//a count within one region
+type regionKey struct {
+	region    string
+	productId string
+}
+
+type regionPairKey struct {
+	region string
+	pair   pairKey
+}
+
+//Builder counts the orders every product and every pair of products appears in, overall and per region.
+//every order counts as its weight, 1 unless it is decayed by its age
+type Builder struct {
+	Orders int
+	//only the pairs of products it keeps are counted, every pair when it is nil. the orders and products are
+	//counted either way
+	PairFilter     func(a string, b string) bool
+	weight         float64
+	products       map[string]float64
+	pairs          map[pairKey]float64
+	pairOrders     map[pairKey]int
+	regionOrders   map[string]float64
+	regionProducts map[regionKey]float64
+	regionPairs    map[regionPairKey]float64
+}
+
+func NewBuilder() *Builder {
+	return &Builder{
+		products:       make(map[string]float64),
+		pairs:          make(map[pairKey]float64),
+		pairOrders:     make(map[pairKey]int),
+		regionOrders:   make(map[string]float64),
+		regionProducts: make(map[regionKey]float64),
+		regionPairs:    make(map[regionPairKey]float64),
+	}
+}
+
+//count one order. a product bought more than once, in several skus or quantities, counts once
+func (builder *Builder) AddOrder(productIds []string) {
+	builder.AddRegionOrder(nil, productIds)
+}
+
+//count one order overall and in each of regions, the state it shipped to and the region groups of the state
+func (builder *Builder) AddRegionOrder(regions []string, productIds []string) {
+	builder.AddWeightedOrder(regions, productIds, 1)
+}
+
+//count one order of weight overall and in each of regions
+func (builder *Builder) AddWeightedOrder(regions []string, productIds []string, weight float64) {
+	distinct := make([]string, 0, len(productIds))
+	seen := make(map[string]bool, len(productIds))
+	for _, productId := range productIds {
+		if productId != "" && !seen[productId] {
+			seen[productId] = true
+			distinct = append(distinct, productId)
+		}
+	}
+	if len(distinct) == 0 {
+		return
+	}
+	builder.Orders++
+	builder.weight += weight
+	for i, a := range distinct {
+		builder.products[a] += weight
+		for _, b := range distinct[i+1:] {
+			if builder.PairFilter != nil && !builder.PairFilter(a, b) {
+				continue
+			}
+			builder.pairs[newPairKey(a, b)] += weight
+			builder.pairOrders[newPairKey(a, b)]++
+		}
+	}
+	for _, region := range regions {
+		if region == "" {
+			continue
+		}
+		builder.regionOrders[region] += weight
+		for i, a := range distinct {
+			builder.regionProducts[regionKey{region: region, productId: a}] += weight
+			for _, b := range distinct[i+1:] {
+				if builder.PairFilter != nil && !builder.PairFilter(a, b) {
+					continue
+				}
+				builder.regionPairs[regionPairKey{region: region, pair: newPairKey(a, b)}] += weight
+			}
+		}
+	}
+}
+
+//the number, or weight, of the orders with productId
+func (builder *Builder) Count(productId string) float64 {
+	return builder.products[productId]
+}
+
+//the number, or weight, of the orders with both products
+func (builder *Builder) PairCount(a string, b string) float64 {
+	return builder.pairs[newPairKey(a, b)]
+}
+
+//the association of B with A, bought together in Count orders
+type Association struct {
+	Count      float64
+	Support    float64
+	Confidence float64
+	Lift       float64
+}
+
+//the association of b with a, zero when they were never bought together
+func (builder *Builder) Association(a string, b string) Association {
+	return association(builder.weight, builder.products[a], builder.products[b], builder.PairCount(a, b))
+}
+
+//the association of b with a within the orders of region
+func (builder *Builder) RegionAssociation(region string, a string, b string) Association {
+	return association(builder.regionOrders[region],
+		builder.regionProducts[regionKey{region: region, productId: a}],
+		builder.regionProducts[regionKey{region: region, productId: b}],
+		builder.regionPairs[regionPairKey{region: region, pair: newPairKey(a, b)}])
+}
+
+//the association of b with a out of orders, countA and countB of them with a and b and count with both
+func association(orders float64, countA float64, countB float64, count float64) Association {
+	if count == 0 || orders == 0 {
+		return Association{}
+	}
+	confidence := count / countA
+	return Association{
+		Count:      count,
+		Support:    count / orders,
+		Confidence: confidence,
+		Lift:       confidence / (countB / orders),
+	}
+}
+
+//the score of an association as a TotalScore
+func score(association Association, options Options) int {
+	switch options.Score {
+	case SCORE_CONFIDENCE:
+		return int(association.Confidence*CONFIDENCE_SCALE + 0.5)
+	case SCORE_LIFT:
+		return int(association.Lift*LIFT_SCALE + 0.5)
+	}
+	countScale := options.CountScale
+	if countScale <= 0 {
+		countScale = 1
+	}
+	return int(association.Count*float64(countScale) + 0.5)
+}
+
+//the products bought together with anything, each with its items best first. ties are broken by the
+//number of orders, then by product id, so the same orders always build the same model. an item, or the score
+//of a region, rounding to a score of 0 is dropped: it would be served as a recommendation of no weight
+func (builder *Builder) Products(options Options) ([]model.Product, error) {
+	if options.Score != SCORE_COUNT && options.Score != SCORE_CONFIDENCE && options.Score != SCORE_LIFT {
+		return nil, ErrUnknownScore
+	}
+	related := make(map[string][]string)
+	for pair, count := range builder.pairOrders {
+		if count < options.MinCount {
+			continue
+		}
+		related[pair.a] = append(related[pair.a], pair.b)
+		related[pair.b] = append(related[pair.b], pair.a)
+	}
+
+	pairRegions := make(map[pairKey][]string)
+	for key := range builder.regionPairs {
+		pairRegions[key.pair] = append(pairRegions[key.pair], key.region)
+	}
+
+	productIds := make([]string, 0, len(related))
+	for productId := range related {
+		productIds = append(productIds, productId)
+	}
+	sort.Strings(productIds)
+
+	products := make([]model.Product, 0, len(productIds))
+	for _, productId := range productIds {
+		type scored struct {
+			productId string
+			count     float64
+			score     int
+		}
+		var items []scored
+		for _, other := range related[productId] {
+			association := builder.Association(productId, other)
+			if association.Confidence < options.MinConfidence || association.Lift < options.MinLift {
+				continue
+			}
+			if itemScore := score(association, options); itemScore > 0 {
+				items = append(items, scored{productId: other, count: association.Count, score: itemScore})
+			}
+		}
+		if len(items) == 0 {
+			continue
+		}
+		sort.Slice(items, func(i, j int) bool {
+			if items[i].score != items[j].score {
+				return items[i].score > items[j].score
+			} else if items[i].count != items[j].count {
+				return items[i].count > items[j].count
+			}
+			return items[i].productId < items[j].productId
+		})
+		if options.MaxItems > 0 && len(items) > options.MaxItems {
+			items = items[:options.MaxItems]
+		}
+		prod := model.Product{ProductID: productId, BoughtTogetherItems: make([]model.BoughtTogetherItem, len(items))}
+		for i, item := range items {
+			prod.BoughtTogetherItems[i] = model.BoughtTogetherItem{
+				ProductID:     item.productId,
+				TotalScore:    item.score,
+				ScoreByRegion: builder.regionScores(pairRegions[newPairKey(productId, item.productId)], productId, item.productId, options),
+			}
+		}
+		products = append(products, prod)
+	}
+	return products, nil
+}
+
+//the scores of b with a in every region they were bought together in, best first
+func (builder *Builder) regionScores(regions []string, a string, b string, options Options) []model.RegionScore {
+	scores := make([]model.RegionScore, 0, len(regions))
+	for _, region := range regions {
+		if regionScore := score(builder.RegionAssociation(region, a, b), options); regionScore > 0 {
+			scores = append(scores, model.RegionScore{Region: region, Score: regionScore})
+		}
+	}
+	sort.Slice(scores, func(i, j int) bool {
+		if scores[i].Score != scores[j].Score {
+			return scores[i].Score > scores[j].Score
+		}
+		return scores[i].Region < scores[j].Region
+	})
+	return scores
+}
+
+//write the products as the (productId,{json}) spark tuple lines of a part file
+func WriteSparkTuples(w io.Writer, products []model.Product) error {
+	bw := bufio.NewWriter(w)
+	for _, prod := range products {
+		value, err := json.Marshal(prod)
+		if err != nil {
+			return err
+		}
+		bw.WriteString("(" + prod.Key() + ",")
+		bw.Write(value)
+		bw.WriteString(")\n")
+	}
+	return bw.Flush()
+}
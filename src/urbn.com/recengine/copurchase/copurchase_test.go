@@ -0,0 +1,203 @@
+package copurchase
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+	"urbn.com/recengine/model"
+)
+
+//five orders, by hand: A is in 4, B in 4, C in 2 and D in 1. A and B are bought together in 3, A and C in 2,
+//B and C in 1, B and D in 1
+func testBuilder() *Builder {
+	builder := NewBuilder()
+	builder.AddRegionOrder([]string{"PA"}, []string{"A", "B", "C"})
+	builder.AddRegionOrder([]string{"NY"}, []string{"A", "B"})
+	builder.AddRegionOrder([]string{"PA"}, []string{"A", "C"})
+	builder.AddOrder([]string{"B", "D"})
+	//a product bought twice counts once, an empty id not at all
+	builder.AddOrder([]string{"A", "B", "A", ""})
+	builder.AddOrder([]string{""})
+	return builder
+}
+
+//the products as A:B3,C2 B:A3
+func formatProducts(products []model.Product) string {
+	var formatted []string
+	for _, prod := range products {
+		var items []string
+		for _, item := range prod.BoughtTogetherItems {
+			items = append(items, fmt.Sprintf("%s%d", item.ProductID, item.TotalScore))
+		}
+		formatted = append(formatted, prod.ProductID+":"+strings.Join(items, ","))
+	}
+	return strings.Join(formatted, " ")
+}
+
+func TestAssociation(t *testing.T) {
+	builder := testBuilder()
+	if builder.Orders != 5 {
+		t.Errorf("%d orders, want 5", builder.Orders)
+	}
+	for _, test := range []struct {
+		a, b string
+		want Association
+	}{
+		{"A", "B", Association{Count: 3, Support: 3.0 / 5, Confidence: 3.0 / 4, Lift: (3.0 / 4) / (4.0 / 5)}},
+		{"A", "C", Association{Count: 2, Support: 2.0 / 5, Confidence: 2.0 / 4, Lift: (2.0 / 4) / (2.0 / 5)}},
+		{"C", "A", Association{Count: 2, Support: 2.0 / 5, Confidence: 1, Lift: 1 / (4.0 / 5)}},
+		{"D", "B", Association{Count: 1, Support: 1.0 / 5, Confidence: 1, Lift: 1 / (4.0 / 5)}},
+		{"C", "D", Association{}},
+	} {
+		if got := builder.Association(test.a, test.b); got != test.want {
+			t.Errorf("Association(%s, %s) = %+v, want %+v", test.a, test.b, got, test.want)
+		}
+	}
+	//two PA orders, both with A and one with B
+	want := Association{Count: 1, Support: 1.0 / 2, Confidence: 1.0 / 2, Lift: (1.0 / 2) / (1.0 / 2)}
+	if got := builder.RegionAssociation("PA", "A", "B"); got != want {
+		t.Errorf("RegionAssociation(PA, A, B) = %+v, want %+v", got, want)
+	}
+}
+
+func TestProducts(t *testing.T) {
+	builder := testBuilder()
+	for name, test := range map[string]struct {
+		options Options
+		want    string
+	}{
+		"count": {DefaultOptions(), "A:B3,C2 B:A3,C1,D1 C:A2,B1 D:B1"},
+		//permille: A->B 3/4, B->C 1/4, C->A 2/2
+		"confidence": {Options{Score: SCORE_CONFIDENCE}, "A:B750,C500 B:A750,C250,D250 C:A1000,B500 D:B1000"},
+		//hundredths: A->B 0.75/0.8 is 93.75, B->C 0.25/0.4 is 62.5, rounded half up
+		"lift":           {Options{Score: SCORE_LIFT}, "A:C125,B94 B:D125,A94,C63 C:A125,B63 D:B125"},
+		"scaled":         {Options{Score: SCORE_COUNT, CountScale: 100}, "A:B300,C200 B:A300,C100,D100 C:A200,B100 D:B100"},
+		"min count":      {Options{Score: SCORE_COUNT, MinCount: 2}, "A:B3,C2 B:A3 C:A2"},
+		"min lift":       {Options{Score: SCORE_LIFT, MinLift: 1}, "A:C125 B:D125 C:A125 D:B125"},
+		"min confidence": {Options{Score: SCORE_CONFIDENCE, MinConfidence: 0.5}, "A:B750,C500 B:A750 C:A1000,B500 D:B1000"},
+		"max items":      {Options{Score: SCORE_COUNT, MaxItems: 1}, "A:B3 B:A3 C:A2 D:B1"},
+	} {
+		products, err := builder.Products(test.options)
+		if err != nil {
+			t.Fatalf("%s: %s", name, err.Error())
+		}
+		if got := formatProducts(products); got != test.want {
+			t.Errorf("%s: %s, want %s", name, got, test.want)
+		}
+	}
+	if _, err := builder.Products(Options{Score: "support"}); err != ErrUnknownScore {
+		t.Errorf("score support: %v, want %v", err, ErrUnknownScore)
+	}
+}
+
+func TestRegionScores(t *testing.T) {
+	products, err := testBuilder().Products(Options{Score: SCORE_CONFIDENCE})
+	if err != nil {
+		t.Fatal(err)
+	}
+	//A->B is 1 of the 2 PA orders with A, 1 of the 1 NY order
+	if got := fmt.Sprint(products[0].BoughtTogetherItems[0].ScoreByRegion); got != "[{NY 1000} {PA 500}]" {
+		t.Errorf("A->B by region %s", got)
+	}
+	//B and D were bought together in no region
+	if got := products[3].BoughtTogetherItems[0].ScoreByRegion; got == nil || len(got) != 0 {
+		t.Errorf("D->B by region %#v, want empty", got)
+	}
+}
+
+func TestCountsRoundTrip(t *testing.T) {
+	builder := NewBuilder()
+	builder.AddCounts(testBuilder().Counts(), 1)
+	for _, score := range []string{SCORE_COUNT, SCORE_CONFIDENCE, SCORE_LIFT} {
+		want, _ := testBuilder().Products(Options{Score: score})
+		got, _ := builder.Products(Options{Score: score})
+		if fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("%s: the added counts built %v, want %v", score, got, want)
+		}
+	}
+}
+
+func TestWriteSparkTuples(t *testing.T) {
+	products, err := testBuilder().Products(Options{Score: SCORE_COUNT, MinCount: 2, MaxItems: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var w bytes.Buffer
+	if err := WriteSparkTuples(&w, products); err != nil {
+		t.Fatal(err)
+	}
+	want := `(A,{"productId":"A","boughtTogetherItems":[{"productId":"B","totalScore":3,"scoreByRegion":[{"region":"NY","score":1},{"region":"PA","score":1}]}]})
+(B,{"productId":"B","boughtTogetherItems":[{"productId":"A","totalScore":3,"scoreByRegion":[{"region":"NY","score":1},{"region":"PA","score":1}]}]})
+(C,{"productId":"C","boughtTogetherItems":[{"productId":"A","totalScore":2,"scoreByRegion":[{"region":"PA","score":2}]}]})
+`
+	if w.String() != want {
+		t.Errorf("wrote\n%swant\n%s", w.String(), want)
+	}
+}
+
+func TestPairFilter(t *testing.T) {
+	builder := NewBuilder()
+	//only the pairs with A
+	builder.PairFilter = func(a string, b string) bool {
+		return a == "A" || b == "A"
+	}
+	builder.AddRegionOrder([]string{"PA"}, []string{"A", "B", "C"})
+	builder.AddOrder([]string{"B", "C"})
+	products, err := builder.Products(DefaultOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := formatProducts(products); got != "A:B1,C1 B:A1 C:A1" {
+		t.Errorf("%s, want A:B1,C1 B:A1 C:A1", got)
+	}
+	if builder.Orders != 2 || builder.Count("B") != 2 {
+		t.Errorf("%d orders, B in %g, want 2 and 2", builder.Orders, builder.Count("B"))
+	}
+	if got := builder.RegionAssociation("PA", "B", "C"); got.Count != 0 {
+		t.Errorf("counted B and C in PA %g times", got.Count)
+	}
+}
+
+func TestDecayedScores(t *testing.T) {
+	asOf := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+	decay := Decay{HalfLife: 24 * time.Hour, AsOf: asOf}
+	builder := NewBuilder()
+	builder.AddWeightedOrder([]string{"PA"}, []string{"A", "B"}, decay.Weight(asOf))
+	//ten half lives old, a thousandth of an order
+	builder.AddWeightedOrder([]string{"NY"}, []string{"A", "C"}, decay.Weight(asOf.Add(-240*time.Hour)))
+	options := DefaultOptions()
+	options.CountScale = DECAYED_COUNT_SCALE
+	products, err := builder.Products(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	//A and C were bought together in MinCount orders, but score 0 and are dropped
+	if got := formatProducts(products); got != "A:B100 B:A100" {
+		t.Errorf("%s, want A:B100 B:A100", got)
+	}
+	if got := fmt.Sprint(products[0].BoughtTogetherItems[0].ScoreByRegion); got != "[{PA 100}]" {
+		t.Errorf("A->B by region %s", got)
+	}
+	if got := formatPopular(builder.TopSellers(options)); got != "A100 B100" {
+		t.Errorf("the top sellers are %s, want A100 B100", got)
+	}
+
+	info := NewModelInfo(options, decay, builder.Orders, len(products))
+	if info.CountScale != DECAYED_COUNT_SCALE || info.HalfLife != "24h0m0s" || !info.AsOf.Equal(asOf) || info.Orders != 2 || info.Products != 2 {
+		t.Errorf("model info %+v", info)
+	}
+	if info := NewModelInfo(Options{Score: SCORE_LIFT}, Decay{AsOf: asOf}, 2, 2); info.CountScale != 1 || info.HalfLife != "" {
+		t.Errorf("undecayed model info %+v", info)
+	}
+}
+
+//the items of a popularity list as A100 B100
+func formatPopular(list model.PopularList) string {
+	var items []string
+	for _, item := range list.Items {
+		items = append(items, fmt.Sprintf("%s%d", item.ProductID, item.TotalScore))
+	}
+	return strings.Join(items, " ")
+}
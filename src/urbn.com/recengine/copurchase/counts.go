@@ -0,0 +1,110 @@
+package copurchase
+
+import (
+	"sort"
+)
+
+//Counts are the order counts of a Builder of unweighted orders, which are kept to be added to another Builder
+//later, like the counts of the orders of one day
+type Counts struct {
+	Orders         int               `json:"orders"`
+	Products       map[string]int    `json:"products"`
+	Pairs          []PairCount       `json:"pairs"`
+	RegionOrders   map[string]int    `json:"regionOrders,omitempty"`
+	RegionProducts []RegionCount     `json:"regionProducts,omitempty"`
+	RegionPairs    []RegionPairCount `json:"regionPairs,omitempty"`
+}
+
+type PairCount struct {
+	A     string `json:"a"`
+	B     string `json:"b"`
+	Count int    `json:"count"`
+}
+
+type RegionCount struct {
+	Region    string `json:"region"`
+	ProductId string `json:"productId"`
+	Count     int    `json:"count"`
+}
+
+type RegionPairCount struct {
+	Region string `json:"region"`
+	A      string `json:"a"`
+	B      string `json:"b"`
+	Count  int    `json:"count"`
+}
+
+//the counts of the orders added, sorted so the same orders always give the same counts. the weights of
+//weighted orders are not kept, they count as rounded
+func (builder *Builder) Counts() Counts {
+	counts := Counts{
+		Orders:       builder.Orders,
+		Products:     make(map[string]int, len(builder.products)),
+		Pairs:        make([]PairCount, 0, len(builder.pairOrders)),
+		RegionOrders: make(map[string]int, len(builder.regionOrders)),
+	}
+	for productId, count := range builder.products {
+		counts.Products[productId] = round(count)
+	}
+	for pair, count := range builder.pairOrders {
+		counts.Pairs = append(counts.Pairs, PairCount{A: pair.a, B: pair.b, Count: count})
+	}
+	sort.Slice(counts.Pairs, func(i, j int) bool {
+		if counts.Pairs[i].A != counts.Pairs[j].A {
+			return counts.Pairs[i].A < counts.Pairs[j].A
+		}
+		return counts.Pairs[i].B < counts.Pairs[j].B
+	})
+	for region, count := range builder.regionOrders {
+		counts.RegionOrders[region] = round(count)
+	}
+	for key, count := range builder.regionProducts {
+		counts.RegionProducts = append(counts.RegionProducts, RegionCount{Region: key.region, ProductId: key.productId, Count: round(count)})
+	}
+	sort.Slice(counts.RegionProducts, func(i, j int) bool {
+		if counts.RegionProducts[i].Region != counts.RegionProducts[j].Region {
+			return counts.RegionProducts[i].Region < counts.RegionProducts[j].Region
+		}
+		return counts.RegionProducts[i].ProductId < counts.RegionProducts[j].ProductId
+	})
+	for key, count := range builder.regionPairs {
+		counts.RegionPairs = append(counts.RegionPairs, RegionPairCount{Region: key.region, A: key.pair.a, B: key.pair.b, Count: round(count)})
+	}
+	sort.Slice(counts.RegionPairs, func(i, j int) bool {
+		a, b := counts.RegionPairs[i], counts.RegionPairs[j]
+		if a.Region != b.Region {
+			return a.Region < b.Region
+		} else if a.A != b.A {
+			return a.A < b.A
+		}
+		return a.B < b.B
+	})
+	return counts
+}
+
+//add counts, every order of them weighing weight
+func (builder *Builder) AddCounts(counts Counts, weight float64) {
+	builder.Orders += counts.Orders
+	builder.weight += float64(counts.Orders) * weight
+	for productId, count := range counts.Products {
+		builder.products[productId] += float64(count) * weight
+	}
+	for _, pair := range counts.Pairs {
+		key := newPairKey(pair.A, pair.B)
+		builder.pairs[key] += float64(pair.Count) * weight
+		builder.pairOrders[key] += pair.Count
+	}
+	for region, count := range counts.RegionOrders {
+		builder.regionOrders[region] += float64(count) * weight
+	}
+	for _, count := range counts.RegionProducts {
+		builder.regionProducts[regionKey{region: count.Region, productId: count.ProductId}] += float64(count.Count) * weight
+	}
+	for _, pair := range counts.RegionPairs {
+		builder.regionPairs[regionPairKey{region: pair.Region, pair: newPairKey(pair.A, pair.B)}] += float64(pair.Count) * weight
+	}
+}
+
+func round(count float64) int {
+	return int(count + 0.5)
+}
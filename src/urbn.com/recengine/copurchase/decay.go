@@ -0,0 +1,55 @@
+package copurchase
+
+import (
+	"math"
+	"time"
+)
+
+//the CountScale of a decayed model, its count scores are in hundredths of an order
+const DECAYED_COUNT_SCALE = 100
+
+//the file written next to the part files of a model telling how its scores read, which the loader skips as it
+//is no part file
+const MODEL_INFO = "_model.json"
+
+//ModelInfo tells how the scores of a model built out of orders read: the score, the count scores in
+//1/CountScale of an order, and the decay of the orders when they are weighed by their age at AsOf
+type ModelInfo struct {
+	Score      string    `json:"score"`
+	CountScale int       `json:"countScale"`
+	HalfLife   string    `json:"halfLife,omitempty"`
+	AsOf       time.Time `json:"asOf"`
+	Orders     int       `json:"orders"`
+	Products   int       `json:"products"`
+}
+
+//the info of a model of orders built with options and decay
+func NewModelInfo(options Options, decay Decay, orders int, products int) ModelInfo {
+	info := ModelInfo{Score: options.Score, CountScale: options.CountScale, AsOf: decay.AsOf, Orders: orders, Products: products}
+	if info.CountScale <= 0 {
+		info.CountScale = 1
+	}
+	if decay.HalfLife > 0 {
+		info.HalfLife = decay.HalfLife.String()
+	}
+	return info
+}
+
+//Decay weighs an order by its age at AsOf: an order of that time weighs 1, one HalfLife older 1/2, two 1/4.
+//the orders after AsOf weigh 1
+type Decay struct {
+	HalfLife time.Duration
+	AsOf     time.Time
+}
+
+//the weight of an order placed at
+func (decay Decay) Weight(at time.Time) float64 {
+	if decay.HalfLife <= 0 {
+		return 1
+	}
+	age := decay.AsOf.Sub(at)
+	if age <= 0 {
+		return 1
+	}
+	return math.Exp2(-float64(age) / float64(decay.HalfLife))
+}
@@ -0,0 +1,118 @@
+package copurchase
+
+import (
+	"sort"
+	"urbn.com/recengine/model"
+)
+
+//a product scored in a popularity list, overall or within one region
+type popularScore struct {
+	count float64
+	score int
+}
+
+//the top sellers, the products of the most orders scored by their count. the MaxItems best products overall
+//and within every region are kept, so the list filtered by a region is as long as the overall one. the products
+//scoring 0, of orders decayed to a weight under half a count, are dropped
+func (builder *Builder) TopSellers(options Options) model.PopularList {
+	options.Score = SCORE_COUNT
+	overall := make(map[string]popularScore, len(builder.products))
+	for productId, count := range builder.products {
+		if productScore := score(Association{Count: count}, options); productScore > 0 {
+			overall[productId] = popularScore{count: count, score: productScore}
+		}
+	}
+	regional := make(map[regionKey]popularScore, len(builder.regionProducts))
+	for key, count := range builder.regionProducts {
+		if regionScore := score(Association{Count: count}, options); regionScore > 0 {
+			regional[key] = popularScore{count: count, score: regionScore}
+		}
+	}
+	return popularList(model.LIST_TOP_SELLERS, overall, regional, options.MaxItems)
+}
+
+//the trending products of recent, the builder of the orders of the last days when builder counts all of them,
+//the recent ones included. a product scores the lift of its share of the recent orders over its share of all
+//the orders, in hundredths, and needs to be in MinCount recent orders
+func (builder *Builder) Trending(recent *Builder, options Options) model.PopularList {
+	overall := make(map[string]popularScore, len(recent.products))
+	for productId, count := range recent.products {
+		if count < float64(options.MinCount) || builder.products[productId] == 0 {
+			continue
+		}
+		lift := (count / recent.weight) / (builder.products[productId] / builder.weight)
+		overall[productId] = popularScore{count: count, score: int(lift*LIFT_SCALE + 0.5)}
+	}
+	regional := make(map[regionKey]popularScore, len(recent.regionProducts))
+	for key, count := range recent.regionProducts {
+		if _, ok := overall[key.productId]; !ok || builder.regionProducts[key] == 0 {
+			continue
+		}
+		lift := (count / recent.regionOrders[key.region]) / (builder.regionProducts[key] / builder.regionOrders[key.region])
+		regional[key] = popularScore{count: count, score: int(lift*LIFT_SCALE + 0.5)}
+	}
+	return popularList(model.LIST_TRENDING, overall, regional, options.MaxItems)
+}
+
+//rank the products of a list, best first, and keep the maxItems best overall and within every region. the
+//ScoreByRegion of an item has the regions it is among the best of
+func popularList(list string, overall map[string]popularScore, regional map[regionKey]popularScore, maxItems int) model.PopularList {
+	byRegion := make(map[string][]rankedProduct)
+	for key, regionScore := range regional {
+		byRegion[key.region] = append(byRegion[key.region], rankedProduct{productId: key.productId, popularScore: regionScore})
+	}
+	kept := make(map[string][]model.RegionScore)
+	for region, ranked := range byRegion {
+		for _, product := range rankProducts(ranked, maxItems) {
+			kept[product.productId] = append(kept[product.productId], model.RegionScore{Region: region, Score: product.score})
+		}
+	}
+	ranked := make([]rankedProduct, 0, len(overall))
+	for productId, productScore := range overall {
+		ranked = append(ranked, rankedProduct{productId: productId, popularScore: productScore})
+	}
+	for _, product := range rankProducts(ranked, maxItems) {
+		if _, ok := kept[product.productId]; !ok {
+			kept[product.productId] = []model.RegionScore{}
+		}
+	}
+
+	ranked = make([]rankedProduct, 0, len(kept))
+	for productId := range kept {
+		ranked = append(ranked, rankedProduct{productId: productId, popularScore: overall[productId]})
+	}
+	items := make([]model.BoughtTogetherItem, 0, len(kept))
+	for _, product := range rankProducts(ranked, 0) {
+		regionScores := kept[product.productId]
+		sort.Slice(regionScores, func(i, j int) bool {
+			if regionScores[i].Score != regionScores[j].Score {
+				return regionScores[i].Score > regionScores[j].Score
+			}
+			return regionScores[i].Region < regionScores[j].Region
+		})
+		items = append(items, model.BoughtTogetherItem{ProductID: product.productId, TotalScore: product.score, ScoreByRegion: regionScores})
+	}
+	return model.PopularList{List: list, Items: items}
+}
+
+type rankedProduct struct {
+	productId string
+	popularScore
+}
+
+//sort the products best first, ties broken by count then by product id, and keep the maxItems best. 0 keeps
+//them all
+func rankProducts(ranked []rankedProduct, maxItems int) []rankedProduct {
+	sort.Slice(ranked, func(i, j int) bool {
+		if ranked[i].score != ranked[j].score {
+			return ranked[i].score > ranked[j].score
+		} else if ranked[i].count != ranked[j].count {
+			return ranked[i].count > ranked[j].count
+		}
+		return ranked[i].productId < ranked[j].productId
+	})
+	if maxItems > 0 && len(ranked) > maxItems {
+		ranked = ranked[:maxItems]
+	}
+	return ranked
+}
@@ -0,0 +1,105 @@
+package copurchase
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+)
+
+//the regions an order is counted in
+const (
+	//the state it shipped to, a code like PA
+	REGIONS_STATE = "state"
+	//the region groups of the state, like NORTHEAST. a state in no group is counted in the state
+	REGIONS_GROUP = "group"
+	//both the state and its groups
+	REGIONS_BOTH = "both"
+)
+
+var ErrUnknownRegionLevel = errors.New("unknown region level, expecting state, group or both")
+
+//the codes of the states, the district of columbia, the territories and the military post offices of the US
+var stateCodes = map[string]bool{
+	"AL": true, "AK": true, "AZ": true, "AR": true, "CA": true, "CO": true, "CT": true, "DE": true, "FL": true,
+	"GA": true, "HI": true, "ID": true, "IL": true, "IN": true, "IA": true, "KS": true, "KY": true, "LA": true,
+	"ME": true, "MD": true, "MA": true, "MI": true, "MN": true, "MS": true, "MO": true, "MT": true, "NE": true,
+	"NV": true, "NH": true, "NJ": true, "NM": true, "NY": true, "NC": true, "ND": true, "OH": true, "OK": true,
+	"OR": true, "PA": true, "RI": true, "SC": true, "SD": true, "TN": true, "TX": true, "UT": true, "VT": true,
+	"VA": true, "WA": true, "WV": true, "WI": true, "WY": true, "DC": true,
+	"PR": true, "GU": true, "VI": true, "AS": true, "MP": true, "AA": true, "AE": true, "AP": true,
+}
+
+//Regions tells the regions an order shipped to a state is counted in
+type Regions struct {
+	level   string
+	byState map[string][]string
+	groups  map[string]bool
+}
+
+//the regions of level, groups maps the name of every region group to the state codes in it. the names are
+//trimmed and upper cased like the state codes, the way the regions of a request are looked up
+func NewRegions(level string, groups map[string][]string) (*Regions, error) {
+	if level != REGIONS_STATE && level != REGIONS_GROUP && level != REGIONS_BOTH {
+		return nil, ErrUnknownRegionLevel
+	}
+	regions := &Regions{level: level, byState: make(map[string][]string), groups: make(map[string]bool)}
+	for group, states := range groups {
+		group = normalizeState(group)
+		if group == "" {
+			return nil, errors.New("a region group has no name")
+		} else if regions.groups[group] {
+			return nil, fmt.Errorf("the region group %s is named twice", group)
+		}
+		regions.groups[group] = true
+		for _, state := range states {
+			state = normalizeState(state)
+			regions.byState[state] = append(regions.byState[state], group)
+		}
+	}
+	for _, groups := range regions.byState {
+		sort.Strings(groups)
+	}
+	return regions, nil
+}
+
+//read the region groups of a json file: {"NORTHEAST": ["PA", "NY", ...], ...}
+func LoadRegionGroups(fileName string) (map[string][]string, error) {
+	contents, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	groups := make(map[string][]string)
+	if err := json.Unmarshal(contents, &groups); err != nil {
+		return nil, err
+	}
+	return groups, nil
+}
+
+//the regions of an order shipped to state, none when the state is unknown
+func (regions *Regions) Of(state string) []string {
+	state = normalizeState(state)
+	if state == "" {
+		return nil
+	}
+	groups := regions.byState[state]
+	switch {
+	case regions.level == REGIONS_STATE || len(groups) == 0:
+		return []string{state}
+	case regions.level == REGIONS_GROUP:
+		return groups
+	}
+	return append([]string{state}, groups...)
+}
+
+//whether region is the code of a US state, or of any state in a region group, or the name of a region group
+func (regions *Regions) Known(region string) bool {
+	region = normalizeState(region)
+	return regions.groups[region] || stateCodes[region] || len(regions.byState[region]) > 0
+}
+
+func normalizeState(state string) string {
+	return strings.ToUpper(strings.TrimSpace(state))
+}
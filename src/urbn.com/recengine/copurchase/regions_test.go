@@ -0,0 +1,59 @@
+package copurchase
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestRegions(t *testing.T) {
+	groups := map[string][]string{"NORTHEAST": {"PA", "ny"}, "EAST": {"PA"}, "CANADA": {"ON"}}
+	for level, want := range map[string]string{
+		REGIONS_STATE: "[PA] [NY] [TX] []",
+		REGIONS_GROUP: "[EAST NORTHEAST] [NORTHEAST] [TX] []",
+		REGIONS_BOTH:  "[PA EAST NORTHEAST] [NY NORTHEAST] [TX] []",
+	} {
+		regions, err := NewRegions(level, groups)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := fmt.Sprint(regions.Of(" pa"), regions.Of("NY"), regions.Of("tx"), regions.Of("")); got != want {
+			t.Errorf("%s: %s, want %s", level, got, want)
+		}
+	}
+	if _, err := NewRegions("country", groups); err != ErrUnknownRegionLevel {
+		t.Errorf("level country: %v", err)
+	}
+}
+
+func TestRegionsKnown(t *testing.T) {
+	regions, err := NewRegions(REGIONS_BOTH, map[string][]string{"CANADA": {"ON"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for region, want := range map[string]bool{"PA": true, "pa": true, "DC": true, "CANADA": true, "ON": true, "XX": false, "NORTHEAST": false} {
+		if got := regions.Known(region); got != want {
+			t.Errorf("Known(%s) = %t, want %t", region, got, want)
+		}
+	}
+}
+
+//the group names are looked up upper cased, like the regions of a request
+func TestRegionGroupNames(t *testing.T) {
+	regions, err := NewRegions(REGIONS_GROUP, map[string][]string{" northEast ": {"PA"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := fmt.Sprint(regions.Of("PA")); got != "[NORTHEAST]" {
+		t.Errorf("the regions of PA are %s, want [NORTHEAST]", got)
+	}
+	for _, region := range []string{"NORTHEAST", "northeast", " NorthEast"} {
+		if !regions.Known(region) {
+			t.Errorf("%q is not known", region)
+		}
+	}
+	for _, groups := range []map[string][]string{{"EAST": {"PA"}, "east": {"NY"}}, {" ": {"PA"}}} {
+		if _, err := NewRegions(REGIONS_GROUP, groups); err == nil {
+			t.Errorf("the groups %v are accepted", groups)
+		}
+	}
+}
@@ -0,0 +1,234 @@
+package loader
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/linkedin/goavro/v2"
+	"github.com/parquet-go/parquet-go"
+	"io"
+	"urbn.com/recengine/model"
+)
+
+const (
+	//how many rows of a parquet row group are decoded at a time
+	PARQUET_BATCH_SIZE = 256
+	//how much of a column chunk is read at a time, large enough for a ranged get on s3 to be worth it
+	PARQUET_READ_BUFFER = 1024 * 1024
+)
+
+//the format of a part file read column by column or block by block rather than as text, from its magic
+//bytes. empty for text part files
+func ColumnarFormat(head []byte) string {
+	if bytes.HasPrefix(head, []byte(PARQUET_MAGIC)) {
+		return FORMAT_PARQUET
+	} else if bytes.HasPrefix(head, []byte(AVRO_MAGIC)) {
+		return FORMAT_AVRO
+	}
+	return ""
+}
+
+//the rows of a parquet part file, the columns are matched by name to the json fields of the model. scores
+//written as int32 or int64, and optional or required columns are all accepted
+type parquetProduct struct {
+	ProductID           string                  `parquet:"productId,optional"`
+	Color               string                  `parquet:"color,optional"`
+	SkuID               string                  `parquet:"skuId,optional"`
+	BoughtTogetherItems []parquetItem           `parquet:"boughtTogetherItems,optional,list"`
+	SortedRelates       []parquetRelatedProduct `parquet:"sortedRelates,optional,list"`
+}
+
+type parquetItem struct {
+	ProductID     string               `parquet:"productId,optional"`
+	Color         string               `parquet:"color,optional"`
+	SkuID         string               `parquet:"skuId,optional"`
+	TotalScore    int64                `parquet:"totalScore,optional"`
+	ScoreByRegion []parquetRegionScore `parquet:"scoreByRegion,optional,list"`
+}
+
+type parquetRegionScore struct {
+	Region string `parquet:"region,optional"`
+	Score  int64  `parquet:"score,optional"`
+}
+
+type parquetRelatedProduct struct {
+	ProductID string `parquet:"productId,optional"`
+	Score     int64  `parquet:"score,optional"`
+}
+
+//parse a parquet part file one row group at a time, so only the footer and the pages of the row group being
+//read are held in memory
+func ParseParquet(input io.ReaderAt, size int64, report *FileReport, add func(model.Product)) {
+	report.Format = FORMAT_PARQUET
+	file, err := parquet.OpenFile(input, size, parquet.SkipPageIndex(true), parquet.SkipBloomFilters(true),
+		parquet.ReadBufferSize(PARQUET_READ_BUFFER))
+	if err != nil {
+		report.reject(0, fmt.Errorf("%w: %s", ErrMalformed, err.Error()), "")
+		return
+	}
+	rowNumber := 0
+	rows := make([]parquetProduct, PARQUET_BATCH_SIZE)
+	for _, rowGroup := range file.RowGroups() {
+		reader := parquet.NewGenericRowGroupReader[parquetProduct](rowGroup)
+		for {
+			n, err := reader.Read(rows)
+			for i := 0; i < n; i++ {
+				rowNumber++
+				record := rows[i].toProduct()
+				report.accept(rowNumber, record, record.ProductID, add)
+				rows[i] = parquetProduct{}
+			}
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				report.reject(rowNumber+1, fmt.Errorf("%w: %s", ErrMalformed, err.Error()), "")
+				break
+			}
+		}
+		reader.Close()
+	}
+}
+
+//a v1 row has sortedRelates and no boughtTogetherItems, empty rather than null when the lists are required
+func (row parquetProduct) toProduct() model.Product {
+	if len(row.BoughtTogetherItems) == 0 && len(row.SortedRelates) > 0 {
+		relates := make([]model.RelatedProduct, len(row.SortedRelates))
+		for i, related := range row.SortedRelates {
+			relates[i] = model.RelatedProduct{ProductID: related.ProductID, Score: int(related.Score)}
+		}
+		return model.FromSortedRelates(row.ProductID, relates)
+	}
+	prod := model.Product{
+		ProductID:           row.ProductID,
+		Color:               row.Color,
+		SkuID:               row.SkuID,
+		BoughtTogetherItems: make([]model.BoughtTogetherItem, len(row.BoughtTogetherItems)),
+	}
+	for i, item := range row.BoughtTogetherItems {
+		regions := make([]model.RegionScore, len(item.ScoreByRegion))
+		for j, regionScore := range item.ScoreByRegion {
+			regions[j] = model.RegionScore{Region: regionScore.Region, Score: int(regionScore.Score)}
+		}
+		prod.BoughtTogetherItems[i] = model.BoughtTogetherItem{
+			ProductID:     item.ProductID,
+			Color:         item.Color,
+			SkuID:         item.SkuID,
+			TotalScore:    int(item.TotalScore),
+			ScoreByRegion: regions,
+		}
+	}
+	return prod
+}
+
+//parse an avro object container file one block at a time. the records are the model's json fields, in either
+//the v2 or the v1 sortedRelates schema
+func ParseAvro(input io.Reader, report *FileReport, add func(model.Product)) {
+	report.Format = FORMAT_AVRO
+	reader, err := goavro.NewOCFReader(input)
+	if err != nil {
+		report.reject(0, fmt.Errorf("%w: %s", ErrMalformed, err.Error()), "")
+		return
+	}
+	recordNumber := 0
+	for reader.Scan() {
+		recordNumber++
+		datum, err := reader.Read()
+		if err != nil {
+			//a block which can not be decoded leaves the reader in error, the rest of the file is lost
+			report.reject(recordNumber, fmt.Errorf("%w: %s", ErrMalformed, err.Error()), "")
+			return
+		}
+		prod, err := avroProduct(datum)
+		if err != nil {
+			report.reject(recordNumber, err, fmt.Sprint(datum))
+			continue
+		}
+		report.accept(recordNumber, prod, prod.ProductID, add)
+	}
+	if err := reader.Err(); err != nil {
+		report.reject(recordNumber+1, fmt.Errorf("%w: %s", ErrMalformed, err.Error()), "")
+	}
+}
+
+func avroProduct(datum interface{}) (model.Product, error) {
+	record, ok := datum.(map[string]interface{})
+	if !ok {
+		return model.Product{}, fmt.Errorf("%w: not a record", ErrMalformed)
+	}
+	productId := avroString(record["productId"])
+	items, ok := avroArray(record["boughtTogetherItems"])
+	if !ok {
+		if relates, ok := avroArray(record["sortedRelates"]); ok {
+			sortedRelates := make([]model.RelatedProduct, 0, len(relates))
+			for _, related := range relates {
+				fields, _ := avroUnion(related).(map[string]interface{})
+				sortedRelates = append(sortedRelates, model.RelatedProduct{
+					ProductID: avroString(fields["productId"]),
+					Score:     avroInt(fields["score"]),
+				})
+			}
+			return model.FromSortedRelates(productId, sortedRelates), nil
+		}
+	}
+	prod := model.Product{
+		ProductID:           productId,
+		Color:               avroString(record["color"]),
+		SkuID:               avroString(record["skuId"]),
+		BoughtTogetherItems: make([]model.BoughtTogetherItem, 0, len(items)),
+	}
+	for _, item := range items {
+		fields, ok := avroUnion(item).(map[string]interface{})
+		if !ok {
+			return model.Product{}, fmt.Errorf("%w: bought together item is not a record", ErrMalformed)
+		}
+		regions, _ := avroArray(fields["scoreByRegion"])
+		scoreByRegion := make([]model.RegionScore, 0, len(regions))
+		for _, region := range regions {
+			regionFields, _ := avroUnion(region).(map[string]interface{})
+			scoreByRegion = append(scoreByRegion, model.RegionScore{
+				Region: avroString(regionFields["region"]),
+				Score:  avroInt(regionFields["score"]),
+			})
+		}
+		prod.BoughtTogetherItems = append(prod.BoughtTogetherItems, model.BoughtTogetherItem{
+			ProductID:     avroString(fields["productId"]),
+			Color:         avroString(fields["color"]),
+			SkuID:         avroString(fields["skuId"]),
+			TotalScore:    avroInt(fields["totalScore"]),
+			ScoreByRegion: scoreByRegion,
+		})
+	}
+	return prod, nil
+}
+
+//the value of a nullable field. goavro decodes a union as a map from the branch name to the value, which is
+//told apart from the records of the model as those all have more than one field
+func avroUnion(value interface{}) interface{} {
+	if union, ok := value.(map[string]interface{}); ok && len(union) == 1 {
+		for _, v := range union {
+			return v
+		}
+	}
+	return value
+}
+
+func avroString(value interface{}) string {
+	s, _ := avroUnion(value).(string)
+	return s
+}
+
+func avroInt(value interface{}) int {
+	switch n := avroUnion(value).(type) {
+	case int32:
+		return int(n)
+	case int64:
+		return int(n)
+	case int:
+		return n
+	}
+	return 0
+}
+
+func avroArray(value interface{}) ([]interface{}, bool) {
+	array, ok := avroUnion(value).([]interface{})
+	return array, ok
+}
@@ -0,0 +1,252 @@
+package loader
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/linkedin/goavro/v2"
+	"github.com/parquet-go/parquet-go"
+	"io"
+	"net/http/httptest"
+	"testing"
+	"urbn.com/recengine/model"
+)
+
+//the rows of the parquet fixture: a v2 product with a variant, a v1 sortedRelates one, and one without a
+//productId, written in row groups of two rows
+var parquetFixture = []parquetFixtureProduct{
+	{ProductID: "A", BoughtTogetherItems: []parquetFixtureItem{
+		{ProductID: "B", TotalScore: 5, ScoreByRegion: []parquetRegionScore{{Region: "PA", Score: 3}, {Region: "NY", Score: 2}}},
+		{ProductID: "C", TotalScore: 1, ScoreByRegion: []parquetRegionScore{}},
+	}},
+	{ProductID: "A", Color: "blue", BoughtTogetherItems: []parquetFixtureItem{{ProductID: "B", Color: "red", TotalScore: 2}}},
+	{ProductID: "D", SortedRelates: []parquetRelatedProduct{{ProductID: "A", Score: 4}}},
+	{BoughtTogetherItems: []parquetFixtureItem{{ProductID: "B", TotalScore: 1}}},
+}
+
+//the schema of parquetProduct with required lists: the generic writer of parquet-go writes the elements of an
+//optional list as nulls. the reader takes both, TestParseParquetOptionalLists writes optional ones level by level
+type parquetFixtureProduct struct {
+	ProductID           string                  `parquet:"productId,optional"`
+	Color               string                  `parquet:"color,optional"`
+	SkuID               string                  `parquet:"skuId,optional"`
+	BoughtTogetherItems []parquetFixtureItem    `parquet:"boughtTogetherItems,list"`
+	SortedRelates       []parquetRelatedProduct `parquet:"sortedRelates,list"`
+}
+
+type parquetFixtureItem struct {
+	ProductID     string               `parquet:"productId,optional"`
+	Color         string               `parquet:"color,optional"`
+	SkuID         string               `parquet:"skuId,optional"`
+	TotalScore    int64                `parquet:"totalScore,optional"`
+	ScoreByRegion []parquetRegionScore `parquet:"scoreByRegion,list"`
+}
+
+func parquetFile(t *testing.T) []byte {
+	var buf bytes.Buffer
+	writer := parquet.NewGenericWriter[parquetFixtureProduct](&buf)
+	for i := 0; i < len(parquetFixture); i += 2 {
+		if _, err := writer.Write(parquetFixture[i : i+2]); err != nil {
+			t.Fatal(err)
+		}
+		if err := writer.Flush(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+//the products loaded out of the columnar fixtures, whatever reader they went through
+func checkColumnarFixture(t *testing.T, name string, products map[string]model.Product, report *FileReport) {
+	if report.Loaded != 3 || report.Rejected != 1 || report.Reasons[ErrEmptyProductId.Error()] != 1 {
+		t.Errorf("%s: loaded %d, rejected %d %v, want 3 and 1 empty productId", name, report.Loaded, report.Rejected, report.Reasons)
+	}
+	if prod := products["A"]; len(prod.BoughtTogetherItems) != 2 || prod.BoughtTogetherItems[0].ProductID != "B" ||
+		prod.BoughtTogetherItems[0].TotalScore != 5 || len(prod.BoughtTogetherItems[0].ScoreByRegion) != 2 ||
+		prod.BoughtTogetherItems[0].ScoreByRegion[1] != (model.RegionScore{Region: "NY", Score: 2}) {
+		t.Errorf("%s: A = %+v", name, prod)
+	}
+	if prod := products[model.VariantKey("A", "blue")]; prod.Color != "blue" || len(prod.BoughtTogetherItems) != 1 || prod.BoughtTogetherItems[0].Color != "red" {
+		t.Errorf("%s: A blue = %+v", name, prod)
+	}
+	if prod := products["D"]; len(prod.BoughtTogetherItems) != 1 || prod.BoughtTogetherItems[0].ProductID != "A" || prod.BoughtTogetherItems[0].TotalScore != 4 {
+		t.Errorf("%s: the v1 D = %+v", name, prod)
+	}
+}
+
+func collect(products map[string]model.Product) func(model.Product) {
+	return func(prod model.Product) {
+		products[prod.Key()] = prod
+	}
+}
+
+func TestParseParquet(t *testing.T) {
+	contents := parquetFile(t)
+	products := make(map[string]model.Product)
+	report := NewLoadReport().File("part-00000.parquet", testModified)
+	ParseProducts(contents, report, collect(products))
+	if report.Format != FORMAT_PARQUET {
+		t.Errorf("format %s, want %s", report.Format, FORMAT_PARQUET)
+	}
+	checkColumnarFixture(t, "parquet", products, report)
+
+	report = NewLoadReport().File("part-00000.parquet", testModified)
+	ParseParquet(bytes.NewReader(contents[:len(contents)-8]), int64(len(contents)-8), report, collect(products))
+	if report.Rejected != 1 || report.Loaded != 0 || report.Samples[0].Line != 0 {
+		t.Errorf("truncated parquet: loaded %d, rejected %d, want the whole file rejected", report.Loaded, report.Rejected)
+	}
+}
+
+//the schema of parquetProduct itself, optional lists of optional fields the way spark writes them, with the
+//row of A and its item B, 5, written column by column with the repetition and definition levels
+func TestParseParquetOptionalLists(t *testing.T) {
+	var buf bytes.Buffer
+	writer := parquet.NewWriter(&buf, parquet.SchemaOf(new(parquetProduct)))
+	null := func(definition int, column int) parquet.Value {
+		return parquet.Value{}.Level(0, definition, column)
+	}
+	row := parquet.Row{
+		parquet.ByteArrayValue([]byte("A")).Level(0, 1, 0), null(0, 1), null(0, 2),
+		parquet.ByteArrayValue([]byte("B")).Level(0, 3, 3), null(2, 4), null(2, 5), parquet.Int64Value(5).Level(0, 3, 6),
+		null(2, 7), null(2, 8),
+		null(0, 9), null(0, 10),
+	}
+	if _, err := writer.WriteRows([]parquet.Row{row}); err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	products := make(map[string]model.Product)
+	report := NewLoadReport().File("part-00000.parquet", testModified)
+	ParseParquet(bytes.NewReader(buf.Bytes()), int64(buf.Len()), report, collect(products))
+	if prod := products["A"]; report.Loaded != 1 || len(prod.BoughtTogetherItems) != 1 ||
+		prod.BoughtTogetherItems[0].ProductID != "B" || prod.BoughtTogetherItems[0].TotalScore != 5 {
+		t.Errorf("loaded %d, A = %+v", report.Loaded, prod)
+	}
+}
+
+const avroFixtureSchema = `{"type": "record", "name": "Product", "fields": [
+	{"name": "productId", "type": "string"},
+	{"name": "color", "type": ["null", "string"], "default": null},
+	{"name": "boughtTogetherItems", "type": ["null", {"type": "array", "items": {"type": "record", "name": "Item", "fields": [
+		{"name": "productId", "type": "string"},
+		{"name": "color", "type": ["null", "string"], "default": null},
+		{"name": "totalScore", "type": "long"},
+		{"name": "scoreByRegion", "type": {"type": "array", "items": {"type": "record", "name": "RegionScore", "fields": [
+			{"name": "region", "type": "string"},
+			{"name": "score", "type": "int"}]}}}]}}], "default": null},
+	{"name": "sortedRelates", "type": ["null", {"type": "array", "items": {"type": "record", "name": "Related", "fields": [
+		{"name": "productId", "type": "string"},
+		{"name": "score", "type": "int"}]}}], "default": null}]}`
+
+func avroItem(productId string, color interface{}, totalScore int64, regions ...map[string]interface{}) map[string]interface{} {
+	scoreByRegion := make([]interface{}, len(regions))
+	for i, region := range regions {
+		scoreByRegion[i] = region
+	}
+	return map[string]interface{}{"productId": productId, "color": color, "totalScore": totalScore, "scoreByRegion": scoreByRegion}
+}
+
+//the avro fixture, the same products as the parquet one in blocks of two records
+func avroFile(t *testing.T) []byte {
+	records := []interface{}{
+		map[string]interface{}{"productId": "A", "boughtTogetherItems": goavro.Union("array", []interface{}{
+			avroItem("B", nil, 5, map[string]interface{}{"region": "PA", "score": int32(3)}, map[string]interface{}{"region": "NY", "score": int32(2)}),
+			avroItem("C", nil, 1),
+		})},
+		map[string]interface{}{"productId": "A", "color": goavro.Union("string", "blue"), "boughtTogetherItems": goavro.Union("array", []interface{}{
+			avroItem("B", goavro.Union("string", "red"), 2),
+		})},
+		map[string]interface{}{"productId": "D", "sortedRelates": goavro.Union("array", []interface{}{
+			map[string]interface{}{"productId": "A", "score": int32(4)},
+		})},
+		map[string]interface{}{"productId": "", "boughtTogetherItems": goavro.Union("array", []interface{}{avroItem("B", nil, 1)})},
+	}
+	var buf bytes.Buffer
+	writer, err := goavro.NewOCFWriter(goavro.OCFConfig{W: &buf, Schema: avroFixtureSchema, CompressionName: goavro.CompressionDeflateLabel})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < len(records); i += 2 {
+		if err := writer.Append(records[i : i+2]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return buf.Bytes()
+}
+
+func TestParseAvro(t *testing.T) {
+	contents := avroFile(t)
+	products := make(map[string]model.Product)
+	report := NewLoadReport().File("part-00000.avro", testModified)
+	ParseProducts(contents, report, collect(products))
+	if report.Format != FORMAT_AVRO {
+		t.Errorf("format %s, want %s", report.Format, FORMAT_AVRO)
+	}
+	checkColumnarFixture(t, "avro", products, report)
+
+	//a truncated last block is rejected, the blocks before it are kept
+	report = NewLoadReport().File("part-00000.avro", testModified)
+	products = make(map[string]model.Product)
+	ParseAvro(bytes.NewReader(contents[:len(contents)-20]), report, collect(products))
+	if report.Loaded != 2 || report.Rejected != 1 {
+		t.Errorf("truncated avro: loaded %d, rejected %d, want 2 and 1", report.Loaded, report.Rejected)
+	}
+}
+
+func TestS3ReaderAt(t *testing.T) {
+	contents := []byte("0123456789")
+	fake := &fakeS3{bucket: "bucket", pageSize: 10, objects: map[string][]byte{"model/part-00000": contents}}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	object := &s3ReaderAt{svc: fakeS3Client(server), bucket: "bucket", key: "model/part-00000"}
+
+	p := make([]byte, 4)
+	if n, err := object.ReadAt(p, 3); n != 4 || err != nil || string(p) != "3456" {
+		t.Errorf("ReadAt(4, 3) = %d %q, %v", n, p[:n], err)
+	}
+	if n, err := object.ReadAt(p, 8); n != 2 || err != io.EOF || string(p[:n]) != "89" {
+		t.Errorf("ReadAt(4, 8) = %d %q, %v, want the last 2 bytes and io.EOF", n, p[:n], err)
+	}
+	if n, err := object.ReadAt(p[:0], 20); n != 0 || err != nil {
+		t.Errorf("ReadAt(0, 20) = %d, %v", n, err)
+	}
+	if want := "[bytes=3-6 bytes=8-11]"; fmt.Sprint(fake.ranges) != want {
+		t.Errorf("ranges %v, want %s", fake.ranges, want)
+	}
+}
+
+func TestLoadParquetFromS3(t *testing.T) {
+	fake := &fakeS3{bucket: "bucket", pageSize: 10, objects: map[string][]byte{
+		"model/part-00000.parquet": parquetFile(t),
+		"model/part-00001.avro":    avroFile(t),
+	}}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	products := make(map[string]model.Product)
+	report, err := LoadProductsFromS3(fakeS3Client(server), "s3://bucket/model", collect(products))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Files) != 2 {
+		t.Fatalf("loaded %d files, want 2", len(report.Files))
+	}
+	checkColumnarFixture(t, "s3 parquet", products, report.Files[0])
+	checkColumnarFixture(t, "s3 avro", products, report.Files[1])
+	if !report.Modified.Equal(testModified) {
+		t.Errorf("modified %s, want %s", report.Modified, testModified)
+	}
+	//the parquet file is read with ranged gets, the avro one streamed whole
+	ranged := 0
+	for _, r := range fake.ranges {
+		if r != "" {
+			ranged++
+		}
+	}
+	if ranged < 2 || fake.ranges[len(fake.ranges)-1] != "" {
+		t.Errorf("ranges %v, want ranged gets of the parquet file then a whole get", fake.ranges)
+	}
+}
@@ -0,0 +1,138 @@
+package loader
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+	"io"
+	"io/ioutil"
+	"path"
+	"strings"
+)
+
+//the compressions of the part files the loader decompresses
+const (
+	COMPRESSION_GZIP = "gzip"
+	//the block framing of the hadoop SnappyCodec spark writes .snappy text files with, or the snappy framing format
+	COMPRESSION_SNAPPY = "snappy"
+	COMPRESSION_ZSTD   = "zstd"
+
+	GZIP_MAGIC          = "\x1f\x8b"
+	ZSTD_MAGIC          = "\x28\xb5\x2f\xfd"
+	SNAPPY_FRAMED_MAGIC = "\xff\x06\x00\x00sNaPpY"
+	//how many bytes of a file are needed to tell its compression or columnar format
+	MAGIC_SIZE = len(SNAPPY_FRAMED_MAGIC)
+
+	//the largest compressed chunk of a hadoop snappy block accepted, beyond it the file is taken to be corrupted
+	HADOOP_SNAPPY_MAX_CHUNK = 64 * 1024 * 1024
+)
+
+var ErrCorruptedSnappy = errors.New("corrupted hadoop snappy block")
+
+//detect the compression of a part file from its magic bytes, then from the extension of its name.
+//empty when the file is not compressed. the .snappy of a name.snappy.parquet is the parquet page codec,
+//only the last extension counts
+func DetectCompression(name string, head []byte) string {
+	if bytes.HasPrefix(head, []byte(GZIP_MAGIC)) {
+		return COMPRESSION_GZIP
+	} else if bytes.HasPrefix(head, []byte(ZSTD_MAGIC)) {
+		return COMPRESSION_ZSTD
+	} else if bytes.HasPrefix(head, []byte(SNAPPY_FRAMED_MAGIC)) {
+		return COMPRESSION_SNAPPY
+	}
+	switch strings.ToLower(path.Ext(name)) {
+	case ".gz", ".gzip":
+		return COMPRESSION_GZIP
+	case ".zst", ".zstd":
+		return COMPRESSION_ZSTD
+	case ".snappy":
+		return COMPRESSION_SNAPPY
+	}
+	return ""
+}
+
+//a reader of the decompressed content of r, head being its first bytes
+func NewDecompressor(compression string, head []byte, r io.Reader) (io.ReadCloser, error) {
+	switch compression {
+	case COMPRESSION_GZIP:
+		return gzip.NewReader(r)
+	case COMPRESSION_ZSTD:
+		decoder, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return decoder.IOReadCloser(), nil
+	case COMPRESSION_SNAPPY:
+		if bytes.HasPrefix(head, []byte(SNAPPY_FRAMED_MAGIC)) {
+			return ioutil.NopCloser(snappy.NewReader(r)), nil
+		}
+		return ioutil.NopCloser(&hadoopSnappyReader{r: r}), nil
+	}
+	return ioutil.NopCloser(r), nil
+}
+
+//reads the hadoop snappy block stream: every block is the big endian uint32 length of its uncompressed data,
+//followed by as many uint32 length prefixed raw snappy chunks as it takes to decompress to that length. a block
+//of length 0 ends the stream the way it does for the hadoop BlockDecompressorStream: it is what hadoop writes
+//for an empty file, followed by the chunk of the empty input, which would otherwise be read as the header of
+//the next block
+type hadoopSnappyReader struct {
+	r          io.Reader
+	remaining  uint32
+	eof        bool
+	compressed []byte
+	decoded    []byte
+	pending    []byte
+}
+
+func (reader *hadoopSnappyReader) Read(p []byte) (int, error) {
+	var header [4]byte
+	for len(reader.pending) == 0 {
+		if reader.eof {
+			return 0, io.EOF
+		}
+		if _, err := io.ReadFull(reader.r, header[:]); err != nil {
+			if err == io.EOF && reader.remaining == 0 {
+				return 0, io.EOF
+			} else if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return 0, err
+		}
+		length := binary.BigEndian.Uint32(header[:])
+		if reader.remaining == 0 {
+			//the header of a new block
+			reader.remaining = length
+			reader.eof = length == 0
+			continue
+		}
+		if length > HADOOP_SNAPPY_MAX_CHUNK {
+			return 0, ErrCorruptedSnappy
+		}
+		if cap(reader.compressed) < int(length) {
+			reader.compressed = make([]byte, length)
+		}
+		reader.compressed = reader.compressed[:length]
+		if _, err := io.ReadFull(reader.r, reader.compressed); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return 0, err
+		}
+		decoded, err := snappy.Decode(reader.decoded[:cap(reader.decoded)], reader.compressed)
+		if err != nil {
+			return 0, err
+		} else if uint32(len(decoded)) > reader.remaining {
+			return 0, ErrCorruptedSnappy
+		}
+		reader.decoded = decoded
+		reader.remaining -= uint32(len(decoded))
+		reader.pending = decoded
+	}
+	n := copy(p, reader.pending)
+	reader.pending = reader.pending[n:]
+	return n, nil
+}
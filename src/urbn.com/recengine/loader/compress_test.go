@@ -0,0 +1,108 @@
+package loader
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/golang/snappy"
+	"io"
+	"io/ioutil"
+	"testing"
+)
+
+//a hadoop snappy block of the uncompressed length, then of every chunk compressed on its own
+func hadoopSnappyBlock(length int, chunks ...string) []byte {
+	block := binary.BigEndian.AppendUint32(nil, uint32(length))
+	for _, chunk := range chunks {
+		compressed := snappy.Encode(nil, []byte(chunk))
+		block = binary.BigEndian.AppendUint32(block, uint32(len(compressed)))
+		block = append(block, compressed...)
+	}
+	return block
+}
+
+func readHadoopSnappy(stream []byte) (string, error) {
+	decompressor, err := NewDecompressor(COMPRESSION_SNAPPY, stream, bytes.NewReader(stream))
+	if err != nil {
+		return "", err
+	}
+	contents, err := ioutil.ReadAll(decompressor)
+	return string(contents), err
+}
+
+func TestHadoopSnappy(t *testing.T) {
+	for name, test := range map[string]struct {
+		stream []byte
+		want   string
+	}{
+		"one block":    {hadoopSnappyBlock(6, "A,B,1\n"), "A,B,1\n"},
+		"multi chunks": {hadoopSnappyBlock(12, "A,B,1\n", "A,C", ",2\n"), "A,B,1\nA,C,2\n"},
+		"blocks":       {append(hadoopSnappyBlock(6, "A,B,1\n"), hadoopSnappyBlock(6, "A,C,2\n")...), "A,B,1\nA,C,2\n"},
+		"empty chunk":  {hadoopSnappyBlock(6, "A,B", "", ",1\n"), "A,B,1\n"},
+		"nothing":      {nil, ""},
+		//what hadoop writes for an empty file: the empty block, then the chunk of the empty input
+		"empty file": {hadoopSnappyBlock(0, ""), ""},
+		//the empty block ends the stream, what follows it is not read
+		"empty block": {append(hadoopSnappyBlock(6, "A,B,1\n"), append(hadoopSnappyBlock(0, ""), hadoopSnappyBlock(6, "A,C,2\n")...)...), "A,B,1\n"},
+	} {
+		got, err := readHadoopSnappy(test.stream)
+		if err != nil {
+			t.Errorf("%s: %s", name, err.Error())
+		} else if got != test.want {
+			t.Errorf("%s: read %q, want %q", name, got, test.want)
+		}
+	}
+}
+
+func TestHadoopSnappyCorrupted(t *testing.T) {
+	block := hadoopSnappyBlock(12, "A,B,1\n", "A,C,2\n")
+	oversize := binary.BigEndian.AppendUint32(binary.BigEndian.AppendUint32(nil, 6), HADOOP_SNAPPY_MAX_CHUNK+1)
+	for name, test := range map[string]struct {
+		stream []byte
+		want   error
+	}{
+		"truncated header": {block[:2], io.ErrUnexpectedEOF},
+		"truncated chunk":  {block[:len(block)-2], io.ErrUnexpectedEOF},
+		"missing chunk":    {hadoopSnappyBlock(12, "A,B,1\n"), io.ErrUnexpectedEOF},
+		"longer chunks":    {hadoopSnappyBlock(6, "A,B,1\n", "A,C,2\n"), ErrCorruptedSnappy},
+		"oversize chunk":   {oversize, ErrCorruptedSnappy},
+		"not snappy":       {append(binary.BigEndian.AppendUint32(nil, 6), 0, 0, 0, 2, 0xff, 0xff), snappy.ErrCorrupt},
+	} {
+		if _, err := readHadoopSnappy(test.stream); err != test.want {
+			t.Errorf("%s: %v, want %v", name, err, test.want)
+		}
+	}
+}
+
+func TestFramedSnappy(t *testing.T) {
+	var stream bytes.Buffer
+	writer := snappy.NewBufferedWriter(&stream)
+	writer.Write([]byte("A,B,1\n"))
+	writer.Close()
+	if got := DetectCompression("part-00000", stream.Bytes()); got != COMPRESSION_SNAPPY {
+		t.Fatalf("detected %q, want snappy", got)
+	}
+	got, err := readHadoopSnappy(stream.Bytes())
+	if err != nil || got != "A,B,1\n" {
+		t.Errorf("read %q, %v", got, err)
+	}
+}
+
+func TestDetectCompression(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		head string
+		want string
+	}{
+		{"part-00000", GZIP_MAGIC, COMPRESSION_GZIP},
+		{"part-00000", ZSTD_MAGIC, COMPRESSION_ZSTD},
+		{"part-00000.gz", "", COMPRESSION_GZIP},
+		{"part-00000.ZST", "", COMPRESSION_ZSTD},
+		{"part-00000.snappy", "\x00\x00\x00\x06", COMPRESSION_SNAPPY},
+		{"part-00000.snappy.parquet", "PAR1", ""},
+		{"part-00000", "A,B,1", ""},
+	} {
+		if got := DetectCompression(test.name, []byte(test.head)); got != test.want {
+			t.Errorf("DetectCompression(%s, %q) = %q, want %q", test.name, test.head, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,248 @@
+package loader
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"urbn.com/recengine/model"
+)
+
+//the part file formats the loader reads
+const (
+	//(p1,{json}), the toString of the spark tuples the co-purchase job saves as text
+	FORMAT_SPARK_TUPLE = "spark"
+	//one product json per line
+	FORMAT_JSONL = "jsonl"
+	//one row per product, bought together item and region: productId,itemId,region,score
+	FORMAT_CSV     = "csv"
+	FORMAT_TSV     = "tsv"
+	FORMAT_PARQUET = "parquet"
+	//an avro object container file
+	FORMAT_AVRO    = "avro"
+	FORMAT_UNKNOWN = "unknown"
+
+	PARQUET_MAGIC = "PAR1"
+	AVRO_MAGIC    = "Obj\x01"
+	//how many bytes of a file are sniffed for its format
+	SNIFF_SIZE = 64 * 1024
+)
+
+//detect the format of a part file from its magic bytes or its first non empty line
+func DetectFormat(contents []byte) string {
+	if format := ColumnarFormat(contents); format != "" {
+		return format
+	}
+	if len(contents) > SNIFF_SIZE {
+		contents = contents[:SNIFF_SIZE]
+	}
+	for _, line := range strings.Split(string(contents), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "(") && strings.Contains(line, "{") {
+			return FORMAT_SPARK_TUPLE
+		} else if strings.HasPrefix(line, "{") {
+			return FORMAT_JSONL
+		} else if strings.Contains(line, "\t") {
+			return FORMAT_TSV
+		} else if strings.Contains(line, ",") {
+			return FORMAT_CSV
+		}
+		return FORMAT_UNKNOWN
+	}
+	return FORMAT_UNKNOWN
+}
+
+//parse a part file of the detected format, handing every valid product to add and accounting for
+//every record in report
+func ParseProducts(contents []byte, report *FileReport, add func(model.Product)) {
+	report.Format = DetectFormat(contents)
+	switch report.Format {
+	case FORMAT_SPARK_TUPLE, FORMAT_JSONL:
+		parseJsonLines(contents, report, add)
+	case FORMAT_CSV:
+		parseDelimited(contents, ',', report, add)
+	case FORMAT_TSV:
+		parseDelimited(contents, '\t', report, add)
+	case FORMAT_PARQUET:
+		ParseParquet(bytes.NewReader(contents), int64(len(contents)), report, add)
+	case FORMAT_AVRO:
+		ParseAvro(bytes.NewReader(contents), report, add)
+	default:
+		report.reject(0, fmt.Errorf("%w: %s part file", ErrUnsupportedFormat, report.Format), "")
+	}
+}
+
+//parse spark tuple or json lines, the json of a spark tuple starts from the first { and ends at the last )
+func parseJsonLines(contents []byte, report *FileReport, add func(model.Product)) {
+	scanner := bufio.NewScanner(bytes.NewReader(contents))
+	scanner.Buffer(make([]byte, 0, 64*1024), len(contents)+1)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		jsonStr := line
+		if report.Format == FORMAT_SPARK_TUPLE {
+			start := strings.Index(line, "{")
+			end := strings.LastIndex(line, ")")
+			if start < 0 || end < start {
+				report.reject(lineNumber, fmt.Errorf("%w: not a spark tuple", ErrMalformed), line)
+				continue
+			}
+			jsonStr = line[start:end]
+		}
+		prod, err := DecodeProduct([]byte(jsonStr))
+		if err != nil {
+			report.reject(lineNumber, fmt.Errorf("%w: %s", ErrMalformed, err.Error()), line)
+		} else {
+			report.accept(lineNumber, prod, line, add)
+		}
+	}
+}
+
+//the columns of a csv or tsv part file. the header is optional, without it the columns are
+//productId,itemId,region,score or productId,itemId,score
+var delimitedColumns = []string{"productId", "itemId", "region", "score"}
+
+//a product being assembled from its rows, and the line it started on
+type delimitedProduct struct {
+	line  int
+	prod  model.Product
+	items map[string]int
+}
+
+//parse csv or tsv rows, each adding the score of one item in one region. the rows of a product need not be
+//adjacent, the products are handed to add once the whole file is read with their items sorted by score
+func parseDelimited(contents []byte, delimiter rune, report *FileReport, add func(model.Product)) {
+	reader := csv.NewReader(bytes.NewReader(contents))
+	reader.Comma = delimiter
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	if delimiter == '\t' {
+		reader.LazyQuotes = true
+	}
+
+	var columns map[string]int
+	var order []string
+	products := make(map[string]*delimitedProduct)
+	for {
+		row, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			if parseErr, ok := err.(*csv.ParseError); ok {
+				report.reject(parseErr.Line, fmt.Errorf("%w: %s", ErrMalformed, parseErr.Err.Error()), "")
+				continue
+			}
+			report.reject(0, fmt.Errorf("%w: %s", ErrMalformed, err.Error()), "")
+			break
+		}
+		line, _ := reader.FieldPos(0)
+		if columns == nil {
+			columns = delimitedHeader(row)
+			if columns != nil {
+				continue
+			}
+			columns = positionalColumns(len(row))
+		}
+		productId, itemId, region, score, err := delimitedRow(row, columns)
+		if err != nil {
+			report.reject(line, err, strings.Join(row, string(delimiter)))
+			continue
+		}
+		entry, ok := products[productId]
+		if !ok {
+			entry = &delimitedProduct{line: line, prod: model.EmptyProduct(productId), items: make(map[string]int)}
+			products[productId] = entry
+			order = append(order, productId)
+		}
+		i, ok := entry.items[itemId]
+		if !ok {
+			i = len(entry.prod.BoughtTogetherItems)
+			entry.items[itemId] = i
+			entry.prod.BoughtTogetherItems = append(entry.prod.BoughtTogetherItems,
+				model.BoughtTogetherItem{ProductID: itemId, ScoreByRegion: []model.RegionScore{}})
+		}
+		item := &entry.prod.BoughtTogetherItems[i]
+		item.TotalScore += score
+		if region != "" {
+			item.ScoreByRegion = append(item.ScoreByRegion, model.RegionScore{Region: region, Score: score})
+		}
+	}
+
+	for _, productId := range order {
+		entry := products[productId]
+		items := entry.prod.BoughtTogetherItems
+		sort.SliceStable(items, func(i, j int) bool { return items[i].TotalScore > items[j].TotalScore })
+		for _, item := range items {
+			regions := item.ScoreByRegion
+			sort.SliceStable(regions, func(i, j int) bool { return regions[i].Score > regions[j].Score })
+		}
+		report.accept(entry.line, entry.prod, productId, add)
+	}
+}
+
+//the column index by name when row is a header naming at least the productId and itemId columns, nil otherwise
+func delimitedHeader(row []string) map[string]int {
+	columns := make(map[string]int)
+	for i, name := range row {
+		for _, column := range delimitedColumns {
+			if strings.EqualFold(strings.TrimSpace(name), column) {
+				columns[column] = i
+			}
+		}
+	}
+	if _, ok := columns["productId"]; !ok {
+		return nil
+	}
+	if _, ok := columns["itemId"]; !ok {
+		return nil
+	}
+	return columns
+}
+
+func positionalColumns(width int) map[string]int {
+	if width == 3 {
+		return map[string]int{"productId": 0, "itemId": 1, "score": 2}
+	}
+	return map[string]int{"productId": 0, "itemId": 1, "region": 2, "score": 3}
+}
+
+//the fields of one row, the score defaults to 1 when the file has no score column
+func delimitedRow(row []string, columns map[string]int) (string, string, string, int, error) {
+	field := func(name string) (string, bool) {
+		i, ok := columns[name]
+		if !ok || i >= len(row) {
+			return "", ok
+		}
+		return strings.TrimSpace(row[i]), true
+	}
+	productId, _ := field("productId")
+	itemId, _ := field("itemId")
+	region, _ := field("region")
+	score := 1
+	if value, ok := field("score"); ok {
+		var err error
+		if score, err = strconv.Atoi(value); err != nil {
+			return "", "", "", 0, fmt.Errorf("%w: score %q is not an integer", ErrMalformed, value)
+		}
+	}
+	if productId == "" {
+		return "", "", "", 0, ErrEmptyProductId
+	} else if itemId == "" {
+		return "", "", "", 0, ErrEmptyItemId
+	} else if score < 0 {
+		return "", "", "", 0, ErrNegativeScore
+	}
+	return productId, itemId, region, score, nil
+}
@@ -0,0 +1,127 @@
+//Package loader reads the products out of the part files written by the co-purchase job,
+//from a local directory or an s3 prefix. The format of every part file is detected, spark tuple text,
+//json lines, csv/tsv rows, parquet or avro, gzip, snappy and zstd compressed files are decompressed as
+//they are read, and every record is validated. Both the v2 and the older v1 sortedRelates records are accepted.
+package loader
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"hash"
+	"hash/fnv"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"urbn.com/recengine/model"
+)
+
+//load every product found at dataLocation, either a local directory or an s3://bucket/prefix location, handing each
+//valid one to add. returns the report of the load, with the dataset version, a hash of the content of the part files,
+//or the error of a location or part file which can not be read
+func LoadProducts(dataLocation string, add func(model.Product)) (*LoadReport, error) {
+	if strings.HasPrefix(dataLocation, "s3://") {
+		svc := s3.New(session.New(), &aws.Config{Region: aws.String("us-east-1")})
+		return LoadProductsFromS3(svc, dataLocation, add)
+	}
+	return LoadProductsFromDir(dataLocation, add)
+}
+
+func datasetVersion(h hash.Hash64) string {
+	return fmt.Sprintf("%016x", h.Sum64())
+}
+
+//load the part files of the data directory. the data file name following the pattern of "part([\d]+)"
+func LoadProductsFromDir(dataDir string, add func(model.Product)) (*LoadReport, error) {
+	report := NewLoadReport()
+	version := fnv.New64a()
+	fileInfos, error := ioutil.ReadDir(dataDir)
+	if error != nil {
+		return nil, fmt.Errorf("failed to read the data directory %s %s", dataDir, error.Error())
+	}
+	for _, fileInfo := range fileInfos {
+		if strings.Contains(fileInfo.Name(), ".crc") {
+			continue
+		} else if strings.Contains(fileInfo.Name(), "part-") {
+			if err := loadPartFile(dataDir+"/"+fileInfo.Name(), fileInfo, version, report.File(fileInfo.Name(), fileInfo.ModTime()), add); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return report.finish(datasetVersion(version)), nil
+}
+
+//load one part file. parquet files are read in place and versioned by name, size and modification time,
+//any other file is streamed through parseStream
+func loadPartFile(fileName string, fileInfo os.FileInfo, version hash.Hash64, report *FileReport, add func(model.Product)) error {
+	f, error := os.Open(fileName)
+	if error != nil {
+		return fmt.Errorf("failed to load data file %s %s", fileName, error.Error())
+	}
+	defer f.Close()
+	head := make([]byte, MAGIC_SIZE)
+	n, _ := io.ReadFull(f, head)
+	if ColumnarFormat(head[:n]) == FORMAT_PARQUET {
+		fmt.Fprintf(version, "%s %d %d\n", fileInfo.Name(), fileInfo.Size(), fileInfo.ModTime().UnixNano())
+		ParseParquet(f, fileInfo.Size(), report, add)
+		return nil
+	}
+	f.Seek(0, io.SeekStart)
+	parseStream(fileInfo.Name(), f, version, report, add)
+	return nil
+}
+
+//parse a part file read from r, decompressing it first when its magic bytes or the extension of its name say
+//it is compressed. avro files are decoded as they are streamed, any other format is read whole. the raw
+//content is hashed into version
+func parseStream(name string, r io.Reader, version hash.Hash64, report *FileReport, add func(model.Product)) {
+	raw := bufio.NewReader(io.TeeReader(r, version))
+	defer io.Copy(ioutil.Discard, raw)
+	head, _ := raw.Peek(MAGIC_SIZE)
+	in := raw
+	if compression := DetectCompression(name, head); compression != "" {
+		report.Compression = compression
+		decompressor, err := NewDecompressor(compression, head, raw)
+		if err != nil {
+			report.Format = FORMAT_UNKNOWN
+			report.reject(0, fmt.Errorf("%w: %s %s", ErrMalformed, compression, err.Error()), "")
+			return
+		}
+		defer decompressor.Close()
+		in = bufio.NewReader(decompressor)
+		head, _ = in.Peek(MAGIC_SIZE)
+	}
+	if ColumnarFormat(head) == FORMAT_AVRO {
+		ParseAvro(in, report, add)
+		return
+	}
+	contents, err := ioutil.ReadAll(in)
+	if err != nil {
+		report.Format = FORMAT_UNKNOWN
+		report.reject(0, fmt.Errorf("%w: %s", ErrMalformed, err.Error()), "")
+		return
+	}
+	ParseProducts(contents, report, add)
+}
+
+//a product in either the v2 boughtTogetherItems or the v1 sortedRelates schema
+type productRecord struct {
+	model.Product
+	SortedRelates []model.RelatedProduct `json:"sortedRelates"`
+}
+
+//decode a product json in either schema, v1 products are converted up to v2
+func DecodeProduct(data []byte) (model.Product, error) {
+	var record productRecord
+	if err := json.Unmarshal(data, &record); err != nil {
+		return model.Product{}, err
+	}
+	if record.BoughtTogetherItems == nil && record.SortedRelates != nil {
+		return model.FromSortedRelates(record.ProductID, record.SortedRelates), nil
+	}
+	return record.Product, nil
+}
@@ -0,0 +1,136 @@
+package loader
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"hash"
+	"hash/fnv"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"urbn.com/recengine/model"
+)
+
+//load the popularity lists of the part files found at popularLocation, a local directory or an
+//s3://bucket/prefix location, one list json per line as the order tool writes them with -popular. the files
+//may be compressed. a list found more than once keeps its last record
+func LoadPopularLists(popularLocation string) (map[string]model.PopularList, *LoadReport, error) {
+	if strings.HasPrefix(popularLocation, "s3://") {
+		svc := s3.New(session.New(), &aws.Config{Region: aws.String("us-east-1")})
+		return LoadPopularListsFromS3(svc, popularLocation)
+	}
+	report := NewLoadReport()
+	version := fnv.New64a()
+	lists := make(map[string]model.PopularList)
+	add := func(list model.PopularList) {
+		lists[list.List] = list
+	}
+	fileInfos, err := ioutil.ReadDir(popularLocation)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read the popularity lists directory %s %s", popularLocation, err.Error())
+	}
+	for _, fileInfo := range fileInfos {
+		if strings.Contains(fileInfo.Name(), ".crc") || !strings.Contains(fileInfo.Name(), "part-") {
+			continue
+		}
+		f, err := os.Open(popularLocation + "/" + fileInfo.Name())
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to load popularity lists file %s %s", fileInfo.Name(), err.Error())
+		}
+		parsePopularLists(fileInfo.Name(), f, version, report.File(fileInfo.Name(), fileInfo.ModTime()), add)
+		f.Close()
+	}
+	return lists, report.finish(datasetVersion(version)), nil
+}
+
+//load the popularity lists of the part files under an s3://bucket/prefix location, every page of its listing
+func LoadPopularListsFromS3(svc *s3.S3, popularLocation string) (map[string]model.PopularList, *LoadReport, error) {
+	report := NewLoadReport()
+	version := fnv.New64a()
+	lists := make(map[string]model.PopularList)
+	bucket, _ := ParseS3Params(popularLocation)
+	objects, err := ListPartObjects(svc, popularLocation)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to list the popularity lists %s %s", popularLocation, err.Error())
+	}
+	for _, obj := range objects {
+		body, err := OpenObject(svc, bucket, *obj.Key)
+		if err != nil {
+			return nil, nil, err
+		}
+		parsePopularLists(*obj.Key, body, version, report.File(*obj.Key, aws.TimeValue(obj.LastModified)), func(list model.PopularList) {
+			lists[list.List] = list
+		})
+		body.Close()
+	}
+	return lists, report.finish(datasetVersion(version)), nil
+}
+
+//parse the list json lines of the part file name read from r, decompressing it first when it is compressed.
+//the raw content is hashed into version
+func parsePopularLists(name string, r io.Reader, version hash.Hash64, report *FileReport, add func(model.PopularList)) {
+	raw := bufio.NewReader(io.TeeReader(r, version))
+	defer io.Copy(ioutil.Discard, raw)
+	report.Format = FORMAT_JSONL
+	head, _ := raw.Peek(MAGIC_SIZE)
+	in := io.Reader(raw)
+	if compression := DetectCompression(name, head); compression != "" {
+		report.Compression = compression
+		decompressor, err := NewDecompressor(compression, head, raw)
+		if err != nil {
+			report.reject(0, fmt.Errorf("%w: %s %s", ErrMalformed, compression, err.Error()), "")
+			return
+		}
+		defer decompressor.Close()
+		in = decompressor
+	}
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 0, 64*1024), 64*1024*1024)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		var list model.PopularList
+		if err := json.Unmarshal([]byte(line), &list); err != nil {
+			report.reject(lineNumber, fmt.Errorf("%w: %s", ErrMalformed, err.Error()), line)
+		} else if err := ValidatePopularList(list); err != nil {
+			report.reject(lineNumber, err, line)
+		} else {
+			report.Records++
+			report.Loaded++
+			add(list)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		report.reject(lineNumber+1, fmt.Errorf("%w: %s", ErrMalformed, err.Error()), "")
+	}
+}
+
+//check a popularity list against the schema: a known list, every item with a productId and no negative total
+//or region score
+func ValidatePopularList(list model.PopularList) error {
+	if list.List != model.LIST_TOP_SELLERS && list.List != model.LIST_TRENDING {
+		return fmt.Errorf("%w: %q", ErrUnknownList, list.List)
+	}
+	for _, item := range list.Items {
+		if item.ProductID == "" {
+			return ErrEmptyItemId
+		} else if item.TotalScore < 0 {
+			return ErrNegativeScore
+		}
+		for _, regionScore := range item.ScoreByRegion {
+			if regionScore.Score < 0 {
+				return ErrNegativeScore
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,164 @@
+package loader
+
+import (
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/golang/glog"
+	"hash"
+	"hash/fnv"
+	"io"
+	"io/ioutil"
+	"strings"
+	"urbn.com/recengine/model"
+)
+
+//load the part files under an s3://bucket/prefix location
+func LoadProductsFromS3(svc *s3.S3, dataDir string, add func(model.Product)) (*LoadReport, error) {
+	report := NewLoadReport()
+	version := fnv.New64a()
+	bucket, _ := ParseS3Params(dataDir)
+	objects, err := ListPartObjects(svc, dataDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the part files of %s %s", dataDir, err.Error())
+	}
+	for _, obj := range objects {
+		glog.V(2).Infof("populating with file %s", *obj.Key)
+		if err := loadS3PartFile(svc, bucket, obj, version, report.File(*obj.Key, aws.TimeValue(obj.LastModified)), add); err != nil {
+			return nil, err
+		}
+	}
+	return report.finish(datasetVersion(version)), nil
+}
+
+//list the part files under an s3://bucket/prefix location, or at the root of the bucket of s3://bucket, every
+//page of the listing. the error of s3 carries its code when it has one
+func ListPartObjects(svc *s3.S3, location string) ([]*s3.Object, error) {
+	bucket, keypattern := ParseS3Params(location)
+	prefix := strings.Trim(keypattern, "/")
+	if prefix != "" {
+		prefix += "/"
+	}
+	prefix += "part-"
+	var objects []*s3.Object
+	err := svc.ListObjectsPages(&s3.ListObjectsInput{Bucket: aws.String(bucket), Prefix: aws.String(prefix)},
+		func(page *s3.ListObjectsOutput, lastPage bool) bool {
+			for _, obj := range page.Contents {
+				if !strings.Contains(aws.StringValue(obj.Key), ".crc") {
+					objects = append(objects, obj)
+				}
+			}
+			return true
+		})
+	if aerr, ok := err.(awserr.Error); ok {
+		return nil, fmt.Errorf("%s: %s", aerr.Code(), aerr.Message())
+	} else if err != nil {
+		return nil, err
+	}
+	return objects, nil
+}
+
+//load one part object. parquet objects are read with ranged gets and versioned by key, size and etag,
+//any other object is streamed through parseStream
+func loadS3PartFile(svc *s3.S3, bucket string, obj *s3.Object, version hash.Hash64, report *FileReport, add func(model.Product)) error {
+	object := &s3ReaderAt{svc: svc, bucket: bucket, key: *obj.Key}
+	size := aws.Int64Value(obj.Size)
+	if size >= int64(len(PARQUET_MAGIC)) {
+		head := make([]byte, len(PARQUET_MAGIC))
+		if _, err := object.ReadAt(head, 0); err != nil {
+			return fmt.Errorf("failed to read s3 object %s %s", *obj.Key, err.Error())
+		}
+		if ColumnarFormat(head) == FORMAT_PARQUET {
+			fmt.Fprintf(version, "%s %d %s\n", *obj.Key, size, aws.StringValue(obj.ETag))
+			ParseParquet(object, size, report, add)
+			return nil
+		}
+	}
+	body, err := OpenObject(svc, bucket, *obj.Key)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	parseStream(*obj.Key, body, version, report, add)
+	return nil
+}
+
+//an s3 object read with ranged gets, so a parquet file is fetched a column chunk at a time
+type s3ReaderAt struct {
+	svc    *s3.S3
+	bucket string
+	key    string
+}
+
+func (object *s3ReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	resp, err := object.svc.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(object.bucket),
+		Key:    aws.String(object.key),
+		Range:  aws.String(fmt.Sprintf("bytes=%d-%d", off, off+int64(len(p))-1)),
+	})
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	n, err := io.ReadFull(resp.Body, p)
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	return n, err
+}
+
+//open an object from s3 to be streamed, the caller closes it
+func OpenObject(svc *s3.S3, bucket string, key string) (io.ReadCloser, error) {
+	resp, err := svc.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get s3 object %s %s", key, err.Error())
+	}
+	return resp.Body, nil
+}
+
+//get object from s3
+func GetObject(svc *s3.S3, bucket string, key string) ([]byte, error) {
+	params := &s3.GetObjectInput{
+		Bucket: aws.String(bucket), // Required
+		Key:    aws.String(key),    // Required
+	}
+	resp, err := svc.GetObject(params)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to get s3 object %s %s", key, err.Error())
+	}
+
+	defer resp.Body.Close()
+	contents, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read s3 object %s %s", key, err.Error())
+	}
+	return contents, nil
+}
+
+//put body to s3 as the object key
+func PutObject(svc *s3.S3, bucket string, key string, body io.ReadSeeker) error {
+	_, err := svc.PutObject(&s3.PutObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+		Body:   body,
+	})
+	return err
+}
+
+//parse s3://ecomm-order-items/recommendations/output.txt to return {ecomm-order-items,recommendations/output.txt}
+func ParseS3Params(in string) (string, string) {
+	if strings.HasPrefix(in, "s3://") {
+		params := in[len("s3://"):]
+		parts := strings.Split(params, "/")
+		return parts[0], params[len(parts[0]):]
+	}
+	return "", ""
+}
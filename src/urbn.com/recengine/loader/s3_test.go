@@ -0,0 +1,146 @@
+package loader
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+)
+
+//a fake s3 of one bucket, listing at most pageSize keys a page. the objects are served with their ranges
+type fakeS3 struct {
+	bucket   string
+	objects  map[string][]byte
+	pageSize int
+	lists    int
+	ranges   []string
+}
+
+func (fake *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/"+fake.bucket)
+	if path == "" || path == "/" {
+		fake.list(w, r)
+		return
+	}
+	object, ok := fake.objects[strings.TrimPrefix(path, "/")]
+	if !ok {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	fake.ranges = append(fake.ranges, r.Header.Get("Range"))
+	http.ServeContent(w, r, path, testModified, bytes.NewReader(object))
+}
+
+func (fake *fakeS3) list(w http.ResponseWriter, r *http.Request) {
+	fake.lists++
+	prefix, marker := r.URL.Query().Get("prefix"), r.URL.Query().Get("marker")
+	var keys []string
+	for key := range fake.objects {
+		if strings.HasPrefix(key, prefix) && key > marker {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	truncated := len(keys) > fake.pageSize
+	if truncated {
+		keys = keys[:fake.pageSize]
+	}
+	fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8"?><ListBucketResult><Name>%s</Name><Prefix>%s</Prefix><IsTruncated>%t</IsTruncated>`, fake.bucket, prefix, truncated)
+	for _, key := range keys {
+		fmt.Fprintf(w, `<Contents><Key>%s</Key><Size>%d</Size><LastModified>2026-01-02T03:04:05.000Z</LastModified></Contents>`, key, len(fake.objects[key]))
+	}
+	fmt.Fprint(w, `</ListBucketResult>`)
+}
+
+//a client of the fake s3 served by server
+func fakeS3Client(server *httptest.Server) *s3.S3 {
+	return s3.New(session.Must(session.NewSession()), &aws.Config{
+		Region:           aws.String("us-east-1"),
+		Endpoint:         aws.String(server.URL),
+		S3ForcePathStyle: aws.Bool(true),
+		Credentials:      credentials.NewStaticCredentials("id", "secret", ""),
+	})
+}
+
+func TestListPartObjects(t *testing.T) {
+	fake := &fakeS3{bucket: "bucket", pageSize: 2, objects: map[string][]byte{
+		"model/part-00000":     nil,
+		"model/part-00001":     nil,
+		"model/part-00002":     nil,
+		"model/part-00002.crc": nil,
+		"model/_SUCCESS":       nil,
+		"modelx/part-00000":    nil,
+		"part-00000":           nil,
+	}}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	svc := fakeS3Client(server)
+
+	for location, want := range map[string]string{
+		"s3://bucket/model":  "[model/part-00000 model/part-00001 model/part-00002]",
+		"s3://bucket/model/": "[model/part-00000 model/part-00001 model/part-00002]",
+		"s3://bucket":        "[part-00000]",
+		"s3://bucket/":       "[part-00000]",
+	} {
+		objects, err := ListPartObjects(svc, location)
+		if err != nil {
+			t.Fatalf("%s: %s", location, err.Error())
+		}
+		var keys []string
+		for _, obj := range objects {
+			keys = append(keys, aws.StringValue(obj.Key))
+		}
+		if fmt.Sprint(keys) != want {
+			t.Errorf("%s: listed %v, want %s", location, keys, want)
+		}
+	}
+	if fake.lists < 6 {
+		t.Errorf("%d list calls, the listing of s3://bucket/model was not paginated", fake.lists)
+	}
+
+	if _, err := ListPartObjects(svc, "s3://other/model"); err == nil {
+		t.Error("listed a missing bucket")
+	}
+}
+
+func TestLoadPopularListsFromS3(t *testing.T) {
+	fake := &fakeS3{bucket: "bucket", pageSize: 1, objects: map[string][]byte{
+		"popular/part-00000":     []byte(`{"list":"topSellers","items":[{"productId":"P1","totalScore":3}]}` + "\n"),
+		"popular/part-00001":     []byte(`{"list":"trending","items":[{"productId":"P2","totalScore":150}]}` + "\n"),
+		"popular/part-00001.crc": []byte("crc"),
+		"part-00000":             []byte(`{"list":"trending","items":[{"productId":"P3","totalScore":120}]}` + "\n"),
+	}}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	for location, want := range map[string]string{
+		"s3://bucket/popular": "topSellers:P1 trending:P2",
+		"s3://bucket":         "trending:P3",
+	} {
+		lists, report, err := LoadPopularListsFromS3(fakeS3Client(server), location)
+		if err != nil {
+			t.Fatalf("%s: %s", location, err.Error())
+		}
+		var names []string
+		for name, list := range lists {
+			names = append(names, name+":"+list.Items[0].ProductID)
+		}
+		sort.Strings(names)
+		if strings.Join(names, " ") != want {
+			t.Errorf("%s: loaded %v, want %s", location, names, want)
+		}
+		if report.Rejected != 0 {
+			t.Errorf("%s: rejected %d records", location, report.Rejected)
+		}
+	}
+	if _, _, err := LoadPopularListsFromS3(fakeS3Client(server), "s3://other/popular"); err == nil {
+		t.Error("loaded the popularity lists of a missing bucket")
+	}
+}
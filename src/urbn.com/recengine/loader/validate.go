@@ -0,0 +1,211 @@
+package loader
+
+import (
+	"errors"
+	"github.com/golang/glog"
+	"sort"
+	"strings"
+	"time"
+	"urbn.com/recengine/model"
+)
+
+//why a record is rejected, the reasons a LoadReport counts
+var (
+	ErrMalformed          = errors.New("malformed record")
+	ErrUnsupportedFormat  = errors.New("unsupported format")
+	ErrEmptyProductId     = errors.New("empty productId")
+	ErrEmptyItemId        = errors.New("empty bought together productId")
+	ErrNegativeScore      = errors.New("negative score")
+	ErrSelfRecommendation = errors.New("product recommends itself")
+	ErrVariantSeparator   = errors.New("id has the variant separator")
+	ErrUnknownList        = errors.New("unknown popularity list")
+)
+
+//how many rejected records a FileReport keeps as samples
+const MAX_REJECTION_SAMPLES = 10
+
+//check a product against the schema: a productId, every item with a productId and other than the product,
+//or the variant, itself, no id, color or sku having the model.VARIANT_SEPARATOR and no negative total or
+//region score. the schema is checked in code rather than with
+//a JSON Schema document: the records come in formats other than json too, parquet, avro and csv, and are all
+//validated once decoded into a model.Product. the loader drops the items recommending the product itself
+//before, see DropSelfRecommendations, so only a product given to ValidateProduct directly fails on them
+func ValidateProduct(prod model.Product) error {
+	if prod.ProductID == "" {
+		return ErrEmptyProductId
+	} else if hasVariantSeparator(prod.ProductID, prod.Color, prod.SkuID) {
+		return ErrVariantSeparator
+	}
+	for _, item := range prod.BoughtTogetherItems {
+		if item.ProductID == "" {
+			return ErrEmptyItemId
+		} else if hasVariantSeparator(item.ProductID, item.Color, item.SkuID) {
+			return ErrVariantSeparator
+		} else if item.Key() == prod.Key() {
+			return ErrSelfRecommendation
+		} else if item.TotalScore < 0 {
+			return ErrNegativeScore
+		}
+		for _, regionScore := range item.ScoreByRegion {
+			if regionScore.Score < 0 {
+				return ErrNegativeScore
+			}
+		}
+	}
+	return nil
+}
+
+//whether any of ids has the separator of the variant keys, which would make the key of its variant ambiguous
+func hasVariantSeparator(ids ...string) bool {
+	for _, id := range ids {
+		if strings.Contains(id, model.VARIANT_SEPARATOR) {
+			return true
+		}
+	}
+	return false
+}
+
+//the outcome of one load: the dataset version, the time its latest part file was modified, and the records
+//read, loaded and rejected per part file, with the items dropped out of the records loaded
+type LoadReport struct {
+	Version      string         `json:"version"`
+	Modified     time.Time      `json:"modified"`
+	Records      int            `json:"records"`
+	Loaded       int            `json:"loaded"`
+	Rejected     int            `json:"rejected"`
+	Reasons      map[string]int `json:"reasons"`
+	DroppedItems map[string]int `json:"droppedItems,omitempty"`
+	Files        []*FileReport  `json:"files"`
+}
+
+type FileReport struct {
+	Name        string         `json:"name"`
+	Format      string         `json:"format"`
+	Compression string         `json:"compression,omitempty"`
+	Modified    time.Time      `json:"modified"`
+	Records     int            `json:"records"`
+	Loaded      int            `json:"loaded"`
+	Rejected    int            `json:"rejected"`
+	Reasons     map[string]int `json:"reasons"`
+	//the items dropped out of the records loaded, by reason
+	DroppedItems map[string]int `json:"droppedItems,omitempty"`
+	Samples      []Rejection    `json:"samples,omitempty"`
+}
+
+//a rejected record, line is 0 when the whole file is rejected
+type Rejection struct {
+	Line   int    `json:"line"`
+	Reason string `json:"reason"`
+	Record string `json:"record,omitempty"`
+}
+
+func NewLoadReport() *LoadReport {
+	return &LoadReport{Reasons: make(map[string]int)}
+}
+
+//start the report of the part file name, last modified at modified
+func (report *LoadReport) File(name string, modified time.Time) *FileReport {
+	file := &FileReport{Name: name, Modified: modified, Reasons: make(map[string]int)}
+	report.Files = append(report.Files, file)
+	return file
+}
+
+//total the file reports and log the summary
+func (report *LoadReport) finish(version string) *LoadReport {
+	report.Version = version
+	report.Records, report.Loaded, report.Rejected = 0, 0, 0
+	report.Reasons = make(map[string]int)
+	report.DroppedItems = nil
+	for _, file := range report.Files {
+		if file.Modified.After(report.Modified) {
+			report.Modified = file.Modified
+		}
+		report.Records += file.Records
+		report.Loaded += file.Loaded
+		report.Rejected += file.Rejected
+		for reason, count := range file.Reasons {
+			report.Reasons[reason] += count
+		}
+		for reason, count := range file.DroppedItems {
+			if report.DroppedItems == nil {
+				report.DroppedItems = make(map[string]int)
+			}
+			report.DroppedItems[reason] += count
+		}
+	}
+	glog.Infof("loaded %d of %d records from %d part files, dataset version %s", report.Loaded, report.Records, len(report.Files), version)
+	if report.Rejected > 0 {
+		reasons := make([]string, 0, len(report.Reasons))
+		for reason := range report.Reasons {
+			reasons = append(reasons, reason)
+		}
+		sort.Strings(reasons)
+		for _, reason := range reasons {
+			glog.Warningf("rejected %d records: %s", report.Reasons[reason], reason)
+		}
+	}
+	for reason, count := range report.DroppedItems {
+		glog.Warningf("dropped %d items: %s", count, reason)
+	}
+	return report
+}
+
+//validate a decoded product and hand it to add when it passes, without the items recommending it
+func (file *FileReport) accept(line int, prod model.Product, record string, add func(model.Product)) {
+	prod, dropped := DropSelfRecommendations(prod)
+	if err := ValidateProduct(prod); err != nil {
+		file.reject(line, err, record)
+		return
+	}
+	file.Records++
+	file.Loaded++
+	if dropped > 0 {
+		if file.DroppedItems == nil {
+			file.DroppedItems = make(map[string]int)
+		}
+		file.DroppedItems[ErrSelfRecommendation.Error()] += dropped
+		glog.V(2).Infof("dropped %d items of %s line %d: %s", dropped, file.Name, line, ErrSelfRecommendation.Error())
+	}
+	add(prod)
+}
+
+//the product without the items which are the product, or the variant, itself, and how many were dropped
+func DropSelfRecommendations(prod model.Product) (model.Product, int) {
+	key := prod.Key()
+	for i, item := range prod.BoughtTogetherItems {
+		if item.Key() != key {
+			continue
+		}
+		items := append(make([]model.BoughtTogetherItem, 0, len(prod.BoughtTogetherItems)-1), prod.BoughtTogetherItems[:i]...)
+		for _, item := range prod.BoughtTogetherItems[i+1:] {
+			if item.Key() != key {
+				items = append(items, item)
+			}
+		}
+		dropped := len(prod.BoughtTogetherItems) - len(items)
+		prod.BoughtTogetherItems = items
+		return prod, dropped
+	}
+	return prod, 0
+}
+
+//account for a record which can not be loaded, counted under the sentinel error it wraps
+func (file *FileReport) reject(line int, err error, record string) {
+	file.Records++
+	file.Rejected++
+	file.Reasons[reason(err)]++
+	if len(file.Samples) < MAX_REJECTION_SAMPLES {
+		file.Samples = append(file.Samples, Rejection{Line: line, Reason: err.Error(), Record: record})
+	}
+	glog.V(2).Infof("rejected %s line %d: %s", file.Name, line, err.Error())
+}
+
+func reason(err error) string {
+	for _, sentinel := range []error{ErrMalformed, ErrUnsupportedFormat, ErrEmptyProductId, ErrEmptyItemId,
+		ErrNegativeScore, ErrSelfRecommendation, ErrUnknownList} {
+		if errors.Is(err, sentinel) {
+			return sentinel.Error()
+		}
+	}
+	return err.Error()
+}
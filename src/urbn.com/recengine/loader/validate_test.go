@@ -0,0 +1,107 @@
+package loader
+
+import (
+	"errors"
+	"testing"
+	"time"
+	"urbn.com/recengine/model"
+)
+
+//the modification time of the test part files
+var testModified = time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+
+//parse contents as the part file name, returning the products loaded and the report
+func parseTestFile(name string, contents string) (map[string]model.Product, *LoadReport) {
+	products := make(map[string]model.Product)
+	report := NewLoadReport()
+	ParseProducts([]byte(contents), report.File(name, testModified), func(prod model.Product) {
+		products[prod.Key()] = prod
+	})
+	return products, report.finish("test")
+}
+
+func TestDetectFormat(t *testing.T) {
+	for contents, want := range map[string]string{
+		`(A,{"productId":"A"})`:     FORMAT_SPARK_TUPLE,
+		"\n\n{\"productId\":\"A\"}": FORMAT_JSONL,
+		"A,B,PA,3":                  FORMAT_CSV,
+		"A\tB\tPA\t3":               FORMAT_TSV,
+		"PAR1....":                  FORMAT_PARQUET,
+		AVRO_MAGIC + "...":          FORMAT_AVRO,
+		"A":                         FORMAT_UNKNOWN,
+		"":                          FORMAT_UNKNOWN,
+	} {
+		if got := DetectFormat([]byte(contents)); got != want {
+			t.Errorf("DetectFormat(%q) = %s, want %s", contents, got, want)
+		}
+	}
+}
+
+func TestValidateProduct(t *testing.T) {
+	for _, test := range []struct {
+		prod model.Product
+		want error
+	}{
+		{model.Product{ProductID: "A", BoughtTogetherItems: []model.BoughtTogetherItem{{ProductID: "B", TotalScore: 1}}}, nil},
+		{model.Product{BoughtTogetherItems: []model.BoughtTogetherItem{{ProductID: "B"}}}, ErrEmptyProductId},
+		{model.Product{ProductID: "A", BoughtTogetherItems: []model.BoughtTogetherItem{{ProductID: ""}}}, ErrEmptyItemId},
+		{model.Product{ProductID: "A", BoughtTogetherItems: []model.BoughtTogetherItem{{ProductID: "B", TotalScore: -1}}}, ErrNegativeScore},
+		{model.Product{ProductID: "A", BoughtTogetherItems: []model.BoughtTogetherItem{{ProductID: "B", ScoreByRegion: []model.RegionScore{{Region: "PA", Score: -1}}}}}, ErrNegativeScore},
+		{model.Product{ProductID: "A", BoughtTogetherItems: []model.BoughtTogetherItem{{ProductID: "A"}}}, ErrSelfRecommendation},
+		{model.Product{ProductID: "A" + model.VARIANT_SEPARATOR + "B", BoughtTogetherItems: []model.BoughtTogetherItem{{ProductID: "B"}}}, ErrVariantSeparator},
+		{model.Product{ProductID: "A", BoughtTogetherItems: []model.BoughtTogetherItem{{ProductID: "B", Color: model.VARIANT_SEPARATOR}}}, ErrVariantSeparator},
+		//another variant of the product is not the product itself
+		{model.Product{ProductID: "A", Color: "blue", BoughtTogetherItems: []model.BoughtTogetherItem{{ProductID: "A", Color: "red"}}}, nil},
+	} {
+		if err := ValidateProduct(test.prod); !errors.Is(err, test.want) || (err == nil) != (test.want == nil) {
+			t.Errorf("ValidateProduct(%+v) = %v, want %v", test.prod, err, test.want)
+		}
+	}
+}
+
+func TestSelfRecommendationDropped(t *testing.T) {
+	products, report := parseTestFile("part-00000", `{"productId":"A","boughtTogetherItems":[{"productId":"A","totalScore":9},{"productId":"B","totalScore":2},{"productId":"A","totalScore":1}]}
+{"productId":"C","boughtTogetherItems":[{"productId":"B","totalScore":1}]}
+`)
+	prod, ok := products["A"]
+	if !ok {
+		t.Fatal("A was rejected for recommending itself")
+	}
+	if len(prod.BoughtTogetherItems) != 1 || prod.BoughtTogetherItems[0].ProductID != "B" {
+		t.Errorf("A has items %+v, want only B", prod.BoughtTogetherItems)
+	}
+	if report.Loaded != 2 || report.Rejected != 0 {
+		t.Errorf("loaded %d, rejected %d, want 2 and 0", report.Loaded, report.Rejected)
+	}
+	if got := report.DroppedItems[ErrSelfRecommendation.Error()]; got != 2 {
+		t.Errorf("dropped %d self recommending items, want 2", got)
+	}
+	if got := report.Files[0].DroppedItems[ErrSelfRecommendation.Error()]; got != 2 {
+		t.Errorf("the file report dropped %d items, want 2", got)
+	}
+}
+
+func TestParseFormats(t *testing.T) {
+	for name, contents := range map[string]string{
+		"spark": `(A,{"productId":"A","boughtTogetherItems":[{"productId":"B","totalScore":3,"scoreByRegion":[{"region":"PA","score":3}]}]})
+(bad line
+`,
+		"jsonl": `{"productId":"A","boughtTogetherItems":[{"productId":"B","totalScore":3,"scoreByRegion":[{"region":"PA","score":3}]}]}
+{"productId":
+`,
+		"v1": `{"productId":"A","sortedRelates":[{"productId":"B","score":3}]}
+{"productId":"",  "sortedRelates":[]}
+`,
+		"csv": "productId,itemId,region,score\nA,B,PA,3\nA,C,PA,x\n",
+		"tsv": "A\tB\tPA\t3\nA\t\tPA\t1\n",
+	} {
+		products, report := parseTestFile("part-00000", contents)
+		prod, ok := products["A"]
+		if !ok || len(prod.BoughtTogetherItems) != 1 || prod.BoughtTogetherItems[0].ProductID != "B" || prod.BoughtTogetherItems[0].TotalScore != 3 {
+			t.Errorf("%s: A = %+v, %t", name, prod, ok)
+		}
+		if report.Loaded != 1 || report.Rejected != 1 || len(report.Files[0].Samples) != 1 {
+			t.Errorf("%s: loaded %d, rejected %d, %d samples, want 1, 1 and 1", name, report.Loaded, report.Rejected, len(report.Files[0].Samples))
+		}
+	}
+}
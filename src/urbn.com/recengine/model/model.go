@@ -0,0 +1,160 @@
+//Package model holds the recommendation data model, in the json shape it is loaded from the part files and served in.
+package model
+
+import (
+	"strings"
+)
+
+//a product, or one variant of it, and the items bought together with it, best first
+type Product struct {
+	ProductID string `json:"productId"`
+	//the variant the items are for, a color or a sku. both are empty for the product level items
+	Color               string               `json:"color,omitempty"`
+	SkuID               string               `json:"skuId,omitempty"`
+	BoughtTogetherItems []BoughtTogetherItem `json:"boughtTogetherItems"`
+	//the items of the relation types a request asks for, by type. only set on the v2 responses
+	Relations map[string][]BoughtTogetherItem `json:"relations,omitempty"`
+	//what the business rules did to the items, only set on the responses of the debug mode
+	Debug []RuleDecision `json:"debug,omitempty"`
+}
+
+//an item a business rule removed, boosted or pinned, and the rule, like exclude:outOfStock or cap:brand=BDG
+type RuleDecision struct {
+	ProductID string `json:"productId"`
+	Color     string `json:"color,omitempty"`
+	SkuID     string `json:"skuId,omitempty"`
+	//the relation type of the items, empty for the bought together items
+	Relation string `json:"relation,omitempty"`
+	Rule     string `json:"rule"`
+	Action   string `json:"action"`
+}
+
+//the relation types of a product. bought together is the type of the BoughtTogetherItems of the model, every
+//other type is loaded from its own dataset of products, the items of a product being its related items
+const (
+	RELATION_BOUGHT_TOGETHER = "boughtTogether"
+	RELATION_SIMILAR         = "similar"
+	RELATION_VIEWED_TOGETHER = "viewedTogether"
+	RELATION_REPURCHASED     = "repurchased"
+)
+
+//the relation types loaded from their own dataset
+var RelationTypes = []string{RELATION_SIMILAR, RELATION_VIEWED_TOGETHER, RELATION_REPURCHASED}
+
+//a recommended product, with the color or sku recommended when the model is variant level
+type BoughtTogetherItem struct {
+	ProductID     string        `json:"productId"`
+	Color         string        `json:"color,omitempty"`
+	SkuID         string        `json:"skuId,omitempty"`
+	TotalScore    int           `json:"totalScore"`
+	ScoreByRegion []RegionScore `json:"scoreByRegion"`
+}
+
+//joins a product id and a color or sku into the key a variant is stored under. it is the ascii unit separator
+//rather than a dash, which product ids, colors like OFF-WHITE and skus have, so a key always splits back into
+//the product and its variant. the loader rejects the records with an id having it
+const VARIANT_SEPARATOR = "\x1f"
+
+//the key of the variant of productId, the product id itself when variant is empty
+func VariantKey(productId string, variant string) string {
+	if variant == "" {
+		return productId
+	}
+	return productId + VARIANT_SEPARATOR + variant
+}
+
+//the product id and the color or sku of a variant key, the variant is empty for a product key
+func ParseVariantKey(key string) (string, string) {
+	if i := strings.Index(key, VARIANT_SEPARATOR); i >= 0 {
+		return key[:i], key[i+len(VARIANT_SEPARATOR):]
+	}
+	return key, ""
+}
+
+//the sku of the variant, or its color when it has no sku
+func (prod Product) Variant() string {
+	if prod.SkuID != "" {
+		return prod.SkuID
+	}
+	return prod.Color
+}
+
+//the key the product is stored and looked up under
+func (prod Product) Key() string {
+	return VariantKey(prod.ProductID, prod.Variant())
+}
+
+//the key of the variant recommended, the product id for a product level item
+func (item BoughtTogetherItem) Key() string {
+	if item.SkuID != "" {
+		return VariantKey(item.ProductID, item.SkuID)
+	}
+	return VariantKey(item.ProductID, item.Color)
+}
+
+//the score of an item within one region, a state code like PA
+type RegionScore struct {
+	Region string `json:"region"`
+	Score  int    `json:"score"`
+}
+
+//an empty recommendation, what is served for a product with no data
+func EmptyProduct(productId string) Product {
+	return Product{
+		ProductID:           productId,
+		BoughtTogetherItems: []BoughtTogetherItem{},
+	}
+}
+
+//the v1 schema: a product's related products sorted by score, loaded as sortedRelates
+type RelatedProduct struct {
+	ProductID string `json:"productId"`
+	Color     string `json:"color,omitempty"`
+	SkuID     string `json:"skuId,omitempty"`
+	Score     int    `json:"score"`
+}
+
+//the v1 response
+type ProductRecommendation struct {
+	ProductID  string           `json:"productId"`
+	Color      string           `json:"color,omitempty"`
+	SkuID      string           `json:"skuId,omitempty"`
+	BoughtWith []RelatedProduct `json:"boughtTogether"`
+}
+
+//convert down to the v1 response, an item scores its TotalScore
+func ToRecommendation(prod Product) ProductRecommendation {
+	recommendation := ProductRecommendation{
+		ProductID:  prod.ProductID,
+		Color:      prod.Color,
+		SkuID:      prod.SkuID,
+		BoughtWith: make([]RelatedProduct, len(prod.BoughtTogetherItems)),
+	}
+	for i, item := range prod.BoughtTogetherItems {
+		recommendation.BoughtWith[i] = RelatedProduct{
+			ProductID: item.ProductID,
+			Color:     item.Color,
+			SkuID:     item.SkuID,
+			Score:     item.TotalScore,
+		}
+	}
+	return recommendation
+}
+
+//convert v1 sorted relates up, the items have a TotalScore but no ScoreByRegion
+func FromSortedRelates(productId string, sortedRelates []RelatedProduct) Product {
+	prod := Product{
+		ProductID:           productId,
+		BoughtTogetherItems: make([]BoughtTogetherItem, len(sortedRelates)),
+	}
+	for i, related := range sortedRelates {
+		prod.BoughtTogetherItems[i] = BoughtTogetherItem{
+			ProductID:     related.ProductID,
+			Color:         related.Color,
+			SkuID:         related.SkuID,
+			TotalScore:    related.Score,
+			ScoreByRegion: []RegionScore{},
+		}
+	}
+	return prod
+}
@@ -0,0 +1,41 @@
+package model
+
+import (
+	"testing"
+)
+
+func TestVariantKey(t *testing.T) {
+	for _, test := range []struct {
+		productId string
+		variant   string
+	}{
+		{"37418258", "BLACK"},
+		{"37418258", "OFF-WHITE"},
+		{"37418258-OFF", "WHITE"},
+		{"37418258", ""},
+		{"37418258", "37418258-0001"},
+	} {
+		key := VariantKey(test.productId, test.variant)
+		if productId, variant := ParseVariantKey(key); productId != test.productId || variant != test.variant {
+			t.Errorf("VariantKey(%s, %s) = %q parsed back to %s, %s", test.productId, test.variant, key, productId, variant)
+		}
+	}
+	//a dash in the product id or the color does not make two variants share a key
+	if VariantKey("37418258-OFF", "WHITE") == VariantKey("37418258", "OFF-WHITE") {
+		t.Error("two variants have the same key")
+	}
+	if key := VariantKey("37418258", ""); key != "37418258" {
+		t.Errorf("the product key is %q, want the product id", key)
+	}
+}
+
+func TestKeys(t *testing.T) {
+	prod := Product{ProductID: "A", Color: "BLACK", SkuID: "A-1"}
+	if prod.Key() != VariantKey("A", "A-1") {
+		t.Errorf("the sku of a product is not its key: %q", prod.Key())
+	}
+	item := BoughtTogetherItem{ProductID: "B", Color: "NUDE"}
+	if item.Key() != VariantKey("B", "NUDE") {
+		t.Errorf("the color of an item is not its key: %q", item.Key())
+	}
+}
@@ -0,0 +1,25 @@
+package model
+
+//the popularity lists
+const (
+	//the products in the most orders
+	LIST_TOP_SELLERS = "topSellers"
+	//the products whose share of the recent orders grew the most over their share of all the orders
+	LIST_TRENDING = "trending"
+)
+
+//a popularity list, its products best first. every item is scored overall in TotalScore and within every
+//region it sold in in ScoreByRegion, with the region codes of the bought together items
+type PopularList struct {
+	List string `json:"list"`
+	//the region a served list is filtered by, empty for the overall list
+	Region string               `json:"region,omitempty"`
+	Items  []BoughtTogetherItem `json:"items"`
+	//what the business rules did to the items, only set on the responses of the debug mode
+	Debug []RuleDecision `json:"debug,omitempty"`
+}
+
+//an empty list, what is served for a list with no data
+func EmptyPopularList(list string) PopularList {
+	return PopularList{List: list, Items: []BoughtTogetherItem{}}
+}
@@ -0,0 +1,149 @@
+//Package ranking turns the stored products into the recommendations served, shared by the http and grpc apis.
+package ranking
+
+import (
+	"math"
+	"sort"
+	"urbn.com/recengine/model"
+	"urbn.com/recengine/rules"
+	"urbn.com/recengine/store"
+)
+
+//the most bought together items served per product
+const MAX_ITEMS = 10
+
+//Filter is what is done to the items of a product between its lookup and their truncation: the business rules
+//applied, none when nil, whether the decisions of the rules are reported, and how many items are kept
+type Filter struct {
+	Rules *rules.RuleSet
+	Debug bool
+	//the most items kept, MAX_ITEMS when 0, every item when negative
+	MaxItems int
+}
+
+//apply the rules to the items of prod, then keep the best MaxItems of them
+func (filter Filter) Apply(prod model.Product) model.Product {
+	prod = filter.Rules.Apply(prod, filter.Debug)
+	if filter.MaxItems == 0 {
+		return FilterResult(prod)
+	} else if filter.MaxItems > 0 && len(prod.BoughtTogetherItems) > filter.MaxItems {
+		prod.BoughtTogetherItems = prod.BoughtTogetherItems[:filter.MaxItems]
+	}
+	return prod
+}
+
+//the filtered product from source, an unknown product gets an empty list
+func FindProduct(source store.ProductSource, productId string, filter Filter) model.Product {
+	prod, ok := source.Get(productId)
+	if !ok {
+		prod = model.EmptyProduct(productId)
+	}
+	return filter.Apply(prod)
+}
+
+//the filtered items of the first of variants of productId the source has, its sku then its color, falling
+//back to the product level ones when it has none of them
+func FindVariant(source store.ProductSource, productId string, variants []string, filter Filter) model.Product {
+	for _, variant := range variants {
+		if variant == "" {
+			continue
+		}
+		if prod, ok := source.Get(model.VariantKey(productId, variant)); ok {
+			return filter.Apply(prod)
+		}
+	}
+	return FindProduct(source, productId, filter)
+}
+
+//keep the best MAX_ITEMS items, the items are stored best first
+func FilterResult(prod model.Product) model.Product {
+	if len(prod.BoughtTogetherItems) > 0 {
+		min := math.Min(MAX_ITEMS, (float64(len(prod.BoughtTogetherItems))))
+		filtered := prod.BoughtTogetherItems[0:int(min)]
+		prod.BoughtTogetherItems = filtered
+	}
+	return prod
+}
+
+//the best MAX_ITEMS items of a popularity list, or of the items with a score in region ranked by it when
+//region is not empty, filtered by the rules of filter
+func FilterPopular(list model.PopularList, region string, filter Filter) model.PopularList {
+	if region != "" {
+		items := make([]model.BoughtTogetherItem, 0, len(list.Items))
+		for _, item := range list.Items {
+			if regionScore(item, region) >= 0 {
+				items = append(items, item)
+			}
+		}
+		sort.SliceStable(items, func(i, j int) bool {
+			return regionScore(items[i], region) > regionScore(items[j], region)
+		})
+		list.Region, list.Items = region, items
+	}
+	list = applyPopularRules(list, filter)
+	if len(list.Items) > MAX_ITEMS {
+		list.Items = list.Items[:MAX_ITEMS]
+	}
+	return list
+}
+
+//apply the rules to the items of a list as to those of a product of no id, which only the pins of every product
+//apply to. the items of a list filtered by region are ranked by their score in it, which stands as their
+//TotalScore while the rules apply, their overall TotalScore being served
+func applyPopularRules(list model.PopularList, filter Filter) model.PopularList {
+	if filter.Rules == nil {
+		return list
+	}
+	items := list.Items
+	var totals map[string]int
+	if list.Region != "" {
+		totals = make(map[string]int, len(list.Items))
+		items = make([]model.BoughtTogetherItem, len(list.Items))
+		for i, item := range list.Items {
+			totals[model.VariantKey(item.ProductID, item.Color)] = item.TotalScore
+			item.TotalScore = regionScore(item, list.Region)
+			items[i] = item
+		}
+	}
+	prod := filter.Rules.Apply(model.Product{BoughtTogetherItems: items}, filter.Debug)
+	if totals != nil {
+		for i := range prod.BoughtTogetherItems {
+			item := &prod.BoughtTogetherItems[i]
+			item.TotalScore = totals[model.VariantKey(item.ProductID, item.Color)]
+		}
+	}
+	list.Items, list.Debug = prod.BoughtTogetherItems, prod.Debug
+	return list
+}
+
+//the score of item in region, -1 when it has none
+func regionScore(item model.BoughtTogetherItem, region string) int {
+	for _, score := range item.ScoreByRegion {
+		if score.Region == region {
+			return score.Score
+		}
+	}
+	return -1
+}
+
+//set the Relations of prod to the filtered items of every type of types, the bought together items of prod
+//or those of prod looked up the way FindVariant does in the dataset of the type. a type without a dataset
+//gets an empty list. the decisions of the rules on the items of a type are added to the Debug of prod
+func FindRelations(relations *store.Relations, prod model.Product, variants []string, types []string, filter Filter) model.Product {
+	prod.Relations = make(map[string][]model.BoughtTogetherItem, len(types))
+	for _, relationType := range types {
+		if relationType == model.RELATION_BOUGHT_TOGETHER {
+			prod.Relations[relationType] = prod.BoughtTogetherItems
+		} else if source, ok := relations.Source(relationType); ok {
+			related := FindVariant(source, prod.ProductID, variants, filter)
+			prod.Relations[relationType] = related.BoughtTogetherItems
+			for _, decision := range related.Debug {
+				decision.Relation = relationType
+				prod.Debug = append(prod.Debug, decision)
+			}
+		} else {
+			prod.Relations[relationType] = []model.BoughtTogetherItem{}
+		}
+	}
+	return prod
+}
@@ -0,0 +1,90 @@
+package ranking
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"urbn.com/recengine/model"
+	"urbn.com/recengine/rules"
+	"urbn.com/recengine/store"
+)
+
+func TestFindVariant(t *testing.T) {
+	relates := store.NewRelatedProducts()
+	for _, prod := range []model.Product{
+		{ProductID: "A", BoughtTogetherItems: []model.BoughtTogetherItem{{ProductID: "B"}}},
+		{ProductID: "A", Color: "BLACK", BoughtTogetherItems: []model.BoughtTogetherItem{{ProductID: "B", Color: "NUDE"}}},
+		{ProductID: "A", SkuID: "A-1", BoughtTogetherItems: []model.BoughtTogetherItem{{ProductID: "B", SkuID: "B-2"}}},
+	} {
+		relates.Add(prod)
+	}
+	for _, test := range []struct {
+		productId string
+		variants  []string
+		want      string
+	}{
+		{"A", nil, "B"},
+		{"A", []string{"", ""}, "B"},
+		{"A", []string{"", "BLACK"}, "B" + model.VARIANT_SEPARATOR + "NUDE"},
+		{"A", []string{"A-1", "BLACK"}, "B" + model.VARIANT_SEPARATOR + "B-2"},
+		//a sku the model does not have falls back to the color, then to the product
+		{"A", []string{"A-9", "BLACK"}, "B" + model.VARIANT_SEPARATOR + "NUDE"},
+		{"A", []string{"A-9", "RED"}, "B"},
+		{"C", []string{"", "BLACK"}, ""},
+	} {
+		prod := FindVariant(relates, test.productId, test.variants, Filter{})
+		got := ""
+		if len(prod.BoughtTogetherItems) > 0 {
+			got = prod.BoughtTogetherItems[0].Key()
+		}
+		if got != test.want {
+			t.Errorf("FindVariant(%s, %q) recommends %q, want %q", test.productId, test.variants, got, test.want)
+		}
+		if prod.ProductID != test.productId {
+			t.Errorf("FindVariant(%s, %q) served %s", test.productId, test.variants, prod.ProductID)
+		}
+	}
+}
+
+func TestFilterPopular(t *testing.T) {
+	list := model.PopularList{List: model.LIST_TOP_SELLERS, Items: []model.BoughtTogetherItem{
+		{ProductID: "A", TotalScore: 10, ScoreByRegion: []model.RegionScore{{Region: "PA", Score: 1}}},
+		{ProductID: "B", TotalScore: 8, ScoreByRegion: []model.RegionScore{{Region: "PA", Score: 5}}},
+		{ProductID: "C", TotalScore: 6, ScoreByRegion: []model.RegionScore{{Region: "PA", Score: 3}}},
+		{ProductID: "D", TotalScore: 4, ScoreByRegion: []model.RegionScore{}},
+	}}
+	for _, test := range []struct {
+		region string
+		config string
+		want   string
+	}{
+		{"", "", "A:10 B:8 C:6 D:4"},
+		{"PA", "", "B:8 C:6 A:10"},
+		{"", `{"boosts": [{"productIds": ["C"], "factor": 2}]}`, "C:12 A:10 B:8 D:4"},
+		//the rules of a region list rank its items by their score in the region, served with their overall one
+		{"PA", `{"boosts": [{"productIds": ["C"], "factor": 2}]}`, "C:6 B:8 A:10"},
+		{"PA", `{"exclude": {"outOfStock": ["B"]}, "pins": [{"productId": "D", "position": 1}]}`, "D:0 C:6 A:10"},
+	} {
+		filter := Filter{Debug: true}
+		if test.config != "" {
+			var err error
+			if filter.Rules, err = rules.Parse([]byte(test.config)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		filtered := FilterPopular(list, test.region, filter)
+		var items []string
+		for _, item := range filtered.Items {
+			items = append(items, fmt.Sprintf("%s:%d", item.ProductID, item.TotalScore))
+		}
+		if got := strings.Join(items, " "); got != test.want {
+			t.Errorf("%s in %q: %s, want %s", test.config, test.region, got, test.want)
+		}
+		if (test.config == "") != (len(filtered.Debug) == 0) {
+			t.Errorf("%s in %q: the decisions are %v", test.config, test.region, filtered.Debug)
+		}
+	}
+	if list.Items[0].ProductID != "A" || list.Items[2].TotalScore != 6 {
+		t.Errorf("the list served was changed to %v", list.Items)
+	}
+}
@@ -0,0 +1,4 @@
+//the generated protobuf and grpc code of the recommendation service
+package recommendationpb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative recommendation.proto
@@ -0,0 +1,90 @@
+package rules
+
+import (
+	"github.com/golang/glog"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+)
+
+//File is a rules config file, compiled again whenever its contents change. the rules stay the last ones which
+//compiled when a change does not
+type File struct {
+	fileName string
+	mu       sync.RWMutex
+	rules    *RuleSet
+	modified time.Time
+	//the version of the last contents which did not compile, reported once
+	failed string
+}
+
+//open and compile the rules of fileName
+func Open(fileName string) (*File, error) {
+	file := &File{fileName: fileName}
+	if _, err := file.reload(); err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+//the current rules, nil for a nil file
+func (file *File) Rules() *RuleSet {
+	if file == nil {
+		return nil
+	}
+	file.mu.RLock()
+	defer file.mu.RUnlock()
+	return file.rules
+}
+
+//the modification time of the file the current rules were compiled from, or the time they were when the file
+//changed without its modification time moving forward
+func (file *File) Modified() time.Time {
+	file.mu.RLock()
+	defer file.mu.RUnlock()
+	return file.modified
+}
+
+//check the file for changes every interval in the background, and compile it again when it changed
+func (file *File) Watch(interval time.Duration) {
+	go func() {
+		for range time.Tick(interval) {
+			if reloaded, err := file.reload(); err != nil {
+				glog.Errorf("failed to reload the rules %s, keeping version %s %s\n", file.fileName, file.Rules().Version, err.Error())
+			} else if reloaded {
+				glog.Infof("reloaded the rules %s, version %s", file.fileName, file.Rules().Version)
+			}
+		}
+	}()
+}
+
+//compile the file when its contents changed since they last compiled, or failed to, telling whether they did.
+//the contents are told apart by their hash, a copy or a checkout may keep the modification time of the file
+func (file *File) reload() (bool, error) {
+	info, err := os.Stat(file.fileName)
+	if err != nil {
+		return false, err
+	}
+	contents, err := ioutil.ReadFile(file.fileName)
+	if err != nil {
+		return false, err
+	}
+	version := contentVersion(contents)
+	if (file.rules != nil && version == file.Rules().Version) || version == file.failed {
+		return false, nil
+	}
+	rules, err := Parse(contents)
+	if err != nil {
+		file.failed = version
+		return false, err
+	}
+	modified := info.ModTime()
+	if file.rules != nil && !modified.After(file.Modified()) {
+		modified = time.Now()
+	}
+	file.mu.Lock()
+	defer file.mu.Unlock()
+	file.rules, file.modified, file.failed = rules, modified, ""
+	return true, nil
+}
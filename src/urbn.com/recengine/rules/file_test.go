@@ -0,0 +1,71 @@
+package rules
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+//write contents to fileName, modified at
+func writeTestRules(t *testing.T, fileName string, contents string, at time.Time) {
+	if err := ioutil.WriteFile(fileName, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(fileName, at, at); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFileReload(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "rules.json")
+	at := time.Now().Add(-time.Hour).Truncate(time.Second)
+	writeTestRules(t, fileName, `{"exclude": {"outOfStock": ["A"]}}`, at)
+	file, err := Open(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	version := file.Rules().Version
+	if !file.Modified().Equal(at) {
+		t.Errorf("modified %s, want %s", file.Modified(), at)
+	}
+
+	//the same contents modified again are not compiled again
+	writeTestRules(t, fileName, `{"exclude": {"outOfStock": ["A"]}}`, at.Add(time.Minute))
+	if reloaded, err := file.reload(); reloaded || err != nil {
+		t.Errorf("reloaded the same contents: %t, %v", reloaded, err)
+	}
+
+	//other contents are, even when the modification time stays
+	writeTestRules(t, fileName, `{"exclude": {"outOfStock": ["B"]}}`, at)
+	if reloaded, err := file.reload(); !reloaded || err != nil {
+		t.Fatalf("did not reload changed contents: %t, %v", reloaded, err)
+	}
+	if file.Rules().Version == version {
+		t.Error("the version did not change")
+	}
+	if !file.Modified().After(at) {
+		t.Errorf("modified %s, not after %s", file.Modified(), at)
+	}
+	version = file.Rules().Version
+
+	//contents which do not compile keep the rules, and fail once
+	writeTestRules(t, fileName, `{"caps": [{"max": 2}]}`, at.Add(2*time.Minute))
+	if _, err := file.reload(); err != ErrInvalidCap {
+		t.Errorf("reloading an invalid config: %v, want %v", err, ErrInvalidCap)
+	}
+	if reloaded, err := file.reload(); reloaded || err != nil {
+		t.Errorf("reloading the invalid config again: %t, %v", reloaded, err)
+	}
+	if file.Rules().Version != version {
+		t.Error("the invalid config replaced the rules")
+	}
+}
+
+func TestNilFile(t *testing.T) {
+	var file *File
+	if file.Rules() != nil {
+		t.Error("a nil file has rules")
+	}
+}
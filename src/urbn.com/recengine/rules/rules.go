@@ -0,0 +1,248 @@
+//Package rules applies the business rules of merchandising to the items served: the products excluded, like the
+//out of stock or discontinued ones, the caps of the items of a brand or category, and the items boosted or
+//pinned. The rules are read from a json config file, reloaded whenever it changes.
+package rules
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"urbn.com/recengine/model"
+)
+
+//what a rule did to an item, as reported by the debug mode
+const (
+	ACTION_REMOVED = "removed"
+	ACTION_BOOSTED = "boosted"
+	ACTION_PINNED  = "pinned"
+)
+
+var (
+	ErrInvalidCap   = errors.New("a cap needs an attribute and a max of at least 1")
+	ErrInvalidBoost = errors.New("a boost needs product ids and a positive factor")
+	ErrInvalidPin   = errors.New("a pin needs a product id and a position of at least 1")
+)
+
+//Config is the json of a rules file:
+//
+//	{
+//	  "exclude": {"outOfStock": ["37418258"], "discontinued": ["41002233"], "restricted": ["40011122"]},
+//	  "attributes": {"37418258": {"brand": "BDG", "category": "denim"}},
+//	  "caps": [{"attribute": "brand", "max": 2}],
+//	  "boosts": [{"name": "fall", "productIds": ["40001111"], "factor": 1.5}],
+//	  "pins": [{"name": "launch", "productId": "40002222", "position": 1, "products": ["37418258"]}]
+//	}
+type Config struct {
+	//the product ids never recommended, by the reason they are excluded
+	Exclude map[string][]string `json:"exclude"`
+	//the attributes of the products, by product id, the caps count the items by
+	Attributes map[string]map[string]string `json:"attributes"`
+	Caps       []Cap                        `json:"caps"`
+	Boosts     []Boost                      `json:"boosts"`
+	Pins       []Pin                        `json:"pins"`
+}
+
+//the most items served with the same value of an attribute, like 2 items of a brand
+type Cap struct {
+	Attribute string `json:"attribute"`
+	Max       int    `json:"max"`
+}
+
+//the TotalScore of the items of the products is multiplied by factor, and the items ranked again
+type Boost struct {
+	Name       string   `json:"name"`
+	ProductIds []string `json:"productIds"`
+	Factor     float64  `json:"factor"`
+}
+
+//the product is served at position, 1 being the first item, in the items of the products, or of every product
+//when products is empty. it is added when it is not among the items, unless it is excluded
+type Pin struct {
+	Name      string   `json:"name"`
+	ProductId string   `json:"productId"`
+	Position  int      `json:"position"`
+	Products  []string `json:"products"`
+}
+
+//RuleSet is the compiled rules of a config
+type RuleSet struct {
+	//a hash of the config the rules were compiled from
+	Version    string
+	excluded   map[string]string
+	attributes map[string]map[string]string
+	caps       []Cap
+	boosts     map[string]boost
+	pins       []Pin
+}
+
+type boost struct {
+	name   string
+	factor float64
+}
+
+//parse and compile the json of a config
+func Parse(contents []byte) (*RuleSet, error) {
+	var config Config
+	if err := json.Unmarshal(contents, &config); err != nil {
+		return nil, err
+	}
+	set := &RuleSet{
+		Version:    contentVersion(contents),
+		excluded:   make(map[string]string),
+		attributes: config.Attributes,
+		caps:       config.Caps,
+		boosts:     make(map[string]boost),
+		pins:       config.Pins,
+	}
+	reasons := make([]string, 0, len(config.Exclude))
+	for reason := range config.Exclude {
+		reasons = append(reasons, reason)
+	}
+	sort.Strings(reasons)
+	for _, reason := range reasons {
+		for _, productId := range config.Exclude[reason] {
+			if _, ok := set.excluded[productId]; !ok {
+				set.excluded[productId] = reason
+			}
+		}
+	}
+	for _, limit := range set.caps {
+		if limit.Attribute == "" || limit.Max < 1 {
+			return nil, ErrInvalidCap
+		}
+	}
+	for i, b := range config.Boosts {
+		if len(b.ProductIds) == 0 || b.Factor <= 0 {
+			return nil, ErrInvalidBoost
+		}
+		name := b.Name
+		if name == "" {
+			name = fmt.Sprintf("%d", i+1)
+		}
+		for _, productId := range b.ProductIds {
+			set.boosts[productId] = boost{name: name, factor: b.Factor}
+		}
+	}
+	for i, pin := range set.pins {
+		if pin.ProductId == "" || pin.Position < 1 {
+			return nil, ErrInvalidPin
+		} else if pin.Name == "" {
+			set.pins[i].Name = fmt.Sprintf("%d", i+1)
+		}
+	}
+	sort.SliceStable(set.pins, func(i, j int) bool {
+		return set.pins[i].Position < set.pins[j].Position
+	})
+	return set, nil
+}
+
+//the version of the rules of a config, a hash of its json
+func contentVersion(contents []byte) string {
+	h := fnv.New64a()
+	h.Write(contents)
+	return fmt.Sprintf("%016x", h.Sum64())
+}
+
+//apply the rules to the items of prod, before they are truncated: drop the excluded products, boost and rank
+//the items again, cap them, then pin the promoted products, a pinned product counting towards no cap. when
+//debug is set every item removed, boosted or pinned is reported in the Debug of prod. nil rules keep the items
+func (set *RuleSet) Apply(prod model.Product, debug bool) model.Product {
+	if set == nil {
+		return prod
+	}
+	report := func(item model.BoughtTogetherItem, rule string, action string) {
+		if debug {
+			prod.Debug = append(prod.Debug, model.RuleDecision{ProductID: item.ProductID, Color: item.Color, SkuID: item.SkuID, Rule: rule, Action: action})
+		}
+	}
+
+	items := make([]model.BoughtTogetherItem, 0, len(prod.BoughtTogetherItems))
+	boosted := false
+	for _, item := range prod.BoughtTogetherItems {
+		if reason, ok := set.excluded[item.ProductID]; ok {
+			report(item, "exclude:"+reason, ACTION_REMOVED)
+			continue
+		}
+		if b, ok := set.boosts[item.ProductID]; ok {
+			item.TotalScore = int(float64(item.TotalScore)*b.factor + 0.5)
+			report(item, "boost:"+b.name, ACTION_BOOSTED)
+			boosted = true
+		}
+		items = append(items, item)
+	}
+	if boosted {
+		sort.SliceStable(items, func(i, j int) bool {
+			return items[i].TotalScore > items[j].TotalScore
+		})
+	}
+
+	if len(set.caps) > 0 {
+		counts := make(map[string]int)
+		capped := items[:0]
+		for _, item := range items {
+			if rule := set.capped(item, counts); rule != "" {
+				report(item, rule, ACTION_REMOVED)
+				continue
+			}
+			capped = append(capped, item)
+		}
+		items = capped
+	}
+
+	for _, pin := range set.pins {
+		if _, excluded := set.excluded[pin.ProductId]; excluded || !pin.pins(prod) {
+			continue
+		}
+		pinned := model.BoughtTogetherItem{ProductID: pin.ProductId, ScoreByRegion: []model.RegionScore{}}
+		for i, item := range items {
+			if item.ProductID == pin.ProductId {
+				pinned = item
+				items = append(items[:i], items[i+1:]...)
+				break
+			}
+		}
+		position := pin.Position - 1
+		if position > len(items) {
+			position = len(items)
+		}
+		items = append(items, model.BoughtTogetherItem{})
+		copy(items[position+1:], items[position:])
+		items[position] = pinned
+		report(pinned, "pin:"+pin.Name, ACTION_PINNED)
+	}
+	prod.BoughtTogetherItems = items
+	return prod
+}
+
+//the rule of the first cap the item is over, counting it towards every cap when it is under all of them
+func (set *RuleSet) capped(item model.BoughtTogetherItem, counts map[string]int) string {
+	attributes := set.attributes[item.ProductID]
+	for _, limit := range set.caps {
+		if value, ok := attributes[limit.Attribute]; ok && counts[limit.Attribute+"="+value] >= limit.Max {
+			return "cap:" + limit.Attribute + "=" + value
+		}
+	}
+	for _, limit := range set.caps {
+		if value, ok := attributes[limit.Attribute]; ok {
+			counts[limit.Attribute+"="+value]++
+		}
+	}
+	return ""
+}
+
+//whether the pin applies to the items of prod, never pinning a product to itself
+func (pin Pin) pins(prod model.Product) bool {
+	if pin.ProductId == prod.ProductID {
+		return false
+	} else if len(pin.Products) == 0 {
+		return true
+	}
+	for _, productId := range pin.Products {
+		if productId == prod.ProductID {
+			return true
+		}
+	}
+	return false
+}
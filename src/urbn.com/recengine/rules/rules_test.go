@@ -0,0 +1,131 @@
+package rules
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"urbn.com/recengine/model"
+)
+
+//a product of the items A:10 B:8 C:6 D:4
+func testProduct() model.Product {
+	prod := model.Product{ProductID: "P"}
+	for i, productId := range []string{"A", "B", "C", "D"} {
+		prod.BoughtTogetherItems = append(prod.BoughtTogetherItems, model.BoughtTogetherItem{
+			ProductID: productId, TotalScore: 10 - 2*i, ScoreByRegion: []model.RegionScore{},
+		})
+	}
+	return prod
+}
+
+//the items of prod as A:10 B:8
+func formatItems(prod model.Product) string {
+	var items []string
+	for _, item := range prod.BoughtTogetherItems {
+		items = append(items, fmt.Sprintf("%s:%d", item.ProductID, item.TotalScore))
+	}
+	return strings.Join(items, " ")
+}
+
+//the decisions of the debug mode as B=exclude:discontinued/removed
+func formatDecisions(prod model.Product) string {
+	var decisions []string
+	for _, decision := range prod.Debug {
+		decisions = append(decisions, decision.ProductID+"="+decision.Rule+"/"+decision.Action)
+	}
+	return strings.Join(decisions, " ")
+}
+
+func parseTestRules(t *testing.T, config string) *RuleSet {
+	set, err := Parse([]byte(config))
+	if err != nil {
+		t.Fatalf("%s: %s", config, err.Error())
+	}
+	return set
+}
+
+func TestApply(t *testing.T) {
+	for _, test := range []struct {
+		name      string
+		config    string
+		items     string
+		decisions string
+	}{
+		{"none", `{}`, "A:10 B:8 C:6 D:4", ""},
+		//the first reason in alphabetical order is reported
+		{"exclude", `{"exclude": {"outOfStock": ["B"], "discontinued": ["B", "D"]}}`,
+			"A:10 C:6", "B=exclude:discontinued/removed D=exclude:discontinued/removed"},
+		{"boost", `{"boosts": [{"name": "fall", "productIds": ["C"], "factor": 2}]}`,
+			"C:12 A:10 B:8 D:4", "C=boost:fall/boosted"},
+		//the items tied after a boost keep their order
+		{"boost tie", `{"boosts": [{"productIds": ["B"], "factor": 1.25}]}`,
+			"A:10 B:10 C:6 D:4", "B=boost:1/boosted"},
+		{"cap", `{"attributes": {"A": {"brand": "X"}, "B": {"brand": "X"}, "C": {"brand": "X"}, "D": {"brand": "Y"}},
+			"caps": [{"attribute": "brand", "max": 2}]}`,
+			"A:10 B:8 D:4", "C=cap:brand=X/removed"},
+		//the cap counts the items ranked again by the boost
+		{"cap after boost", `{"attributes": {"A": {"brand": "X"}, "B": {"brand": "X"}, "C": {"brand": "X"}},
+			"caps": [{"attribute": "brand", "max": 2}], "boosts": [{"name": "fall", "productIds": ["C"], "factor": 2}]}`,
+			"C:12 A:10 D:4", "C=boost:fall/boosted B=cap:brand=X/removed"},
+		{"pin moved", `{"pins": [{"name": "launch", "productId": "D", "position": 1}]}`,
+			"D:4 A:10 B:8 C:6", "D=pin:launch/pinned"},
+		{"pin added", `{"pins": [{"name": "launch", "productId": "E", "position": 2}]}`,
+			"A:10 E:0 B:8 C:6 D:4", "E=pin:launch/pinned"},
+		{"pin past the end", `{"pins": [{"name": "launch", "productId": "E", "position": 9}]}`,
+			"A:10 B:8 C:6 D:4 E:0", "E=pin:launch/pinned"},
+		//the pins are placed in the order of their positions
+		{"pins", `{"pins": [{"name": "second", "productId": "F", "position": 2}, {"name": "first", "productId": "E", "position": 1}]}`,
+			"E:0 F:0 A:10 B:8 C:6 D:4", "E=pin:first/pinned F=pin:second/pinned"},
+		{"pin of other products", `{"pins": [{"productId": "E", "position": 1, "products": ["Q"]}]}`,
+			"A:10 B:8 C:6 D:4", ""},
+		{"pin of the product", `{"pins": [{"productId": "E", "position": 1, "products": ["Q", "P"]}]}`,
+			"E:0 A:10 B:8 C:6 D:4", "E=pin:1/pinned"},
+		{"pin to itself", `{"pins": [{"productId": "P", "position": 1}]}`,
+			"A:10 B:8 C:6 D:4", ""},
+		//a pinned product counts towards no cap, it is pinned even when the cap removed it
+		{"pin over a cap", `{"attributes": {"A": {"brand": "X"}, "B": {"brand": "X"}, "C": {"brand": "X"}},
+			"caps": [{"attribute": "brand", "max": 2}], "pins": [{"name": "launch", "productId": "C", "position": 1}]}`,
+			"C:0 A:10 B:8 D:4", "C=cap:brand=X/removed C=pin:launch/pinned"},
+		//an excluded product is never boosted nor pinned
+		{"exclude over boost and pin", `{"exclude": {"restricted": ["C", "E"]}, "boosts": [{"productIds": ["C"], "factor": 3}],
+			"pins": [{"productId": "C", "position": 1}, {"productId": "E", "position": 1}]}`,
+			"A:10 B:8 D:4", "C=exclude:restricted/removed"},
+	} {
+		prod := parseTestRules(t, test.config).Apply(testProduct(), true)
+		if got := formatItems(prod); got != test.items {
+			t.Errorf("%s: the items are %s, want %s", test.name, got, test.items)
+		}
+		if got := formatDecisions(prod); got != test.decisions {
+			t.Errorf("%s: the decisions are %s, want %s", test.name, got, test.decisions)
+		}
+	}
+}
+
+func TestApplyNoDebug(t *testing.T) {
+	set := parseTestRules(t, `{"exclude": {"outOfStock": ["B"]}}`)
+	if prod := set.Apply(testProduct(), false); prod.Debug != nil || formatItems(prod) != "A:10 C:6 D:4" {
+		t.Errorf("the product is %+v", prod)
+	}
+	var none *RuleSet
+	if prod := none.Apply(testProduct(), true); formatItems(prod) != "A:10 B:8 C:6 D:4" || prod.Debug != nil {
+		t.Errorf("nil rules changed the product to %+v", prod)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for config, want := range map[string]error{
+		`{"caps": [{"attribute": "brand", "max": 0}]}`:     ErrInvalidCap,
+		`{"caps": [{"max": 2}]}`:                           ErrInvalidCap,
+		`{"boosts": [{"productIds": ["A"], "factor": 0}]}`: ErrInvalidBoost,
+		`{"boosts": [{"factor": 2}]}`:                      ErrInvalidBoost,
+		`{"pins": [{"productId": "A", "position": 0}]}`:    ErrInvalidPin,
+		`{"pins": [{"position": 1}]}`:                      ErrInvalidPin,
+	} {
+		if _, err := Parse([]byte(config)); err != want {
+			t.Errorf("%s: %v, want %v", config, err, want)
+		}
+	}
+	if _, err := Parse([]byte(`{"caps": `)); err == nil {
+		t.Error("parsed a truncated config")
+	}
+}
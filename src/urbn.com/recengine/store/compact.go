@@ -0,0 +1,226 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"urbn.com/recengine/loader"
+	"urbn.com/recengine/model"
+)
+
+//CompactProducts keeps the same data as RelatedProducts but dictionary encoded: every product id and
+//region string is stored once and referenced by its integer id, and the bought together items and
+//region scores of all products are packed into two flat arrays. The color and sku of variants are
+//dictionary encoded as well. Products are converted back to the json shape only when they are served.
+type CompactProducts struct {
+	Version      string
+	Report       *loader.LoadReport
+	ids          []string
+	idIndex      map[string]uint32
+	regions      []string
+	regionIndex  map[string]uint16
+	variants     []variant
+	variantIndex map[variant]uint32
+	spans        []itemSpan
+	items        []compactItem
+	regionScores []compactRegionScore
+}
+
+//why a product can not be packed into the compact form
+var (
+	ErrTooManyRegions  = errors.New("too many distinct regions for the compact representation")
+	ErrScoreOutOfRange = errors.New("score out of the range of the compact representation")
+)
+
+//the items of a product, by dictionary id. count is 0 for ids which only appear as recommended items
+type itemSpan struct {
+	start   uint32
+	count   uint32
+	variant uint32
+}
+
+type compactItem struct {
+	product     uint32
+	variant     uint32
+	totalScore  int32
+	regionStart uint32
+	regionCount uint32
+}
+
+//the color and sku of a variant, variant 0 is the product level one with neither
+type variant struct {
+	color string
+	skuId string
+}
+
+type compactRegionScore struct {
+	region uint16
+	score  int32
+}
+
+func NewCompactProducts() *CompactProducts {
+	return &CompactProducts{
+		idIndex:      make(map[string]uint32),
+		regionIndex:  make(map[string]uint16),
+		variants:     []variant{{}},
+		variantIndex: map[variant]uint32{{}: 0},
+	}
+}
+
+//get the compact form of the products found at dataLocation, failing on the first product which does not fit
+func GetCompactProducts(dataLocation string) (*CompactProducts, error) {
+	compact := NewCompactProducts()
+	var addErr error
+	report, err := loader.LoadProducts(dataLocation, func(prod model.Product) {
+		if addErr == nil {
+			addErr = compact.Add(prod)
+		}
+	})
+	if err != nil {
+		return nil, err
+	} else if addErr != nil {
+		return nil, addErr
+	}
+	compact.Report = report
+	compact.Version = compact.Report.Version
+	compact.Trim()
+	return compact, nil
+}
+
+func (compact *CompactProducts) internId(productId string) uint32 {
+	if id, ok := compact.idIndex[productId]; ok {
+		return id
+	}
+	id := uint32(len(compact.ids))
+	compact.ids = append(compact.ids, productId)
+	compact.idIndex[productId] = id
+	compact.spans = append(compact.spans, itemSpan{})
+	return id
+}
+
+func (compact *CompactProducts) internRegion(region string) uint16 {
+	if id, ok := compact.regionIndex[region]; ok {
+		return id
+	}
+	id := uint16(len(compact.regions))
+	compact.regions = append(compact.regions, region)
+	compact.regionIndex[region] = id
+	return id
+}
+
+func (compact *CompactProducts) internVariant(color string, skuId string) uint32 {
+	key := variant{color: color, skuId: skuId}
+	if id, ok := compact.variantIndex[key]; ok {
+		return id
+	}
+	id := uint32(len(compact.variants))
+	compact.variants = append(compact.variants, key)
+	compact.variantIndex[key] = id
+	return id
+}
+
+//add a product, replacing any previous one with the same key the way the map does. a product with a score
+//out of the int32 range, or with a region past the 65536 distinct ones, is not added
+func (compact *CompactProducts) Add(prod model.Product) error {
+	if err := compact.fits(prod); err != nil {
+		return fmt.Errorf("product %s: %w", prod.Key(), err)
+	}
+	id := compact.internId(prod.Key())
+	span := itemSpan{
+		start:   uint32(len(compact.items)),
+		count:   uint32(len(prod.BoughtTogetherItems)),
+		variant: compact.internVariant(prod.Color, prod.SkuID),
+	}
+	for _, item := range prod.BoughtTogetherItems {
+		packed := compactItem{
+			product:     compact.internId(item.ProductID),
+			variant:     compact.internVariant(item.Color, item.SkuID),
+			totalScore:  int32(item.TotalScore),
+			regionStart: uint32(len(compact.regionScores)),
+			regionCount: uint32(len(item.ScoreByRegion)),
+		}
+		for _, score := range item.ScoreByRegion {
+			compact.regionScores = append(compact.regionScores, compactRegionScore{
+				region: compact.internRegion(score.Region),
+				score:  int32(score.Score),
+			})
+		}
+		compact.items = append(compact.items, packed)
+	}
+	compact.spans[id] = span
+	return nil
+}
+
+//check the scores and the regions of prod fit the compact form before anything of it is interned
+func (compact *CompactProducts) fits(prod model.Product) error {
+	newRegions := make(map[string]bool)
+	for _, item := range prod.BoughtTogetherItems {
+		if item.TotalScore < math.MinInt32 || item.TotalScore > math.MaxInt32 {
+			return ErrScoreOutOfRange
+		}
+		for _, score := range item.ScoreByRegion {
+			if score.Score < math.MinInt32 || score.Score > math.MaxInt32 {
+				return ErrScoreOutOfRange
+			}
+			if _, ok := compact.regionIndex[score.Region]; !ok {
+				newRegions[score.Region] = true
+			}
+		}
+	}
+	if len(compact.regions)+len(newRegions) > math.MaxUint16+1 {
+		return ErrTooManyRegions
+	}
+	return nil
+}
+
+//drop the spare capacity left over by append once loading is done
+func (compact *CompactProducts) Trim() {
+	compact.ids = append([]string(nil), compact.ids...)
+	compact.spans = append([]itemSpan(nil), compact.spans...)
+	compact.items = append([]compactItem(nil), compact.items...)
+	compact.regionScores = append([]compactRegionScore(nil), compact.regionScores...)
+	compact.variants = append([]variant(nil), compact.variants...)
+}
+
+func (compact *CompactProducts) Len() int {
+	count := 0
+	for _, span := range compact.spans {
+		if span.count > 0 {
+			count++
+		}
+	}
+	return count
+}
+
+//decode the product, or the variant, stored under key back into its json shape
+func (compact *CompactProducts) Get(key string) (model.Product, bool) {
+	id, ok := compact.idIndex[key]
+	if !ok || compact.spans[id].count == 0 {
+		return model.Product{}, false
+	}
+	span := compact.spans[id]
+	prod := model.Product{
+		ProductID:           key,
+		Color:               compact.variants[span.variant].color,
+		SkuID:               compact.variants[span.variant].skuId,
+		BoughtTogetherItems: make([]model.BoughtTogetherItem, span.count),
+	}
+	//the key of a variant is its product id and its color or sku
+	if variant := prod.Variant(); variant != "" {
+		prod.ProductID = key[:len(key)-len(model.VARIANT_SEPARATOR)-len(variant)]
+	}
+	for i, packed := range compact.items[span.start : span.start+span.count] {
+		scores := make([]model.RegionScore, packed.regionCount)
+		for j, score := range compact.regionScores[packed.regionStart : packed.regionStart+packed.regionCount] {
+			scores[j] = model.RegionScore{Region: compact.regions[score.region], Score: int(score.score)}
+		}
+		prod.BoughtTogetherItems[i] = model.BoughtTogetherItem{
+			ProductID:     compact.ids[packed.product],
+			Color:         compact.variants[packed.variant].color,
+			SkuID:         compact.variants[packed.variant].skuId,
+			TotalScore:    int(packed.totalScore),
+			ScoreByRegion: scores,
+		}
+	}
+	return prod, true
+}
@@ -0,0 +1,192 @@
+package store
+
+import (
+	"errors"
+	"math"
+	"reflect"
+	"runtime"
+	"strconv"
+	"testing"
+	"urbn.com/recengine/model"
+)
+
+//the size of the benchmark dataset, every product with a full list of items scored in a few states
+const (
+	benchProducts = 10000
+	benchItems    = 20
+	benchRegions  = 4
+)
+
+var benchStates = []string{"CA", "NY", "PA", "TX", "WA", "NJ", "FL", "IL"}
+
+func benchProduct(i int) model.Product {
+	prod := model.Product{ProductID: strconv.Itoa(30000000 + i)}
+	for j := 0; j < benchItems; j++ {
+		item := model.BoughtTogetherItem{ProductID: strconv.Itoa(30000000 + (i*7919+j*104729)%benchProducts)}
+		for k := 0; k < benchRegions; k++ {
+			score := benchItems - j + k
+			item.ScoreByRegion = append(item.ScoreByRegion, model.RegionScore{Region: benchStates[(i+j+k)%len(benchStates)], Score: score})
+			item.TotalScore += score
+		}
+		prod.BoughtTogetherItems = append(prod.BoughtTogetherItems, item)
+	}
+	return prod
+}
+
+func benchSources(b *testing.B) (*RelatedProducts, *CompactProducts) {
+	relates := NewRelatedProducts()
+	compact := NewCompactProducts()
+	for i := 0; i < benchProducts; i++ {
+		prod := benchProduct(i)
+		relates.Add(prod)
+		if err := compact.Add(prod); err != nil {
+			b.Fatal(err)
+		}
+	}
+	compact.Trim()
+	return relates, compact
+}
+
+func TestCompactRoundTrip(t *testing.T) {
+	compact := NewCompactProducts()
+	products := []model.Product{
+		benchProduct(1),
+		{ProductID: "A", Color: "blue", BoughtTogetherItems: []model.BoughtTogetherItem{
+			{ProductID: "B", Color: "red", TotalScore: math.MaxInt32, ScoreByRegion: []model.RegionScore{{Region: "PA", Score: math.MinInt32}}},
+		}},
+	}
+	for _, prod := range products {
+		if err := compact.Add(prod); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, prod := range products {
+		got, ok := compact.Get(prod.Key())
+		if !ok || !reflect.DeepEqual(got, prod) {
+			t.Errorf("Get(%s) = %+v, want %+v", prod.Key(), got, prod)
+		}
+	}
+}
+
+func TestCompactScoreOutOfRange(t *testing.T) {
+	compact := NewCompactProducts()
+	for _, prod := range []model.Product{
+		{ProductID: "A", BoughtTogetherItems: []model.BoughtTogetherItem{{ProductID: "B", TotalScore: math.MaxInt32 + 1}}},
+		{ProductID: "A", BoughtTogetherItems: []model.BoughtTogetherItem{{ProductID: "B", ScoreByRegion: []model.RegionScore{{Region: "PA", Score: math.MinInt32 - 1}}}}},
+	} {
+		if err := compact.Add(prod); !errors.Is(err, ErrScoreOutOfRange) {
+			t.Errorf("Add(%+v) = %v, want %v", prod, err, ErrScoreOutOfRange)
+		}
+	}
+	if _, ok := compact.Get("A"); ok || len(compact.ids) != 0 || len(compact.regions) != 0 {
+		t.Errorf("a rejected product was partly added")
+	}
+}
+
+func TestCompactTooManyRegions(t *testing.T) {
+	compact := NewCompactProducts()
+	item := model.BoughtTogetherItem{ProductID: "B"}
+	for i := 0; i <= math.MaxUint16; i++ {
+		item.ScoreByRegion = append(item.ScoreByRegion, model.RegionScore{Region: strconv.Itoa(i), Score: 1})
+	}
+	if err := compact.Add(model.Product{ProductID: "A", BoughtTogetherItems: []model.BoughtTogetherItem{item}}); err != nil {
+		t.Fatal(err)
+	}
+	prod := model.Product{ProductID: "C", BoughtTogetherItems: []model.BoughtTogetherItem{
+		{ProductID: "B", ScoreByRegion: []model.RegionScore{{Region: "PA", Score: 1}}},
+	}}
+	if err := compact.Add(prod); !errors.Is(err, ErrTooManyRegions) {
+		t.Errorf("Add = %v, want %v", err, ErrTooManyRegions)
+	}
+}
+
+//the products of the benchmark dataset, decoded ahead so loading measures only the product source
+func benchDataset() []model.Product {
+	products := make([]model.Product, benchProducts)
+	for i := range products {
+		products[i] = benchProduct(i)
+	}
+	return products
+}
+
+//the heap kept by what build returns, once the garbage is collected
+func retainedBytes(build func() interface{}) float64 {
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	kept := build()
+	runtime.GC()
+	runtime.ReadMemStats(&after)
+	runtime.KeepAlive(kept)
+	return float64(int64(after.HeapAlloc) - int64(before.HeapAlloc))
+}
+
+func BenchmarkLoadMap(b *testing.B) {
+	products := benchDataset()
+	load := func() interface{} {
+		relates := NewRelatedProducts()
+		for _, prod := range products {
+			relates.Add(prod)
+		}
+		return relates
+	}
+	//the map shares the decoded products, drop them to measure what it keeps
+	retained := retainedBytes(func() interface{} {
+		relates := NewRelatedProducts()
+		for i := 0; i < benchProducts; i++ {
+			relates.Add(benchProduct(i))
+		}
+		return relates
+	})
+	b.ReportAllocs()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		load()
+	}
+	b.ReportMetric(retained, "retained-bytes")
+}
+
+func BenchmarkLoadCompact(b *testing.B) {
+	products := benchDataset()
+	load := func() interface{} {
+		compact := NewCompactProducts()
+		for _, prod := range products {
+			if err := compact.Add(prod); err != nil {
+				b.Fatal(err)
+			}
+		}
+		compact.Trim()
+		return compact
+	}
+	retained := retainedBytes(load)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		load()
+	}
+	b.ReportMetric(retained, "retained-bytes")
+}
+
+func BenchmarkGetMap(b *testing.B) {
+	relates, _ := benchSources(b)
+	benchmarkGet(b, relates)
+}
+
+func BenchmarkGetCompact(b *testing.B) {
+	_, compact := benchSources(b)
+	benchmarkGet(b, compact)
+}
+
+func benchmarkGet(b *testing.B, source ProductSource) {
+	keys := make([]string, benchProducts)
+	for i := range keys {
+		keys[i] = strconv.Itoa(30000000 + i)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		if _, ok := source.Get(keys[n%len(keys)]); !ok {
+			b.Fatalf("%s not found", keys[n%len(keys)])
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package store
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/golang/glog"
+	"urbn.com/recengine/loader"
+	"urbn.com/recengine/model"
+)
+
+//DynamoDbStore looks every product up in the ProductRecommendation table
+type DynamoDbStore struct {
+	svc *dynamodb.DynamoDB
+}
+
+func NewDynamoDbStore(svc *dynamodb.DynamoDB) *DynamoDbStore {
+	return &DynamoDbStore{svc: svc}
+}
+
+func (dstore *DynamoDbStore) Get(productId string) (model.Product, bool) {
+	productStr := GetItemFromDynamoDb(dstore.svc, productId)
+	if productStr == "" {
+		return model.Product{}, false
+	}
+	rp, error := loader.DecodeProduct([]byte(productStr))
+	if error != nil {
+		glog.Errorf("failed to unmarshal json %s %s\n", productStr, error.Error())
+		return rp, false
+	}
+	return rp, true
+}
+
+func GetItemFromDynamoDb(svc *dynamodb.DynamoDB, productId string) string {
+	params := &dynamodb.GetItemInput{
+		Key: map[string]*dynamodb.AttributeValue{ // Required
+			"productId": { // Required
+				S: aws.String(productId),
+			},
+			// More values...
+		},
+		TableName: aws.String("ProductRecommendation"), // Required
+		AttributesToGet: []*string{
+			aws.String("boughtTogether"), // Required
+			// More values...
+		},
+		ConsistentRead: aws.Bool(true),
+		/*
+			ExpressionAttributeNames: map[string]*string{
+				"Key": aws.String("AttributeName"), // Required
+				// More values...
+			},
+			ProjectionExpression:   aws.String("ProjectionExpression"),
+			ReturnConsumedCapacity: aws.String("ReturnConsumedCapacity"),
+		*/
+	}
+	resp, err := svc.GetItem(params)
+
+	if err != nil {
+		// Print the error, cast err to awserr.Error to get the Code and
+		// Message from an error.
+		glog.Errorln(err.Error())
+		return ""
+	}
+
+	// Pretty-print the response data.
+	if attribute, ok := resp.Item["boughtTogether"]; !ok || attribute.S == nil {
+		return ""
+	}
+	return *resp.Item["boughtTogether"].S
+}
@@ -0,0 +1,237 @@
+package store
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/golang/glog"
+	"os"
+	"sort"
+	"strconv"
+	"urbn.com/recengine/loader"
+	"urbn.com/recengine/model"
+)
+
+//the on-disk index is a read-only file compiled from the part files. all integers are little endian.
+//
+//	magic "RECIDX02"
+//	records, each one: uint16 key length, key, uint32 value length, value (the product json)
+//	offset table: one uint64 record offset per product, ordered by product id
+//	footer: uint64 offset of the offset table, uint32 number of products, uint64 dataset version
+const (
+	INDEX_MAGIC       = "RECIDX02"
+	INDEX_FOOTER_SIZE = 20
+)
+
+//ProductIndex serves the products of an index file. the header and the offset table are checked when it is
+//opened, every record when it is read, so opening it does not read the whole file
+type ProductIndex struct {
+	data       []byte
+	offsets    []byte
+	recordsEnd uint64
+	count      int
+	version    string
+	release    func() error
+}
+
+type indexEntry struct {
+	key    string
+	offset uint64
+}
+
+//compile every product found at dataLocation into the index file, returning the report of the load
+func BuildProductIndex(dataLocation string, fileName string) (*loader.LoadReport, error) {
+	tmpName := fileName + ".tmp"
+	f, err := os.Create(tmpName)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmpName)
+	w := bufio.NewWriter(f)
+	if _, err := w.WriteString(INDEX_MAGIC); err != nil {
+		f.Close()
+		return nil, err
+	}
+	offset := uint64(len(INDEX_MAGIC))
+	var entries []indexEntry
+	var writeErr error
+	report, err := loader.LoadProducts(dataLocation, func(prod model.Product) {
+		if writeErr != nil {
+			return
+		}
+		value, err := json.Marshal(prod)
+		if err != nil {
+			writeErr = err
+			return
+		}
+		key := prod.Key()
+		if len(key) > 0xffff {
+			glog.Errorf("product id too long for the index, skipping %.32s...", key)
+			return
+		}
+		if uint64(len(value)) > 0xffffffff {
+			glog.Errorf("product json too long for the index, skipping %s", key)
+			return
+		}
+		entries = append(entries, indexEntry{key: key, offset: offset})
+		record := make([]byte, 0, 2+len(key)+4+len(value))
+		record = binary.LittleEndian.AppendUint16(record, uint16(len(key)))
+		record = append(record, key...)
+		record = binary.LittleEndian.AppendUint32(record, uint32(len(value)))
+		record = append(record, value...)
+		if _, err := w.Write(record); err != nil {
+			writeErr = err
+			return
+		}
+		offset += uint64(len(record))
+	})
+	if err == nil {
+		err = writeErr
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	//a product seen more than once keeps its last record, same as the in memory map
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].key < entries[j].key })
+	unique := entries[:0]
+	for _, entry := range entries {
+		if len(unique) > 0 && unique[len(unique)-1].key == entry.key {
+			unique[len(unique)-1] = entry
+		} else {
+			unique = append(unique, entry)
+		}
+	}
+
+	table := make([]byte, 0, len(unique)*8+INDEX_FOOTER_SIZE)
+	for _, entry := range unique {
+		table = binary.LittleEndian.AppendUint64(table, entry.offset)
+	}
+	table = binary.LittleEndian.AppendUint64(table, offset)
+	table = binary.LittleEndian.AppendUint32(table, uint32(len(unique)))
+	versionHash, err := strconv.ParseUint(report.Version, 16, 64)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("invalid dataset version %s %s", report.Version, err.Error())
+	}
+	table = binary.LittleEndian.AppendUint64(table, versionHash)
+	if _, err := w.Write(table); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if err := f.Close(); err != nil {
+		return nil, err
+	}
+	glog.Infof("compiled %d products into %s", len(unique), fileName)
+	return report, os.Rename(tmpName, fileName)
+}
+
+//open the index file, memory mapping it where the platform allows
+func OpenProductIndex(fileName string) (*ProductIndex, error) {
+	data, release, err := mapFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < len(INDEX_MAGIC)+INDEX_FOOTER_SIZE || string(data[:len(INDEX_MAGIC)]) != INDEX_MAGIC {
+		release()
+		return nil, errors.New("not a product index file: " + fileName)
+	}
+	footer := data[len(data)-INDEX_FOOTER_SIZE:]
+	tableOffset := binary.LittleEndian.Uint64(footer[:8])
+	count := int(binary.LittleEndian.Uint32(footer[8:12]))
+	tableEnd := uint64(len(data) - INDEX_FOOTER_SIZE)
+	if tableOffset < uint64(len(INDEX_MAGIC)) || tableOffset > tableEnd || tableEnd-tableOffset != uint64(count)*8 {
+		release()
+		return nil, errors.New("corrupted offset table in product index " + fileName)
+	}
+	return &ProductIndex{
+		data:       data,
+		offsets:    data[tableOffset:tableEnd],
+		recordsEnd: tableOffset,
+		count:      count,
+		version:    fmt.Sprintf("%016x", binary.LittleEndian.Uint64(footer[12:])),
+		release:    release,
+	}, nil
+}
+
+func (idx *ProductIndex) Close() error {
+	return idx.release()
+}
+
+func (idx *ProductIndex) Len() int {
+	return idx.count
+}
+
+//the version of the dataset the index was compiled from
+func (idx *ProductIndex) Version() string {
+	return idx.version
+}
+
+//the key and value of the i-th record in product id order, an error when the record does not lie within the
+//records, after the magic and before the offset table
+func (idx *ProductIndex) record(i int) ([]byte, []byte, error) {
+	offset := binary.LittleEndian.Uint64(idx.offsets[i*8:])
+	if offset < uint64(len(INDEX_MAGIC)) || offset > idx.recordsEnd || idx.recordsEnd-offset < 2 {
+		return nil, nil, fmt.Errorf("record %d at offset %d out of bounds", i, offset)
+	}
+	keyLen := uint64(binary.LittleEndian.Uint16(idx.data[offset:]))
+	if idx.recordsEnd-offset-2 < keyLen+4 {
+		return nil, nil, fmt.Errorf("key of record %d out of bounds", i)
+	}
+	valueStart := offset + 2 + keyLen + 4
+	valueLen := uint64(binary.LittleEndian.Uint32(idx.data[offset+2+keyLen:]))
+	if idx.recordsEnd-valueStart < valueLen {
+		return nil, nil, fmt.Errorf("value of record %d out of bounds", i)
+	}
+	return idx.data[offset+2 : offset+2+keyLen], idx.data[valueStart : valueStart+valueLen], nil
+}
+
+//look up the product json with a binary search over the offset table, failing on the first record out of
+//bounds it reads
+func (idx *ProductIndex) lookup(productId string) ([]byte, bool, error) {
+	target := []byte(productId)
+	var recordErr error
+	i := sort.Search(idx.count, func(i int) bool {
+		key, _, err := idx.record(i)
+		if err != nil && recordErr == nil {
+			recordErr = err
+		}
+		return bytes.Compare(key, target) >= 0
+	})
+	if recordErr != nil {
+		return nil, false, recordErr
+	}
+	if i < idx.count {
+		key, value, err := idx.record(i)
+		if err != nil {
+			return nil, false, err
+		} else if bytes.Equal(key, target) {
+			return value, true, nil
+		}
+	}
+	return nil, false, nil
+}
+
+func (idx *ProductIndex) Get(productId string) (model.Product, bool) {
+	var prod model.Product
+	value, ok, err := idx.lookup(productId)
+	if err != nil {
+		glog.Errorf("corrupted product index, failed to look up %s %s\n", productId, err.Error())
+		return prod, false
+	} else if !ok {
+		return prod, false
+	}
+	if err := json.Unmarshal(value, &prod); err != nil {
+		glog.Errorf("failed to unmarshal indexed product %s %s\n", productId, err.Error())
+		return prod, false
+	}
+	return prod, true
+}
@@ -0,0 +1,140 @@
+package store
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const testModel = `(B,{"productId":"B","boughtTogetherItems":[{"productId":"A","totalScore":2,"scoreByRegion":[]}]})
+(A,{"productId":"A","boughtTogetherItems":[{"productId":"B","totalScore":2,"scoreByRegion":[]},{"productId":"C","totalScore":1,"scoreByRegion":[]}]})
+(C,{"productId":"C","boughtTogetherItems":[{"productId":"A","totalScore":1,"scoreByRegion":[]}]})
+(A,{"productId":"A","boughtTogetherItems":[{"productId":"C","totalScore":3,"scoreByRegion":[]}]})
+`
+
+//build an index of the test model in a temp dir, returning the index file name
+func buildTestIndex(t *testing.T) string {
+	dir := t.TempDir()
+	modelDir := filepath.Join(dir, "model")
+	if err := os.Mkdir(modelDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(modelDir, "part-00000"), []byte(testModel), 0644); err != nil {
+		t.Fatal(err)
+	}
+	fileName := filepath.Join(dir, "products.idx")
+	report, err := BuildProductIndex(modelDir, fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Loaded != 4 {
+		t.Fatalf("loaded %d records, want 4", report.Loaded)
+	}
+	return fileName
+}
+
+func TestProductIndexRoundTrip(t *testing.T) {
+	idx, err := OpenProductIndex(buildTestIndex(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer idx.Close()
+	if idx.Len() != 3 {
+		t.Errorf("indexed %d products, want 3", idx.Len())
+	}
+	prod, ok := idx.Get("A")
+	if !ok {
+		t.Fatal("A not found")
+	}
+	//the last record of a product wins
+	if len(prod.BoughtTogetherItems) != 1 || prod.BoughtTogetherItems[0].ProductID != "C" || prod.BoughtTogetherItems[0].TotalScore != 3 {
+		t.Errorf("A = %+v, want its last record", prod)
+	}
+	for _, productId := range []string{"B", "C"} {
+		if prod, ok := idx.Get(productId); !ok || prod.ProductID != productId {
+			t.Errorf("Get(%s) = %+v, %v", productId, prod, ok)
+		}
+	}
+	for _, productId := range []string{"", "0", "AB", "D"} {
+		if _, ok := idx.Get(productId); ok {
+			t.Errorf("Get(%q) found a product", productId)
+		}
+	}
+}
+
+func TestProductIndexCorrupt(t *testing.T) {
+	fileName := buildTestIndex(t)
+	valid, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tableOffset := binary.LittleEndian.Uint64(valid[len(valid)-INDEX_FOOTER_SIZE:])
+	//the header and the offset table are checked when the index is opened
+	unopened := map[string]func([]byte) []byte{
+		"truncated": func(data []byte) []byte {
+			return data[:len(data)/2]
+		},
+		"bad magic": func(data []byte) []byte {
+			data[0] = 'X'
+			return data
+		},
+		"offset table in the magic": func(data []byte) []byte {
+			binary.LittleEndian.PutUint64(data[len(data)-INDEX_FOOTER_SIZE:], 1)
+			return data
+		},
+	}
+	//a record when it is read, the first one of A
+	unread := map[string]func([]byte) []byte{
+		"offset past the records": func(data []byte) []byte {
+			binary.LittleEndian.PutUint64(data[tableOffset:], tableOffset+1)
+			return data
+		},
+		"offset in the magic": func(data []byte) []byte {
+			binary.LittleEndian.PutUint64(data[tableOffset:], 1)
+			return data
+		},
+		"key length past the records": func(data []byte) []byte {
+			offset := binary.LittleEndian.Uint64(data[tableOffset:])
+			binary.LittleEndian.PutUint16(data[offset:], 0xffff)
+			return data
+		},
+		"value length past the records": func(data []byte) []byte {
+			offset := binary.LittleEndian.Uint64(data[tableOffset:])
+			keyLen := uint64(binary.LittleEndian.Uint16(data[offset:]))
+			binary.LittleEndian.PutUint32(data[offset+2+keyLen:], 0xffffffff)
+			return data
+		},
+	}
+	open := func(corruption func([]byte) []byte) (*ProductIndex, error) {
+		data := corruption(append([]byte(nil), valid...))
+		if err := ioutil.WriteFile(fileName, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return OpenProductIndex(fileName)
+	}
+	for name, corruption := range unopened {
+		if idx, err := open(corruption); err == nil {
+			idx.Close()
+			t.Errorf("%s: opened a corrupt index", name)
+		}
+	}
+	for name, corruption := range unread {
+		idx, err := open(corruption)
+		if err != nil {
+			t.Errorf("%s: %s", name, err.Error())
+			continue
+		}
+		if _, _, err := idx.lookup("A"); err == nil {
+			t.Errorf("%s: read a corrupt record", name)
+		}
+		if _, ok := idx.Get("A"); ok {
+			t.Errorf("%s: got a corrupt record", name)
+		}
+		if prod, ok := idx.Get("C"); !ok || prod.ProductID != "C" {
+			t.Errorf("%s: Get(C) = %+v, %v", name, prod, ok)
+		}
+		idx.Close()
+	}
+}
@@ -0,0 +1,17 @@
+//go:build !darwin && !linux
+// +build !darwin,!linux
+
+package store
+
+import (
+	"io/ioutil"
+)
+
+//no mmap on this platform, read the whole file instead
+func mapFile(fileName string) ([]byte, func() error, error) {
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, nil, err
+	}
+	return data, func() error { return nil }, nil
+}
@@ -0,0 +1,35 @@
+//go:build darwin || linux
+// +build darwin linux
+
+package store
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+//map the whole file read-only into memory, the returned func unmaps it
+func mapFile(fileName string) ([]byte, func() error, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, nil, err
+	}
+	size := info.Size()
+	if size == 0 {
+		return nil, nil, errors.New("empty file " + fileName)
+	}
+	if int64(int(size)) != size {
+		return nil, nil, errors.New("file too large to map " + fileName)
+	}
+	data, err := syscall.Mmap(int(f.Fd()), 0, int(size), syscall.PROT_READ, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, nil, err
+	}
+	return data, func() error { return syscall.Munmap(data) }, nil
+}
@@ -0,0 +1,130 @@
+package store
+
+import (
+	"fmt"
+	"hash/fnv"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+	"urbn.com/recengine/model"
+)
+
+//how the datasets of the relations are kept, the way the products of the model are served
+const (
+	//in the map of RelatedProducts
+	RELATIONS_MAP = "map"
+	//in the compact form of CompactProducts
+	RELATIONS_COMPACT = "compact"
+	//in on-disk index files, every location being an index file compiled with -buildIndex
+	RELATIONS_INDEX = "index"
+)
+
+//Relations holds the dataset of every relation type other than bought together, like the similar items,
+//by type. a dataset has the schema of the bought together model, the items of a product being its related items
+type Relations struct {
+	Sources map[string]ProductSource
+	//a hash of the versions of the datasets
+	Version string
+	//the time the latest part file of the datasets was modified
+	Modified time.Time
+}
+
+//load the dataset of every relation type of locations, type=location pairs separated by commas like
+//similar=s3://bucket/similar,viewedTogether=/data/viewed, into backend
+func GetRelations(locations string, backend string) (*Relations, error) {
+	if backend != RELATIONS_MAP && backend != RELATIONS_COMPACT && backend != RELATIONS_INDEX {
+		return nil, fmt.Errorf("unknown relations backend %q, expecting map, compact or index", backend)
+	}
+	relations := &Relations{Sources: make(map[string]ProductSource)}
+	versions := make(map[string]string)
+	for _, pair := range strings.Split(locations, ",") {
+		parts := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+		if len(parts) != 2 || parts[1] == "" {
+			return nil, fmt.Errorf("invalid relation %q, expecting type=location", pair)
+		} else if !knownRelation(parts[0]) {
+			return nil, fmt.Errorf("unknown relation type %q, expecting one of %s", parts[0], strings.Join(model.RelationTypes, ", "))
+		} else if _, ok := relations.Sources[parts[0]]; ok {
+			return nil, fmt.Errorf("relation type %s given twice", parts[0])
+		}
+		source, version, modified, err := loadRelation(parts[1], backend)
+		if err != nil {
+			relations.Close()
+			return nil, fmt.Errorf("relation %s: %s", parts[0], err.Error())
+		}
+		relations.Sources[parts[0]] = source
+		versions[parts[0]] = version
+		if modified.After(relations.Modified) {
+			relations.Modified = modified
+		}
+	}
+	types := make([]string, 0, len(versions))
+	for relationType := range versions {
+		types = append(types, relationType)
+	}
+	sort.Strings(types)
+	h := fnv.New64a()
+	for _, relationType := range types {
+		fmt.Fprintf(h, "%s %s\n", relationType, versions[relationType])
+	}
+	relations.Version = fmt.Sprintf("%016x", h.Sum64())
+	return relations, nil
+}
+
+//load the dataset of location into backend, with its version and the time it was last modified
+func loadRelation(location string, backend string) (ProductSource, string, time.Time, error) {
+	switch backend {
+	case RELATIONS_COMPACT:
+		compact, err := GetCompactProducts(location)
+		if err != nil {
+			return nil, "", time.Time{}, err
+		}
+		return compact, compact.Version, compact.Report.Modified, nil
+	case RELATIONS_INDEX:
+		info, err := os.Stat(location)
+		if err != nil {
+			return nil, "", time.Time{}, err
+		}
+		index, err := OpenProductIndex(location)
+		if err != nil {
+			return nil, "", time.Time{}, err
+		}
+		return index, index.Version(), info.ModTime(), nil
+	}
+	relates, err := GetRelatedProducts(location)
+	if err != nil {
+		return nil, "", time.Time{}, err
+	}
+	return relates, relates.Version, relates.Report.Modified, nil
+}
+
+func knownRelation(relationType string) bool {
+	for _, known := range model.RelationTypes {
+		if relationType == known {
+			return true
+		}
+	}
+	return false
+}
+
+//the dataset of relationType, none when relations is nil
+func (relations *Relations) Source(relationType string) (ProductSource, bool) {
+	if relations == nil {
+		return nil, false
+	}
+	source, ok := relations.Sources[relationType]
+	return source, ok
+}
+
+//close the datasets kept in index files, relations may be nil
+func (relations *Relations) Close() {
+	if relations == nil {
+		return
+	}
+	for _, source := range relations.Sources {
+		if closer, ok := source.(io.Closer); ok {
+			closer.Close()
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package store
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+//write the test model as the part file of a dataset directory, returning it
+func writeTestDataset(t *testing.T) string {
+	dataDir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dataDir, "part-00000"), []byte(testModel), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return dataDir
+}
+
+func TestGetRelations(t *testing.T) {
+	dataDir := writeTestDataset(t)
+	for backend, location := range map[string]string{
+		RELATIONS_MAP:     dataDir,
+		RELATIONS_COMPACT: dataDir,
+		RELATIONS_INDEX:   buildTestIndex(t),
+	} {
+		relations, err := GetRelations("similar="+location, backend)
+		if err != nil {
+			t.Fatalf("%s: %s", backend, err.Error())
+		}
+		source, ok := relations.Source("similar")
+		if !ok {
+			t.Fatalf("%s: no similar dataset", backend)
+		}
+		switch source.(type) {
+		case *RelatedProducts:
+			ok = backend == RELATIONS_MAP
+		case *CompactProducts:
+			ok = backend == RELATIONS_COMPACT
+		case *ProductIndex:
+			ok = backend == RELATIONS_INDEX
+		default:
+			ok = false
+		}
+		if !ok {
+			t.Errorf("%s: the dataset is kept in a %T", backend, source)
+		}
+		if prod, found := source.Get("A"); !found || len(prod.BoughtTogetherItems) != 1 || prod.BoughtTogetherItems[0].ProductID != "C" {
+			t.Errorf("%s: the similar items of A are %v", backend, prod.BoughtTogetherItems)
+		}
+		if relations.Version == "" || relations.Modified.IsZero() {
+			t.Errorf("%s: version %q modified %s", backend, relations.Version, relations.Modified)
+		}
+		relations.Close()
+	}
+}
+
+func TestGetRelationsErrors(t *testing.T) {
+	dataDir := writeTestDataset(t)
+	for _, test := range []struct {
+		locations string
+		backend   string
+		want      string
+	}{
+		{"similar=" + dataDir, "disk", "unknown relations backend"},
+		{"similar", RELATIONS_MAP, "invalid relation"},
+		{"related=" + dataDir, RELATIONS_MAP, "unknown relation type"},
+		{"similar=" + dataDir + ",similar=" + dataDir, RELATIONS_MAP, "given twice"},
+		{"similar=" + filepath.Join(dataDir, "missing.idx"), RELATIONS_INDEX, "relation similar"},
+		{"similar=" + dataDir, RELATIONS_INDEX, "relation similar"},
+	} {
+		_, err := GetRelations(test.locations, test.backend)
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%s in %s: %v, want %s", test.locations, test.backend, err, test.want)
+		}
+	}
+}
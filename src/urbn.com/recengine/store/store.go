@@ -0,0 +1,56 @@
+//Package store holds the backends the products are served from: the in-memory map, its compact form,
+//the on-disk index and dynamo db.
+package store
+
+import (
+	"github.com/aws/aws-sdk-go/service/s3"
+	"urbn.com/recengine/loader"
+	"urbn.com/recengine/model"
+)
+
+//a backend the products are served from
+type ProductSource interface {
+	Get(productId string) (model.Product, bool)
+}
+
+//RelatedProducts keeps every product in a map keyed by product id, or by variant key for a variant
+type RelatedProducts struct {
+	Relates map[string]model.Product
+	Version string
+	Report  *loader.LoadReport
+}
+
+func NewRelatedProducts() *RelatedProducts {
+	return &RelatedProducts{Relates: make(map[string]model.Product)}
+}
+
+//get the RelatedProducts found at dataLocation, a local directory or an s3://bucket/prefix location
+func GetRelatedProducts(dataLocation string) (*RelatedProducts, error) {
+	results := NewRelatedProducts()
+	report, err := loader.LoadProducts(dataLocation, results.Add)
+	if err != nil {
+		return nil, err
+	}
+	results.Report, results.Version = report, report.Version
+	return results, nil
+}
+
+func GetRelatedProductsFromS3(svc *s3.S3, dataDir string) (*RelatedProducts, error) {
+	results := NewRelatedProducts()
+	report, err := loader.LoadProductsFromS3(svc, dataDir, results.Add)
+	if err != nil {
+		return nil, err
+	}
+	results.Report, results.Version = report, report.Version
+	return results, nil
+}
+
+//add a product, replacing any previous one with the same key
+func (relates *RelatedProducts) Add(prod model.Product) {
+	relates.Relates[prod.Key()] = prod
+}
+
+func (relates *RelatedProducts) Get(productId string) (model.Product, bool) {
+	prod, ok := relates.Relates[productId]
+	return prod, ok
+}
@@ -1,317 +1,245 @@
 package main
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
 	"flag"
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
-	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/golang/glog"
-	"io"
+	"google.golang.org/grpc"
 	"io/ioutil"
-	"math"
 	"net/http"
-	"strings"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+	"urbn.com/recengine/api"
+	"urbn.com/recengine/loader"
+	"urbn.com/recengine/rules"
+	"urbn.com/recengine/store"
 )
 
-const (
-	HTTP_HEADER_CONTENT_TYPE = "Content-Type"
-	HTTP_HEADER_VALUE_JSON   = "application/json; charset=UTF-8"
-)
-
-type Product struct {
-	ProductID           string               `json:"productId"`
-	BoughtTogetherItems []BoughtTogetherItem `json:"boughtTogetherItems"`
-}
-
-type BoughtTogetherItem struct {
-	ProductID     string        `json:"productId"`
-	TotalScore    int           `json:"totalScore"`
-	ScoreByRegion []RegionScore `json:"scoreByRegion"`
-}
-
-type RegionScore struct {
-	Region string `json:"region"`
-	Score  int    `json:"score"`
-}
-
-type RelatedProducts struct {
-	Relates map[string]Product
-}
-type DynamoDbHandler struct {
-	svc *dynamodb.DynamoDB
-}
+//how long the requests in flight are given to finish on shutdown
+const SHUTDOWN_TIMEOUT = 10 * time.Second
 
-func (dhandler *DynamoDbHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	productId := GetProductId(r)
-	productStr := GetItemFromDynamoDb(dhandler.svc, productId)
-	var rp Product
-	error := json.Unmarshal([]byte(productStr), &rp)
-	if error != nil {
-		glog.Errorf("failed to unmarshal json %s %s\n", productStr, error.Error())
-	} else {
-		//recommendation:=convertToRecommendation(rp)
-		json.NewEncoder(w).Encode(rp)
-		glog.V(3).Infof("served %s %+v", r.URL.Path, rp)
-		glog.V(2).Infof("served %s", r.URL.Path)
-	}
-
-}
-
-func (relates *RelatedProducts) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	glog.V(2).Infof("serving %s", r.URL.Path)
-	productId := GetProductId(r)
-	var prod Product
-	if val, ok := relates.Relates[productId]; ok {
-		prod = val
-	} else {
-		//var recom [] RelatedProduct=[2]RelatedProduct{RelatedProduct{ProductID:"abcd",Score:25},RelatedProduct{ProductID:"xdgg",Score:2}}
-		prod = Product{
-			ProductID: productId,
-			//SortedRelates:[]RelatedProduct{},
-			BoughtTogetherItems: []BoughtTogetherItem{},
+func main() {
+	dataDir := flag.String("dataLocation", "", "")
+	useDynamoDb := flag.Bool("useDynamoDb", false, "dynamodb indicator")
+	indexFile := flag.String("indexFile", "", "serve from the on-disk index file compiled with -buildIndex")
+	buildIndex := flag.String("buildIndex", "", "compile dataLocation into this index file and exit")
+	compact := flag.Bool("compact", false, "keep the products in memory in the compact dictionary encoded form")
+	prerender := flag.Bool("prerender", false, "encode the default response of every product at load time")
+	prerenderGzip := flag.Bool("prerenderGzip", false, "keep the prerendered responses gzip compressed")
+	grpcAddress := flag.String("grpcAddress", "", "serve the grpc api on this address as well, for instance :9090")
+	cacheMaxAge := flag.Duration("cacheMaxAge", 0, "Cache-Control max-age of the responses, set it to the interval the dataset is reloaded at")
+	defaultSchema := flag.String("defaultSchema", api.SCHEMA_V2, "the schema, v1 or v2, /recommendation/ answers in when the Accept header does not ask for one")
+	validationReport := flag.String("validationReport", "", "write the json report of the records loaded and rejected from dataLocation to this file")
+	relations := flag.String("relations", "", "the datasets of the other relation types, comma separated type=location pairs like similar=s3://bucket/similar,viewedTogether=/data/viewed. the types are similar, viewedTogether and repurchased, asked for with ?types=. they are kept in compact form with -compact, and are index files compiled with -buildIndex with -indexFile")
+	popularLocation := flag.String("popularLocation", "", "serve the top sellers and trending lists of this directory or s3://bucket/prefix location, written by the order tool with -popular, on /topsellers/ and /trending/")
+	rulesFile := flag.String("rulesFile", "", "json file of the business rules filtering the items served, the popularity lists included: the products excluded, the caps per brand or category, the boosts and the pins. ?debug=true reports what they did. it can not be used with -prerender")
+	rulesReload := flag.Duration("rulesReload", 30*time.Second, "how often -rulesFile is checked for changes and reloaded, 0 loads it once")
+	memoryReport := flag.Bool("memoryReport", false, "report the memory used by the map and the compact form of dataLocation, or of a synthetic dataset, and exit")
+	flag.Parse()
+	glog.V(2).Infof("data dir is %s \n", *dataDir)
+	if *defaultSchema != api.SCHEMA_V1 && *defaultSchema != api.SCHEMA_V2 {
+		glog.Fatalf("unknown schema %s, expecting v1 or v2\n", *defaultSchema)
+	}
+	options := serveOptions{maxAge: *cacheMaxAge, grpcAddress: *grpcAddress, defaultSchema: *defaultSchema, validationReport: *validationReport, popularLocation: *popularLocation}
+	if *relations != "" && !*memoryReport && *buildIndex == "" {
+		backend := store.RELATIONS_MAP
+		if *indexFile != "" {
+			backend = store.RELATIONS_INDEX
+		} else if *compact {
+			backend = store.RELATIONS_COMPACT
+		}
+		var err error
+		if options.relations, err = store.GetRelations(*relations, backend); err != nil {
+			glog.Fatalf("failed to load the relations %s\n", err.Error())
 		}
+		defer options.relations.Close()
 	}
-	//recommendation:=convertToRecommendation(prod)
-
-	w.Header().Set(HTTP_HEADER_CONTENT_TYPE, HTTP_HEADER_VALUE_JSON)
-	prod = filterResult(prod)
-	json.NewEncoder(w).Encode(prod)
-	glog.V(3).Infof("served %s %+v", r.URL.Path, prod)
-	glog.V(2).Infof("served %s", r.URL.Path)
-
-}
-
-func filterResult(prod Product) Product {
-	if len(prod.BoughtTogetherItems) > 0 {
-		min := math.Min(10, (float64(len(prod.BoughtTogetherItems))))
-		filtered := prod.BoughtTogetherItems[0:int(min)]
-		prod.BoughtTogetherItems = filtered
+	if *rulesFile != "" && (*prerender || *prerenderGzip) {
+		glog.Fatalf("-prerender encodes the responses once at load time, it can not serve the rules of -rulesFile which may be reloaded\n")
 	}
-	return prod
-}
-
-//get the productId from the url path, for instance /recommendation/prod123 will return prod123
-func GetProductId(r *http.Request) string {
-	p := strings.Split(r.URL.Path, "/")
-	length := len(p)
-	if length >= 1 {
-		return p[length-1]
+	if *rulesFile != "" && !*memoryReport && *buildIndex == "" {
+		var err error
+		if options.rules, err = rules.Open(*rulesFile); err != nil {
+			glog.Fatalf("failed to load the rules %s %s\n", *rulesFile, err.Error())
+		}
+		glog.Infof("loaded the rules %s, version %s", *rulesFile, options.rules.Rules().Version)
+		if *rulesReload > 0 {
+			options.rules.Watch(*rulesReload)
+		}
+	}
+	if *memoryReport {
+		reportMemory(*dataDir)
+	} else if *buildIndex != "" {
+		report, err := store.BuildProductIndex(*dataDir, *buildIndex)
+		if err != nil {
+			glog.Fatalf("failed to build the index %s %s\n", *buildIndex, err.Error())
+		}
+		options.writeReport(report)
+	} else if *indexFile != "" {
+		serveFromIndex(*indexFile, options)
+	} else if *compact {
+		serveCompact(*dataDir, options)
+	} else if !*useDynamoDb {
+		serveFromS3(*dataDir, *prerender || *prerenderGzip, *prerenderGzip, options)
 	} else {
-		return ""
+		serveFromDynamoDb(options)
 	}
 }
 
-//get the RelatedProducts from the data directory. the data file name following the pattern of "part([\d]+)"
-
-func GetRelatedProducts(dataDir string) RelatedProducts {
-	results := RelatedProducts{Relates: make(map[string]Product)}
-	fileInfos, error := ioutil.ReadDir(dataDir)
-	if error != nil {
-		glog.Fatalf("failed to read the data directory %s \n", dataDir)
-	}
-	for _, fileInfo := range fileInfos {
-		if strings.Contains(fileInfo.Name(), ".crc") {
-			continue
-		} else if strings.Contains(fileInfo.Name(), "part-") {
-			contents, error := ioutil.ReadFile(dataDir + "/" + fileInfo.Name())
-			if error != nil {
-				glog.Fatalf("failed to load data file %s %s\n", dataDir+"/"+fileInfo.Name(), error.Error())
-			}
-			strContent := string(contents)
-			parts := strings.Split(strContent, "\n")
-			for _, part := range parts {
-				//get the json which starts from the first { and ends at the last )
-				start := strings.Index(part, "{")
-				end := strings.LastIndex(part, ")")
-				if start < 0 || end >= len(part) {
-					continue
-				}
-				jsonStr := part[start:end]
-				var rp Product
-				error := json.Unmarshal([]byte(jsonStr), &rp)
-				if error != nil {
-					glog.Errorf("failed to unmarshal json %s %s\n", jsonStr, error.Error())
-				} else {
-					results.Relates[rp.ProductID] = rp
-				}
-			}
-		}
-	}
-	return results
+//the flags shared by every backend
+type serveOptions struct {
+	maxAge           time.Duration
+	grpcAddress      string
+	defaultSchema    string
+	validationReport string
+	popularLocation  string
+	relations        *store.Relations
+	rules            *rules.File
 }
 
-func GetRelatedProductsFromS3(svc *s3.S3, dataDir string) RelatedProducts {
-	results := RelatedProducts{Relates: make(map[string]Product)}
-	bucket, keypattern := parseS3Params(dataDir)
-	params := &s3.ListObjectsInput{
-		Bucket: aws.String("ecomm-order-items"), // Required
-		//Delimiter:    aws.String("Delimiter"),
-		//EncodingType: aws.String("EncodingType"),
-		//Marker:       aws.String("Marker"),
-		//MaxKeys:      aws.Int64(1),
-		//Prefix:       aws.String("Prefix"),
+//write the load report to the -validationReport file, if any
+func (options serveOptions) writeReport(report *loader.LoadReport) {
+	if options.validationReport == "" {
+		return
+	}
+	contents, err := json.MarshalIndent(report, "", "  ")
+	if err == nil {
+		err = ioutil.WriteFile(options.validationReport, contents, 0644)
 	}
-	resp, err := svc.ListObjects(params)
-
 	if err != nil {
-		// Print the error, cast err to awserr.Error to get the Code and
-		// Message from an error.
-
-		glog.Errorf("%s, %s \n", err.(awserr.Error).Code(), err.(awserr.Error).Error())
-
+		glog.Errorf("failed to write the validation report %s %s\n", options.validationReport, err.Error())
 	}
+}
 
-	// Pretty-print the response data.
-	//fmt.Println(resp)
-	for _, obj := range resp.Contents {
-		glog.V(2).Infof("s3 object: %s. Keypattern:%s", *obj.Key, keypattern)
-		if strings.HasPrefix(*obj.Key, keypattern[1:]+"/part-") {
-			glog.V(2).Infof("populating with file %s", *obj.Key)
-			populateRelatedProducts(&results, getObject(svc, bucket, *obj.Key))
+//serve handler, or the plain ProductHandler of source when nil, on port 8080 and source over grpc. the
+//popularity lists of -popularLocation are served next to it
+func (options serveOptions) listen(source store.ProductSource, handler http.Handler, version string, modified time.Time) {
+	grpcServer, err := api.StartGrpc(options.grpcAddress, source, options.relations, options.rules)
+	if err != nil {
+		glog.Fatal(err)
+	}
+	if handler == nil {
+		productHandler := api.NewProductHandler(source, options.defaultSchema)
+		productHandler.Relations = options.relations
+		productHandler.Rules = options.rules
+		handler = productHandler
+	}
+	if options.relations != nil && version != "" {
+		version += "." + options.relations.Version
+		if options.relations.Modified.After(modified) {
+			modified = options.relations.Modified
 		}
 	}
-
-	return results
-
-}
-
-//populate relatedProducts by parsing out the strContent which should the content from part-00000[\d] files
-func populateRelatedProducts(relatedProducts *RelatedProducts, strContent string) {
-	parts := strings.Split(strContent, "\n")
-	glog.V(2).Infof("number of results: %d", len(parts))
-	for _, part := range parts {
-		//get the json which starts from the first { and ends at the last )
-		start := strings.Index(part, "{")
-		end := strings.LastIndex(part, ")")
-		if start < 0 || end >= len(part) {
-			continue
+	mux := http.NewServeMux()
+	caching := api.NewCachingHandler(handler, version, modified, options.maxAge)
+	caching.Rules = options.rules
+	api.RegisterRoutes(mux, caching)
+	if options.popularLocation != "" {
+		lists, report, err := loader.LoadPopularLists(options.popularLocation)
+		if err != nil {
+			glog.Fatalf("failed to load the popularity lists %s %s\n", options.popularLocation, err.Error())
 		}
-		jsonStr := part[start:end]
-
-		var rp Product
-		error := json.Unmarshal([]byte(jsonStr), &rp)
-		if error != nil {
-			glog.Errorf("failed to unmarshal json %s %s\n", jsonStr, error.Error())
-		} else {
-			relatedProducts.Relates[rp.ProductID] = rp
+		glog.Infof("serving %d popularity lists of %s", len(lists), options.popularLocation)
+		popularHandler := api.NewPopularHandler(lists)
+		popularHandler.Rules = options.rules
+		popularCaching := api.NewCachingHandler(popularHandler, report.Version, datasetModified(report), options.maxAge)
+		popularCaching.Rules = options.rules
+		api.RegisterPopularRoutes(mux, popularCaching)
+	}
+	server := &http.Server{Addr: ":8080", Handler: mux}
+	stopped := make(chan struct{})
+	go stopOnSignal(server, grpcServer, stopped)
+	if err := server.ListenAndServe(); err != http.ErrServerClosed {
+		glog.Fatal(err)
+	}
+	<-stopped
+}
+
+//on SIGINT or SIGTERM stop taking requests and let the ones in flight finish, over http and grpc, for up to
+//SHUTDOWN_TIMEOUT, then close stopped
+func stopOnSignal(server *http.Server, grpcServer *grpc.Server, stopped chan struct{}) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	sig := <-signals
+	glog.Infof("received %s, shutting down", sig)
+	ctx, cancel := context.WithTimeout(context.Background(), SHUTDOWN_TIMEOUT)
+	defer cancel()
+	grpcStopped := make(chan struct{})
+	go func() {
+		if grpcServer != nil {
+			grpcServer.GracefulStop()
+		}
+		close(grpcStopped)
+	}()
+	if err := server.Shutdown(ctx); err != nil {
+		glog.Errorf("failed to shut down the http service gracefully %s\n", err.Error())
+	}
+	select {
+	case <-grpcStopped:
+	case <-ctx.Done():
+		glog.Errorf("failed to shut down the grpc service gracefully %s\n", ctx.Err().Error())
+		if grpcServer != nil {
+			grpcServer.Stop()
 		}
 	}
+	close(stopped)
 }
 
-//get object from s3
-func getObject(svc *s3.S3, bucket string, key string) string {
-	params := &s3.GetObjectInput{
-		Bucket: aws.String(bucket), // Required
-		Key:    aws.String(key),    // Required
-	}
-	resp, err := svc.GetObject(params)
+func serveFromDynamoDb(options serveOptions) {
+	svc := dynamodb.New(session.New(), &aws.Config{Region: aws.String("us-east-1")})
+	glog.Infof("data source is pointing to dynamo db. servic ready on port 8080")
+	options.listen(store.NewDynamoDbStore(svc), nil, "", time.Time{})
+}
 
+func serveFromIndex(indexFile string, options serveOptions) {
+	index, err := store.OpenProductIndex(indexFile)
 	if err != nil {
-		// Print the error, cast err to awserr.Error to get the Code and
-		// Message from an error.
-		glog.Fatal(err.Error())
+		glog.Fatalf("failed to open the index %s %s\n", indexFile, err.Error())
 	}
-
-	size := int(*resp.ContentLength)
-
-	buffer := make([]byte, size)
-	defer resp.Body.Close()
-	var bbuffer bytes.Buffer
-	for true {
-		num, rerr := resp.Body.Read(buffer)
-		if num > 0 {
-			bbuffer.Write(buffer[:num])
-		} else if rerr == io.EOF || rerr != nil {
-			break
-		}
+	defer index.Close()
+	modified := time.Now()
+	if info, err := os.Stat(indexFile); err == nil {
+		modified = info.ModTime()
 	}
-	return bbuffer.String()
+	glog.Infof("data source is the index %s with %d products. servic ready on port 8080", indexFile, index.Len())
+	options.listen(index, nil, index.Version(), modified)
 }
 
-//parse s3://ecomm-order-items/recommendations/output.txt to return {ecomm-order-items,recommendations/output.txt}
-func parseS3Params(in string) (string, string) {
-	if strings.HasPrefix(in, "s3://") {
-		params := in[len("s3://"):]
-		parts := strings.Split(params, "/")
-		return parts[0], params[len(parts[0]):]
+func serveCompact(dataLocation string, options serveOptions) {
+	compact, err := store.GetCompactProducts(dataLocation)
+	if err != nil {
+		glog.Fatalf("failed to load the compact products %s %s\n", dataLocation, err.Error())
 	}
-	return "", ""
+	options.writeReport(compact.Report)
+	glog.Infof("serving %d products in compact form. servic ready on port 8080", compact.Len())
+	options.listen(compact, nil, compact.Version, datasetModified(compact.Report))
 }
 
-func GetItemFromDynamoDb(svc *dynamodb.DynamoDB, productId string) string {
-	params := &dynamodb.GetItemInput{
-		Key: map[string]*dynamodb.AttributeValue{ // Required
-			"productId": { // Required
-				S: aws.String(productId),
-			},
-			// More values...
-		},
-		TableName: aws.String("ProductRecommendation"), // Required
-		AttributesToGet: []*string{
-			aws.String("boughtTogether"), // Required
-			// More values...
-		},
-		ConsistentRead: aws.Bool(true),
-		/*
-			ExpressionAttributeNames: map[string]*string{
-				"Key": aws.String("AttributeName"), // Required
-				// More values...
-			},
-			ProjectionExpression:   aws.String("ProjectionExpression"),
-			ReturnConsumedCapacity: aws.String("ReturnConsumedCapacity"),
-		*/
-	}
-	resp, err := svc.GetItem(params)
-
+func serveFromS3(s3Location string, prerender bool, gzipped bool, options serveOptions) {
+	relatedProducts, err := store.GetRelatedProducts(s3Location)
 	if err != nil {
-		// Print the error, cast err to awserr.Error to get the Code and
-		// Message from an error.
-		glog.Errorln(err.Error())
-		return "failed to query DynamoDb"
+		glog.Fatalf("failed to load the products %s %s\n", s3Location, err.Error())
 	}
+	options.writeReport(relatedProducts.Report)
 
-	// Pretty-print the response data.
-	return *resp.Item["boughtTogether"].S
-}
-
-func main() {
-	dataDir := flag.String("dataLocation", "", "")
-	useDynamoDb := flag.Bool("useDynamoDb", false, "dynamodb indicator")
-	flag.Parse()
-	glog.V(2).Infof("data dir is %s \n", *dataDir)
-	if !*useDynamoDb {
-		serveFromS3(*dataDir)
-	} else {
-		serveFromDynamoDb()
+	var myHandler http.Handler
+	if prerender {
+		myHandler = api.NewPrerenderedProducts(relatedProducts, options.relations, gzipped, options.defaultSchema)
 	}
+	glog.Infof("servic ready on port 8080")
+	options.listen(relatedProducts, myHandler, relatedProducts.Version, datasetModified(relatedProducts.Report))
 }
 
-func serveFromDynamoDb() {
-	svc := dynamodb.New(session.New(), &aws.Config{Region: aws.String("us-east-1")})
-	handler := &DynamoDbHandler{svc: svc}
-	mux := http.NewServeMux()
-	mux.Handle("/recommendation/", handler)
-	glog.Infof("data source is pointing to dynamo db. servic ready on port 8080")
-	glog.Fatal(http.ListenAndServe(":8080", mux))
-}
-
-func serveFromS3(s3Location string) {
-	var relatedProducts RelatedProducts
-
-	if strings.HasPrefix(s3Location, "s3://") {
-		svc := s3.New(session.New(), &aws.Config{Region: aws.String("us-east-1")})
-		relatedProducts = GetRelatedProductsFromS3(svc, s3Location)
-	} else {
-		relatedProducts = GetRelatedProducts(s3Location)
+//the Last-Modified of a dataset, the time its latest part file was modified, or now when it has none
+func datasetModified(report *loader.LoadReport) time.Time {
+	if report.Modified.IsZero() {
+		return time.Now()
 	}
-
-	mux := http.NewServeMux()
-	myHandler := &relatedProducts
-	mux.Handle("/recommendation/", myHandler)
-	glog.Infof("servic ready on port 8080")
-	glog.Fatal(http.ListenAndServe(":8080", mux))
+	return report.Modified
 }
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"github.com/golang/glog"
+	"runtime"
+	"strconv"
+	"strings"
+	"urbn.com/recengine/loader"
+	"urbn.com/recengine/model"
+	"urbn.com/recengine/store"
+)
+
+//the synthetic dataset used by the memory report when no data location is given, sized after the
+//production catalog: every product has a full list of bought together items scored in a handful of states
+const (
+	SYNTHETIC_PRODUCTS      = 200000
+	SYNTHETIC_ITEMS         = 20
+	SYNTHETIC_REGIONS       = 8
+	SYNTHETIC_STATE_REGIONS = "AL,AK,AZ,AR,CA,CO,CT,DE,FL,GA,HI,ID,IL,IN,IA,KS,KY,LA,ME,MD,MA,MI,MN,MS,MO,MT,NE,NV,NH,NJ,NM,NY,NC,ND,OH,OK,OR,PA,RI,SC,SD,TN,TX,UT,VT,VA,WA,WV,WI,WY"
+)
+
+//compare the heap used by the map and the compact representation of the same dataset
+func reportMemory(dataLocation string) {
+	load := func(add func(model.Product)) {
+		if dataLocation == "" {
+			loadSyntheticProducts(add)
+		} else if _, err := loader.LoadProducts(dataLocation, add); err != nil {
+			glog.Fatalf("failed to load the products %s %s\n", dataLocation, err.Error())
+		}
+	}
+
+	base := heapInUse()
+	relates := store.NewRelatedProducts()
+	load(relates.Add)
+	mapBytes := heapInUse() - base
+	count := len(relates.Relates)
+	relates = nil //release the map before measuring the compact form
+
+	base = heapInUse()
+	compact := store.NewCompactProducts()
+	var addErr error
+	load(func(prod model.Product) {
+		if addErr == nil {
+			addErr = compact.Add(prod)
+		}
+	})
+	if addErr != nil {
+		glog.Fatalf("failed to build the compact form %s\n", addErr.Error())
+	}
+	compact.Trim()
+	compactBytes := heapInUse() - base
+	runtime.KeepAlive(compact)
+
+	fmt.Printf("products:        %d\n", count)
+	fmt.Printf("map:             %d bytes\n", mapBytes)
+	fmt.Printf("compact:         %d bytes\n", compactBytes)
+	if compactBytes > 0 {
+		fmt.Printf("reduction:       %.1fx\n", float64(mapBytes)/float64(compactBytes))
+	}
+}
+
+func heapInUse() int64 {
+	var stats runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&stats)
+	return int64(stats.HeapAlloc)
+}
+
+//generate the synthetic dataset, ids and region strings are allocated per product the way json decoding does
+func loadSyntheticProducts(add func(model.Product)) {
+	states := strings.Split(SYNTHETIC_STATE_REGIONS, ",")
+	for i := 0; i < SYNTHETIC_PRODUCTS; i++ {
+		prod := model.Product{ProductID: strconv.Itoa(30000000 + i)}
+		for j := 0; j < SYNTHETIC_ITEMS; j++ {
+			item := model.BoughtTogetherItem{ProductID: strconv.Itoa(30000000 + (i*7919+j*104729)%SYNTHETIC_PRODUCTS)}
+			for k := 0; k < SYNTHETIC_REGIONS; k++ {
+				score := SYNTHETIC_ITEMS - j + k
+				item.ScoreByRegion = append(item.ScoreByRegion, model.RegionScore{Region: string([]byte(states[(i+j+k)%len(states)])), Score: score})
+				item.TotalScore += score
+			}
+			prod.BoughtTogetherItems = append(prod.BoughtTogetherItems, item)
+		}
+		add(prod)
+	}
+}